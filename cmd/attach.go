@@ -0,0 +1,257 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/cobra"
+
+	"github.com/dmage/boater/pkg/client"
+	"github.com/dmage/boater/pkg/manifests"
+)
+
+const annotationTitle = "org.opencontainers.image.title"
+
+// emptyJSON is the content of the empty config blob.
+var emptyJSON = []byte("{}")
+
+// parseAttachFile parses <file>[:<media-type>].
+func parseAttachFile(s string) (string, string) {
+	if i := strings.LastIndexByte(s, ':'); i > 0 && strings.Contains(s[i+1:], "/") {
+		return s[:i], s[i+1:]
+	}
+	return s, attachOpts.FileType
+}
+
+// uploadFile uploads the file as a blob and returns its layer descriptor.
+func uploadFile(c *client.Client, path string, mediaType string) (manifests.LayerDescriptor, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return manifests.LayerDescriptor{}, err
+	}
+	defer f.Close()
+
+	digester := digest.Canonical.Digester()
+	size, err := io.Copy(digester.Hash(), f)
+	if err != nil {
+		return manifests.LayerDescriptor{}, fmt.Errorf("%s: %s", path, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return manifests.LayerDescriptor{}, fmt.Errorf("%s: %s", path, err)
+	}
+
+	dgst := digester.Digest()
+	if err := putBlob(c, dgst, f, size); err != nil {
+		return manifests.LayerDescriptor{}, fmt.Errorf("upload %s: %s", path, err)
+	}
+
+	return manifests.LayerDescriptor{
+		Descriptor: manifests.Descriptor{
+			MediaType: mediaType,
+			Size:      size,
+			Digest:    dgst,
+			Annotations: map[string]string{
+				annotationTitle: filepath.Base(path),
+			},
+		},
+	}, nil
+}
+
+// updateReferrersIndex adds the manifest desc to the index that is tagged
+// using the referrers tag schema for the manifest subject.
+func updateReferrersIndex(c *client.Client, subject digest.Digest, desc manifests.ManifestDescriptor) error {
+	index, err := c.ReferrersIndex(subject)
+	if err != nil {
+		return err
+	}
+	if index == nil {
+		index = &manifests.ManifestList{
+			SchemaVersion: 2,
+			MediaType:     manifests.MediaTypeOCIIndex,
+			Manifests:     []manifests.ManifestDescriptor{},
+		}
+	}
+	for _, md := range index.Manifests {
+		if md.Digest == desc.Digest {
+			return nil
+		}
+	}
+	index.Manifests = append(index.Manifests, desc)
+
+	data, err := manifests.MarshalJSON(index)
+	if err != nil {
+		return err
+	}
+	_, err = putManifest(c, client.ReferrersTag(subject), manifests.MediaTypeOCIIndex, data)
+	return err
+}
+
+// putEmptyConfig uploads the empty config blob and returns its descriptor.
+func putEmptyConfig(c *client.Client) (manifests.ConfigDescriptor, error) {
+	dgst := digest.FromBytes(emptyJSON)
+	if err := putBlob(c, dgst, bytes.NewReader(emptyJSON), int64(len(emptyJSON))); err != nil {
+		return manifests.ConfigDescriptor{}, err
+	}
+	return manifests.ConfigDescriptor{
+		Descriptor: manifests.Descriptor{
+			MediaType: manifests.MediaTypeOCIEmpty,
+			Size:      int64(len(emptyJSON)),
+			Digest:    dgst,
+		},
+	}, nil
+}
+
+// pushReferrer pushes the OCI manifest m with subject as its subject and
+// returns its digest. If the registry does not support the referrers API, the
+// manifest is also added to the referrers index of the subject.
+func pushReferrer(c *client.Client, subject *manifestBlob, m manifests.Schema2) (digest.Digest, error) {
+	m.Subject = &manifests.Descriptor{
+		MediaType: subject.MediaType,
+		Size:      int64(len(subject.Data)),
+		Digest:    subject.Digest,
+	}
+	data, err := manifests.MarshalJSON(m)
+	if err != nil {
+		return "", err
+	}
+	dgst := digest.FromBytes(data)
+
+	resp, err := c.PutManifest(dgst.String(), m.MediaType, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", readResponseError(resp)
+	}
+
+	// Registries that support the referrers API confirm the subject with
+	// the OCI-Subject header.
+	if resp.Header.Get("OCI-Subject") != "" {
+		return dgst, nil
+	}
+	artifactType := m.ArtifactType
+	if artifactType == "" {
+		artifactType = m.Config.MediaType
+	}
+	err = updateReferrersIndex(c, subject.Digest, manifests.ManifestDescriptor{
+		Descriptor: manifests.Descriptor{
+			MediaType:    m.MediaType,
+			Size:         int64(len(data)),
+			Digest:       dgst,
+			ArtifactType: artifactType,
+			Annotations:  m.Annotations,
+		},
+	})
+	return dgst, err
+}
+
+var attachOpts struct {
+	ArtifactType string
+	FileType     string
+	Annotations  []string
+}
+
+var attachCmd = &cobra.Command{
+	Use:   "attach <name>[:<tag>|@<digest>] --artifact-type <type> <file>[:<media-type>]...",
+	Short: "Attach files to a manifest as an OCI artifact",
+	Long: `Attach files to a manifest as an OCI artifact.
+
+Uploads the files as blobs and pushes an OCI image manifest that has the
+manifest as its subject. The digest of the new manifest is printed.
+
+If the registry does not support the referrers API, the artifact is also added
+to the index that is tagged using the referrers tag schema (sha256-<hex>).
+
+Examples:
+  # Attach an SBOM to the image.
+  boater attach example.com/app:v1 --artifact-type application/spdx+json ./sbom.spdx.json
+
+  # Attach test reports with explicit media types.
+  boater attach example.com/app:v1 --artifact-type application/vnd.example.test-report \
+    ./junit.xml:application/xml ./coverage.txt:text/plain
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 || attachOpts.ArtifactType == "" {
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		annotations := map[string]string{}
+		for _, a := range attachOpts.Annotations {
+			key, value, err := parseKeyValue(a)
+			if err != nil {
+				log.Fatal(err)
+			}
+			annotations[key] = value
+		}
+
+		c := newClient(args[0], []string{"pull", "push"})
+
+		subject, err := fetchManifest(c, manifestName(c.Named()))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		config, err := putEmptyConfig(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var layers []manifests.LayerDescriptor
+		for _, arg := range args[1:] {
+			path, mediaType := parseAttachFile(arg)
+			ld, err := uploadFile(c, path, mediaType)
+			if err != nil {
+				log.Fatal(err)
+			}
+			layers = append(layers, ld)
+		}
+
+		m := manifests.Schema2{
+			SchemaVersion: 2,
+			MediaType:     manifests.MediaTypeOCIManifest,
+			ArtifactType:  attachOpts.ArtifactType,
+			Config:        config,
+			Layers:        layers,
+		}
+		if len(annotations) > 0 {
+			m.Annotations = annotations
+		}
+		dgst, err := pushReferrer(c, subject, m)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println(dgst)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(attachCmd)
+
+	attachCmd.Flags().StringVar(&attachOpts.ArtifactType, "artifact-type", "", "the artifact type of the attached files (required)")
+	attachCmd.Flags().StringVar(&attachOpts.FileType, "file-type", "application/octet-stream", "the media type of files without an explicit media type")
+	attachCmd.Flags().StringArrayVarP(&attachOpts.Annotations, "annotation", "a", nil, "add the annotation to the artifact manifest (KEY=VALUE)")
+}
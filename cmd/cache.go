@@ -0,0 +1,111 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dmage/boater/pkg/cache"
+	"github.com/dmage/boater/pkg/manifests"
+)
+
+// parseSize parses a size like 500M or 10G (with binary suffixes K, M, G and
+// T).
+func parseSize(s string) (int64, error) {
+	value := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	multiplier := int64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier != 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: expected a number of bytes with an optional suffix K, M, G or T", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the local blob cache",
+	Long: `Manage the local blob cache.
+
+When --cache or --cache-dir is set, manifests and blobs that are fetched by
+digest are stored in the cache directory after their digests are verified, and
+later requests for them are served from the cache without touching the network.
+Manifests fetched by tags are cached as well, but the tags are revalidated with
+conditional requests.
+`,
+}
+
+var cachePruneOpts struct {
+	MaxSize string
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune --max-size <size>",
+	Short: "Remove the least recently used blobs from the cache",
+	Long: `Remove the least recently used blobs from the cache until its size does not
+exceed the specified size.
+
+Examples:
+  # Keep at most 10 GiB of blobs.
+  boater cache prune --max-size 10G
+
+  # Empty the cache.
+  boater cache prune --max-size 0
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 || !cmd.Flags().Changed("max-size") {
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		maxSize, err := parseSize(cachePruneOpts.MaxSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		result, err := cache.New(cacheDir()).Prune(maxSize)
+		fmt.Printf("removed %d blobs, freed %s, %s remaining\n", result.Removed, manifests.HumanSize(result.Freed), manifests.HumanSize(result.Remaining))
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+
+	cachePruneCmd.Flags().StringVar(&cachePruneOpts.MaxSize, "max-size", "", "the maximum total size of the cached blobs (e.g. 500M, 10G)")
+}
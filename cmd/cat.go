@@ -0,0 +1,212 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dmage/boater/pkg/client"
+	"github.com/dmage/boater/pkg/layer"
+	"github.com/dmage/boater/pkg/manifests"
+)
+
+const maxSymlinks = 40
+
+var errFileNotFound = errors.New("no such file")
+
+// lookupResult is the outcome of scanning a single layer for a path.
+type lookupResult int
+
+const (
+	// lookupContinue means that the lower layers should be scanned.
+	lookupContinue lookupResult = iota
+
+	// lookupFound means that the file contents have been written.
+	lookupFound
+
+	// lookupNotFound means that the path is deleted or shadowed by this
+	// layer.
+	lookupNotFound
+
+	// lookupRedirect means that the path or one of its parents is a link and
+	// the lookup should be restarted with a new path.
+	lookupRedirect
+)
+
+func resolveSymlink(name string, target string) string {
+	if path.IsAbs(target) {
+		return layer.Clean(target)
+	}
+	return layer.Clean(path.Join(path.Dir(name), target))
+}
+
+// lookupLayer scans the layer r for name. Parents of name that are known to
+// be directories in upper layers are listed in dirs; parents that are
+// directories in this layer are added to it.
+func lookupLayer(r io.Reader, name string, dirs map[string]bool, w io.Writer) (lookupResult, string, error) {
+	deleted := false
+	var layerDirs []string
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return lookupContinue, "", err
+		}
+
+		if wh, ok := layer.ParseWhiteout(hdr.Name); ok {
+			if wh.Name == "" {
+				if wh.Dir != name && layer.IsUnder(name, wh.Dir) {
+					deleted = true
+				}
+			} else if layer.IsUnder(name, wh.Path()) {
+				deleted = true
+			}
+			continue
+		}
+
+		entryName := layer.Clean(hdr.Name)
+		if entryName == name {
+			switch hdr.Typeflag {
+			case tar.TypeReg:
+				if _, err := io.Copy(w, tr); err != nil {
+					return lookupContinue, "", err
+				}
+				return lookupFound, "", nil
+			case tar.TypeSymlink:
+				return lookupRedirect, resolveSymlink(entryName, hdr.Linkname), nil
+			case tar.TypeLink:
+				return lookupRedirect, layer.Clean(hdr.Linkname), nil
+			case tar.TypeDir:
+				return lookupNotFound, "", fmt.Errorf("/%s is a directory", name)
+			default:
+				return lookupNotFound, "", fmt.Errorf("/%s is not a regular file", name)
+			}
+		}
+
+		if entryName != "" && layer.IsUnder(name, entryName) && !dirs[entryName] {
+			switch hdr.Typeflag {
+			case tar.TypeDir:
+				layerDirs = append(layerDirs, entryName)
+			case tar.TypeSymlink:
+				rest := strings.TrimPrefix(name, entryName+"/")
+				return lookupRedirect, layer.Clean(path.Join(resolveSymlink(entryName, hdr.Linkname), rest)), nil
+			default:
+				return lookupNotFound, "", nil
+			}
+		}
+	}
+
+	if deleted {
+		return lookupNotFound, "", nil
+	}
+	for _, dir := range layerDirs {
+		dirs[dir] = true
+	}
+	return lookupContinue, "", nil
+}
+
+// catFile writes the contents of the file name into w. The layers are
+// scanned from the top down, so the scan stops as soon as the top-most
+// version of the file is found.
+func catFile(c *client.Client, layers []manifests.LayerDescriptor, name string, w io.Writer) error {
+	name = layer.Clean(name)
+	for hops := 0; hops <= maxSymlinks; hops++ {
+		dirs := make(map[string]bool)
+		redirected := false
+		for i := len(layers) - 1; i >= 0 && !redirected; i-- {
+			r, err := openLayer(c, layers[i])
+			if err != nil {
+				return err
+			}
+			result, target, err := lookupLayer(r, name, dirs, w)
+			if closeErr := r.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fmt.Errorf("layer %s: %w", layers[i].Digest, err)
+			}
+
+			switch result {
+			case lookupFound:
+				return nil
+			case lookupNotFound:
+				return fmt.Errorf("/%s: %w", name, errFileNotFound)
+			case lookupRedirect:
+				name = target
+				redirected = true
+			}
+		}
+		if !redirected {
+			return fmt.Errorf("/%s: %w", name, errFileNotFound)
+		}
+	}
+	return fmt.Errorf("/%s: too many levels of symbolic links", name)
+}
+
+var catOpts struct {
+	Platform string
+}
+
+var catCmd = &cobra.Command{
+	Use:   "cat <name>[:<tag>|@<digest>] <path>",
+	Short: "Print a file from an image",
+	Long: `Print a file from an image.
+
+Looks for the file in the layers of the image from the top down and prints the
+contents of the top-most version of the file. Layers below it are not
+downloaded. The layer with the file is read to the end, so that its digest is
+verified. Symbolic links are followed.
+
+Examples:
+  # Print the OS release of the image.
+  boater cat ubuntu /etc/os-release
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		c := newClient(args[0], []string{"pull"})
+
+		img, err := fetchImage(c, manifestName(c.Named()), catOpts.Platform)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := catFile(c, img.Schema2.Layers, args[1], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(catCmd)
+
+	catCmd.Flags().StringVar(&catOpts.Platform, "platform", "linux/amd64", "use the manifest for the specified platform (os/arch[/variant]) from manifest lists")
+}
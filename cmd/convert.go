@@ -0,0 +1,514 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/cobra"
+
+	"github.com/dmage/boater/pkg/client"
+	"github.com/dmage/boater/pkg/layer"
+	"github.com/dmage/boater/pkg/manifests"
+)
+
+// v1Image is the part of a v1Compatibility entry that is needed to build the
+// history of the image.
+type v1Image struct {
+	ID              string    `json:"id"`
+	Parent          string    `json:"parent"`
+	Created         time.Time `json:"created"`
+	Author          string    `json:"author"`
+	Comment         string    `json:"comment"`
+	ContainerConfig struct {
+		Cmd []string `json:"Cmd"`
+	} `json:"container_config"`
+	ThrowAway bool `json:"throwaway"`
+}
+
+// v1OnlyFields are the fields of v1Compatibility entries that are not part of
+// image configs.
+var v1OnlyFields = []string{"id", "parent", "Size", "parent_id", "layer_id", "throwaway"}
+
+// convertedImage is an image manifest and its config that are not pushed yet.
+type convertedImage struct {
+	MediaType string
+	Manifest  []byte
+	Config    []byte
+}
+
+// inspectLayerBlob downloads the layer dgst and returns its size, compression
+// and the digest of its uncompressed content.
+func inspectLayerBlob(c *client.Client, dgst digest.Digest) (int64, layer.Compression, digest.Digest, error) {
+	resp, err := c.GetBlob(dgst.String())
+	if err != nil {
+		return 0, layer.Uncompressed, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, layer.Uncompressed, "", readResponseError(resp)
+	}
+
+	counter := &countingWriter{}
+	br := bufio.NewReader(newVerifyingReader(io.TeeReader(resp.Body, counter), dgst))
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return 0, layer.Uncompressed, "", fmt.Errorf("layer %s: %w", dgst, err)
+	}
+	compression := layer.DetectCompression(magic)
+
+	r, err := layer.Decompress(br, "")
+	if err != nil {
+		return 0, compression, "", fmt.Errorf("layer %s: %w", dgst, err)
+	}
+	defer r.Close()
+	digester := digest.Canonical.Digester()
+	if _, err := io.Copy(digester.Hash(), r); err != nil {
+		return 0, compression, "", fmt.Errorf("layer %s: %w", dgst, err)
+	}
+
+	// Read the rest of the blob, so that its size and digest are checked.
+	if _, err := io.Copy(ioutil.Discard, br); err != nil {
+		return 0, compression, "", fmt.Errorf("layer %s: %w", dgst, err)
+	}
+
+	return counter.n, compression, digester.Digest(), nil
+}
+
+// dedupeSchema1 removes consecutive entries with the same v1 ID, which some
+// old registries produce for retagged images.
+func dedupeSchema1(m *manifests.Schema1, images []v1Image) []v1Image {
+	for i := len(images) - 2; i >= 0; i-- {
+		if images[i].ID != images[i+1].ID {
+			continue
+		}
+		images = append(images[:i+1], images[i+2:]...)
+		m.FSLayers = append(m.FSLayers[:i+1], m.FSLayers[i+2:]...)
+		m.History = append(m.History[:i+1], m.History[i+2:]...)
+	}
+	return images
+}
+
+// convertSchema1 builds an image manifest of the type manifestType and its
+// config from the schema 1 manifest data. Layers that are marked as
+// throwaway are dropped and recorded as empty layers in the history.
+func convertSchema1(c *client.Client, data []byte, manifestType string) (*convertedImage, error) {
+	var m manifests.Schema1
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("decode schema 1 manifest: %w", err)
+	}
+	if len(m.History) == 0 {
+		return nil, fmt.Errorf("schema 1 manifest has no history")
+	}
+	if len(m.History) != len(m.FSLayers) {
+		return nil, fmt.Errorf("schema 1 manifest has %d history entries and %d layers", len(m.History), len(m.FSLayers))
+	}
+
+	images := make([]v1Image, len(m.History))
+	for i, h := range m.History {
+		if err := json.Unmarshal([]byte(h.V1Compatibility), &images[i]); err != nil {
+			return nil, fmt.Errorf("decode v1Compatibility %d: %w", i, err)
+		}
+	}
+	images = dedupeSchema1(&m, images)
+
+	configType := manifests.MediaTypeImageConfig
+	if manifestType == manifests.MediaTypeOCIManifest {
+		configType = manifests.MediaTypeOCIImageConfig
+	}
+
+	var rootfs manifests.RootFS
+	rootfs.Type = "layers"
+	rootfs.DiffIDs = []string{}
+	var history []manifests.History
+	var layers []manifests.LayerDescriptor
+
+	// Schema 1 manifests list layers from the top to the base.
+	for i := len(images) - 1; i >= 0; i-- {
+		img := images[i]
+		history = append(history, manifests.History{
+			Created:    img.Created,
+			Author:     img.Author,
+			CreatedBy:  strings.Join(img.ContainerConfig.Cmd, " "),
+			Comment:    img.Comment,
+			EmptyLayer: img.ThrowAway,
+		})
+		if img.ThrowAway {
+			continue
+		}
+
+		dgst, err := digest.Parse(m.FSLayers[i].BlobSum)
+		if err != nil {
+			return nil, fmt.Errorf("layer %d: %w", i, err)
+		}
+		size, compression, diffID, err := inspectLayerBlob(c, dgst)
+		if err != nil {
+			return nil, err
+		}
+		mediaType, err := layerMediaType(manifestType, compression)
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", dgst, err)
+		}
+		layers = append(layers, manifests.LayerDescriptor{
+			Descriptor: manifests.Descriptor{
+				MediaType: mediaType,
+				Size:      size,
+				Digest:    dgst,
+			},
+		})
+		rootfs.DiffIDs = append(rootfs.DiffIDs, diffID.String())
+	}
+
+	var config manifests.Object
+	if err := json.Unmarshal([]byte(m.History[0].V1Compatibility), &config); err != nil {
+		return nil, fmt.Errorf("decode v1Compatibility: %w", err)
+	}
+	for _, key := range v1OnlyFields {
+		config.Delete(key)
+	}
+	if err := config.Set("rootfs", rootfs); err != nil {
+		return nil, err
+	}
+	if err := config.Set("history", history); err != nil {
+		return nil, err
+	}
+	configData, err := manifests.MarshalJSON(config)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := manifests.Schema2{
+		SchemaVersion: 2,
+		MediaType:     manifestType,
+		Config: manifests.ConfigDescriptor{
+			Descriptor: manifests.Descriptor{
+				MediaType: configType,
+				Size:      int64(len(configData)),
+				Digest:    digest.FromBytes(configData),
+			},
+		},
+		Layers: layers,
+	}
+	manifestData, err := manifests.MarshalJSON(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &convertedImage{
+		MediaType: manifestType,
+		Manifest:  manifestData,
+		Config:    configData,
+	}, nil
+}
+
+// pushConvertedImage copies the layers of img from src to dst, uploads its
+// config and pushes the manifest as name.
+func pushConvertedImage(src, dst *client.Client, img *convertedImage, name string) (digest.Digest, error) {
+	var manifest manifests.Schema2
+	if err := json.Unmarshal(img.Manifest, &manifest); err != nil {
+		return "", fmt.Errorf("decode manifest: %w", err)
+	}
+	for _, ld := range manifest.Layers {
+		if err := copyBlob(src, dst, ld.Descriptor); err != nil {
+			return "", err
+		}
+	}
+	if err := putBlob(dst, manifest.Config.Digest, bytes.NewReader(img.Config), manifest.Config.Size); err != nil {
+		return "", err
+	}
+	return putManifest(dst, name, img.MediaType, img.Manifest)
+}
+
+func printIndentedJSON(data []byte) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := buf.WriteTo(os.Stdout)
+	return err
+}
+
+// convertDescriptor rewrites the media type of desc for the OCI or Docker
+// format. Docker manifests cannot have annotations, so they are dropped.
+func convertDescriptor(desc manifests.Descriptor, oci bool) (manifests.Descriptor, error) {
+	if oci {
+		desc.MediaType = manifests.OCIMediaType(desc.MediaType)
+		return desc, nil
+	}
+	mediaType, ok := manifests.DockerMediaType(desc.MediaType)
+	if !ok {
+		return desc, fmt.Errorf("%s (%s) cannot be converted to the Docker format", desc.Digest, desc.MediaType)
+	}
+	desc.MediaType = mediaType
+	desc.ArtifactType = ""
+	desc.Annotations = nil
+	return desc, nil
+}
+
+// convertFormat converts the image manifest or index m to the OCI or Docker
+// format. Unless dst is nil, the blobs and the converted children of indexes
+// are pushed to dst, but the converted manifest itself is not. If nothing
+// needs to be changed, m is returned.
+func convertFormat(src, dst *client.Client, m *manifestBlob, oci bool) (*manifestBlob, error) {
+	var obj manifests.Object
+	if err := json.Unmarshal(m.Data, &obj); err != nil {
+		return nil, fmt.Errorf("decode manifest %s: %w", m.Digest, err)
+	}
+
+	md, err := convertDescriptor(manifests.Descriptor{MediaType: m.MediaType, Digest: m.Digest}, oci)
+	if err != nil {
+		return nil, err
+	}
+	changed := md.MediaType != m.MediaType
+	if err := obj.Set("mediaType", md.MediaType); err != nil {
+		return nil, err
+	}
+	if !oci {
+		for _, key := range []string{"artifactType", "subject", "annotations"} {
+			if obj.Delete(key) {
+				changed = true
+			}
+		}
+	}
+
+	switch {
+	case manifests.IsImageManifest(m.MediaType):
+		var manifest manifests.Schema2
+		if err := json.Unmarshal(m.Data, &manifest); err != nil {
+			return nil, fmt.Errorf("decode manifest %s: %w", m.Digest, err)
+		}
+
+		config, err := convertDescriptor(manifest.Config.Descriptor, oci)
+		if err != nil {
+			return nil, fmt.Errorf("config of %s: %w", m.Digest, err)
+		}
+		changed = changed || !reflect.DeepEqual(config, manifest.Config.Descriptor)
+		if err := obj.Set("config", manifests.ConfigDescriptor{Descriptor: config}); err != nil {
+			return nil, err
+		}
+
+		layers := make([]manifests.LayerDescriptor, len(manifest.Layers))
+		for i, ld := range manifest.Layers {
+			desc, err := convertDescriptor(ld.Descriptor, oci)
+			if err != nil {
+				return nil, fmt.Errorf("layer of %s: %w", m.Digest, err)
+			}
+			changed = changed || !reflect.DeepEqual(desc, ld.Descriptor)
+			layers[i] = manifests.LayerDescriptor{
+				Descriptor: desc,
+				URLs:       ld.URLs,
+			}
+		}
+		if err := obj.Set("layers", layers); err != nil {
+			return nil, err
+		}
+
+		if dst != nil {
+			if err := copyBlob(src, dst, manifest.Config.Descriptor); err != nil {
+				return nil, fmt.Errorf("copy config %s: %w", manifest.Config.Digest, err)
+			}
+			for _, ld := range manifest.Layers {
+				if len(ld.URLs) > 0 {
+					// Foreign layers are not stored in the registry.
+					continue
+				}
+				if err := copyBlob(src, dst, ld.Descriptor); err != nil {
+					return nil, fmt.Errorf("copy layer %s: %w", ld.Digest, err)
+				}
+			}
+		}
+	case manifests.IsIndex(m.MediaType):
+		var list manifests.ManifestList
+		if err := json.Unmarshal(m.Data, &list); err != nil {
+			return nil, fmt.Errorf("decode manifest %s: %w", m.Digest, err)
+		}
+
+		children := make([]manifests.ManifestDescriptor, len(list.Manifests))
+		for i, md := range list.Manifests {
+			child, err := fetchManifest(src, md.Digest.String())
+			if err != nil {
+				return nil, err
+			}
+			converted, err := convertFormat(src, dst, child, oci)
+			if err != nil {
+				return nil, err
+			}
+			if dst != nil {
+				if _, err := putManifest(dst, converted.Digest.String(), converted.MediaType, converted.Data); err != nil {
+					return nil, err
+				}
+			}
+
+			desc, err := convertDescriptor(md.Descriptor, oci)
+			if err != nil {
+				return nil, err
+			}
+			desc.MediaType = converted.MediaType
+			desc.Digest = converted.Digest
+			desc.Size = int64(len(converted.Data))
+			changed = changed || !reflect.DeepEqual(desc, md.Descriptor)
+			children[i] = manifests.ManifestDescriptor{
+				Descriptor: desc,
+				Platform:   md.Platform,
+			}
+		}
+		if err := obj.Set("manifests", children); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("manifest %s: unsupported manifest type: %s", m.Digest, m.MediaType)
+	}
+
+	if !changed {
+		return m, nil
+	}
+	data, err := manifests.MarshalJSON(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &manifestBlob{
+		MediaType: md.MediaType,
+		Digest:    digest.FromBytes(data),
+		Data:      data,
+	}, nil
+}
+
+var convertOpts struct {
+	To     string
+	DryRun bool
+}
+
+var convertCmd = &cobra.Command{
+	Use:   "convert <src>[:<tag>|@<digest>] <dst>[:<tag>] --to docker|oci",
+	Short: "Convert an image to the Docker or OCI format",
+	Long: `Convert an image to the Docker or OCI format.
+
+Schema 1 images are converted by building an image config from the
+v1Compatibility history of the manifest, dropping throwaway layers and
+computing diff IDs by decompressing the layers.
+
+For image manifests, manifest lists and OCI indexes, media types of manifests,
+configs and layers are rewritten. Blobs are not changed, foreign layers keep
+their URLs. Annotations are kept in the OCI format and dropped in the Docker
+format, which does not support them. Manifests of indexes are converted and
+pushed recursively.
+
+The converted image is pushed to dst. With --dry-run, the converted manifest
+(and the config for schema 1 images) is printed instead. Schema 1 layers are
+still downloaded to compute their diff IDs.
+
+Examples:
+  # Convert a legacy image in place.
+  boater convert example.com/app:v1 example.com/app:v1 --to docker
+
+  # Make an OCI copy of a multi-arch image.
+  boater convert example.com/app:v1 example.com/app:v1-oci --to oci
+
+  # Show the OCI manifest and config without pushing them.
+  boater convert example.com/app:v1 example.com/app:v1-oci --to oci --dry-run
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		var oci bool
+		switch convertOpts.To {
+		case "schema2", "docker":
+		case "oci":
+			oci = true
+		default:
+			log.Fatalf("unsupported format %q (expected docker, schema2 or oci)", convertOpts.To)
+		}
+
+		src := newClient(args[0], []string{"pull"})
+		m, err := fetchManifest(src, manifestName(src.Named()))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if manifests.IsSchema1(m.MediaType) {
+			manifestType := manifests.MediaTypeSchema2
+			if oci {
+				manifestType = manifests.MediaTypeOCIManifest
+			}
+			img, err := convertSchema1(src, m.Data, manifestType)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if convertOpts.DryRun {
+				if err := printIndentedJSON(img.Manifest); err != nil {
+					log.Fatal(err)
+				}
+				if err := printIndentedJSON(img.Config); err != nil {
+					log.Fatal(err)
+				}
+				return
+			}
+
+			dst := newClient(args[1], []string{"pull", "push"})
+			dgst, err := pushConvertedImage(src, dst, img, manifestName(dst.Named()))
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(dgst)
+			return
+		}
+
+		if convertOpts.DryRun {
+			converted, err := convertFormat(src, nil, m, oci)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := printIndentedJSON(converted.Data); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		dst := newClient(args[1], []string{"pull", "push"})
+		converted, err := convertFormat(src, dst, m, oci)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dgst, err := putManifest(dst, manifestName(dst.Named()), converted.MediaType, converted.Data)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(dgst)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(convertCmd)
+
+	convertCmd.Flags().StringVar(&convertOpts.To, "to", "", "the format of the converted image (docker, schema2 or oci)")
+	convertCmd.Flags().BoolVar(&convertOpts.DryRun, "dry-run", false, "print the converted manifest and config without pushing them")
+}
@@ -0,0 +1,329 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dmage/boater/pkg/client"
+	"github.com/dmage/boater/pkg/manifests"
+)
+
+// platformFromConfig returns the platform of the image described by the
+// config.
+func platformFromConfig(config manifests.ImageConfig) *manifests.PlatformSpec {
+	return &manifests.PlatformSpec{
+		Architecture: config.Architecture,
+		OS:           config.OS,
+		OSVersion:    config.OSVersion,
+		OSFeatures:   config.OSFeatures,
+		Variant:      config.Variant,
+	}
+}
+
+// indexChildren returns the descriptors for the manifest m. If m is an index,
+// descriptors for all its children are returned.
+func indexChildren(c *client.Client, m *manifestBlob) ([]manifests.ManifestDescriptor, error) {
+	if manifests.IsIndex(m.MediaType) {
+		var list manifests.ManifestList
+		if err := json.Unmarshal(m.Data, &list); err != nil {
+			return nil, fmt.Errorf("decode manifest %s: %w", m.Digest, err)
+		}
+		return list.Manifests, nil
+	}
+
+	config, err := fetchImageConfig(c, m)
+	if err != nil {
+		return nil, err
+	}
+	return []manifests.ManifestDescriptor{
+		{
+			Descriptor: manifests.Descriptor{
+				MediaType: m.MediaType,
+				Size:      int64(len(m.Data)),
+				Digest:    m.Digest,
+			},
+			Platform: platformFromConfig(config),
+		},
+	}, nil
+}
+
+// copyManifest makes sure that the manifest m from the repository of src and
+// the blobs that it references exist in the repository of dst. Children of
+// indexes are copied recursively.
+func copyManifest(src, dst *client.Client, m *manifestBlob) error {
+	if sameRepository(src, dst) {
+		return nil
+	}
+
+	resp, err := dst.GetManifest(m.Digest.String(), client.GetManifestOptions{
+		MediaTypes: []string{m.MediaType},
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	switch {
+	case manifests.IsIndex(m.MediaType):
+		var list manifests.ManifestList
+		if err := json.Unmarshal(m.Data, &list); err != nil {
+			return fmt.Errorf("decode manifest %s: %w", m.Digest, err)
+		}
+		for _, md := range list.Manifests {
+			child, err := fetchManifest(src, md.Digest.String())
+			if err != nil {
+				return err
+			}
+			if err := copyManifest(src, dst, child); err != nil {
+				return err
+			}
+		}
+	case manifests.IsImageManifest(m.MediaType):
+		var manifest manifests.Schema2
+		if err := json.Unmarshal(m.Data, &manifest); err != nil {
+			return fmt.Errorf("decode manifest %s: %w", m.Digest, err)
+		}
+		if err := copyBlob(src, dst, manifest.Config.Descriptor); err != nil {
+			return fmt.Errorf("copy config %s: %w", manifest.Config.Digest, err)
+		}
+		for _, ld := range manifest.Layers {
+			if len(ld.URLs) > 0 {
+				// Foreign layers are not stored in the registry.
+				continue
+			}
+			if err := copyBlob(src, dst, ld.Descriptor); err != nil {
+				return fmt.Errorf("copy layer %s: %w", ld.Digest, err)
+			}
+		}
+	default:
+		return fmt.Errorf("manifest %s: unsupported manifest type: %s", m.Digest, m.MediaType)
+	}
+
+	_, err = putManifest(dst, m.Digest.String(), m.MediaType, m.Data)
+	return err
+}
+
+// referenceTypeAnnotation marks manifests that are not images for a platform,
+// but describe another manifest in the index, e.g. buildx attestations.
+const referenceTypeAnnotation = "vnd.docker.reference.type"
+
+// addToIndex adds md to the list, replacing the manifest with the same
+// platform. Manifests without a real platform, such as attestations, are
+// never replaced.
+func addToIndex(list []manifests.ManifestDescriptor, md manifests.ManifestDescriptor) []manifests.ManifestDescriptor {
+	if !hasPlatform(md) {
+		return append(list, md)
+	}
+	for i, old := range list {
+		if hasPlatform(old) && samePlatform(*old.Platform, *md.Platform) {
+			list[i] = md
+			return list
+		}
+	}
+	return append(list, md)
+}
+
+func hasPlatform(md manifests.ManifestDescriptor) bool {
+	if md.Platform == nil {
+		return false
+	}
+	if md.Platform.OS == "unknown" && md.Platform.Architecture == "unknown" {
+		return false
+	}
+	_, ok := md.Annotations[referenceTypeAnnotation]
+	return !ok
+}
+
+func samePlatform(a, b manifests.PlatformSpec) bool {
+	return a.OS == b.OS && a.Architecture == b.Architecture && a.Variant == b.Variant && a.OSVersion == b.OSVersion && sameStrings(a.OSFeatures, b.OSFeatures)
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var createIndexOpts struct {
+	Format      string
+	Annotations []string
+	Amend       bool
+	Remove      []string
+}
+
+var createIndexCmd = &cobra.Command{
+	Use:   "create-index <dst-name>[:<tag>] <child-name>[:<tag>|@<digest>]...",
+	Short: "Create a manifest list or an OCI index",
+	Long: `Create a manifest list or an OCI index.
+
+Resolves the digest, the size and the media type of each child manifest, reads
+its platform from the image config and pushes a manifest list (--format
+docker) or an OCI index (--format oci) that references the children. If a
+child is an index itself, all its manifests are added.
+
+Children may be located in other repositories, in which case they are copied
+into the destination repository.
+
+With --amend, the children are added to the existing index, replacing the
+manifests for the same platforms. Manifests without a platform, such as
+attestations, are kept as is. Manifests for platforms that are passed to
+--remove are removed from the index.
+
+Examples:
+  # Stitch together images that were built on different machines.
+  boater create-index example.com/app:v1 example.com/app:v1-amd64 example.com/app:v1-arm64
+
+  # Add the s390x image to the existing index and drop arm/v6.
+  boater create-index example.com/app:v1 example.com/app:v1-s390x --amend --remove linux/arm/v6
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		var mediaType string
+		switch createIndexOpts.Format {
+		case "docker":
+			mediaType = manifests.MediaTypeManifestList
+		case "oci":
+			mediaType = manifests.MediaTypeOCIIndex
+		default:
+			log.Fatalf("invalid --format: %q (expected docker or oci)", createIndexOpts.Format)
+		}
+
+		annotations := make(map[string]string)
+		for _, a := range createIndexOpts.Annotations {
+			key, value, err := parseKeyValue(a)
+			if err != nil {
+				log.Fatal(err)
+			}
+			annotations[key] = value
+		}
+
+		var remove []manifests.PlatformSpec
+		for _, p := range createIndexOpts.Remove {
+			platform, err := parsePlatform(p)
+			if err != nil {
+				log.Fatal(err)
+			}
+			remove = append(remove, platform)
+		}
+
+		dst := newClient(args[0], []string{"pull", "push"})
+		dstName := manifestName(dst.Named())
+
+		index := manifests.ManifestList{
+			SchemaVersion: 2,
+		}
+		if createIndexOpts.Amend {
+			m, err := fetchManifest(dst, dstName)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !manifests.IsIndex(m.MediaType) {
+				log.Fatalf("%s is not an index: %s", args[0], m.MediaType)
+			}
+			if err := json.Unmarshal(m.Data, &index); err != nil {
+				log.Fatalf("decode manifest %s: %s", m.Digest, err)
+			}
+			if !cmd.Flags().Changed("format") {
+				mediaType = m.MediaType
+			}
+		}
+		if len(annotations) > 0 && mediaType != manifests.MediaTypeOCIIndex {
+			log.Fatal("annotations are supported only by OCI indexes")
+		}
+		index.MediaType = mediaType
+		if len(annotations) > 0 && index.Annotations == nil {
+			index.Annotations = make(map[string]string)
+		}
+		for key, value := range annotations {
+			index.Annotations[key] = value
+		}
+
+		for _, ref := range args[1:] {
+			src := newClient(ref, []string{"pull"})
+			m, err := fetchManifest(src, manifestName(src.Named()))
+			if err != nil {
+				log.Fatal(err)
+			}
+			children, err := indexChildren(src, m)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := copyManifest(src, dst, m); err != nil {
+				log.Fatalf("copy %s: %s", ref, err)
+			}
+			for _, md := range children {
+				index.Manifests = addToIndex(index.Manifests, md)
+			}
+		}
+
+		var manifestDescriptors []manifests.ManifestDescriptor
+		for _, md := range index.Manifests {
+			removed := false
+			for _, platform := range remove {
+				if md.Platform != nil && platformMatches(*md.Platform, platform) {
+					removed = true
+					break
+				}
+			}
+			if !removed {
+				manifestDescriptors = append(manifestDescriptors, md)
+			}
+		}
+		index.Manifests = manifestDescriptors
+		if index.Manifests == nil {
+			index.Manifests = []manifests.ManifestDescriptor{}
+		}
+
+		data, err := manifests.MarshalJSON(index)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		dgst, err := putManifest(dst, dstName, mediaType, data)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(dgst)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(createIndexCmd)
+
+	createIndexCmd.Flags().StringVar(&createIndexOpts.Format, "format", "docker", "create a manifest list (docker) or an OCI index (oci)")
+	createIndexCmd.Flags().StringArrayVarP(&createIndexOpts.Annotations, "annotation", "a", nil, "add the annotation to the OCI index (KEY=VALUE)")
+	createIndexCmd.Flags().BoolVar(&createIndexOpts.Amend, "amend", false, "add manifests to the existing index")
+	createIndexCmd.Flags().StringArrayVar(&createIndexOpts.Remove, "remove", nil, "remove manifests for the platform (os/arch[/variant]) from the index")
+}
@@ -0,0 +1,252 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/client/auth"
+	"k8s.io/kubernetes/pkg/credentialprovider"
+
+	"github.com/dmage/boater/pkg/client"
+	"github.com/dmage/boater/pkg/netrc"
+	"github.com/dmage/boater/pkg/pullsecret"
+)
+
+func getPassword() (string, bool) {
+	if rootCmdPassword != "" {
+		return rootCmdPassword, true
+	}
+
+	if rootCmdPasswordFile != "" {
+		password, err := ioutil.ReadFile(rootCmdPasswordFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return strings.TrimRight(string(password), "\r\n"), true
+	}
+
+	return "", false
+}
+
+func getCredentialsFromConfigJson(configJsonFile string, ref string) ([]credentialprovider.AuthConfig, error) {
+	f, err := os.Open(configJsonFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var dockerConfigJSON credentialprovider.DockerConfigJSON
+	err = json.NewDecoder(f).Decode(&dockerConfigJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	basicKeyring := &credentialprovider.BasicDockerKeyring{}
+	basicKeyring.Add(dockerConfigJSON.Auths)
+	creds, _ := basicKeyring.Lookup(ref)
+	return creds, nil
+}
+
+func getCredentialsFromPullSecrets(pullSecrets []string, ref string) ([]credentialprovider.AuthConfig, error) {
+	basicKeyring := &credentialprovider.BasicDockerKeyring{}
+	for _, pullSecret := range pullSecrets {
+		configs, err := pullsecret.Load(pullSecret)
+		if err != nil {
+			return nil, err
+		}
+		for _, config := range configs {
+			basicKeyring.Add(config)
+		}
+	}
+	creds, _ := basicKeyring.Lookup(ref)
+	return creds, nil
+}
+
+// getCredentialsFromAuthConfig returns the credentials for ref from
+// BOATER_AUTH_CONFIG, which has the format of config.json.
+func getCredentialsFromAuthConfig(authConfig string, ref string) ([]credentialprovider.AuthConfig, error) {
+	var dockerConfigJSON credentialprovider.DockerConfigJSON
+	if err := json.Unmarshal([]byte(authConfig), &dockerConfigJSON); err != nil {
+		return nil, err
+	}
+
+	basicKeyring := &credentialprovider.BasicDockerKeyring{}
+	basicKeyring.Add(dockerConfigJSON.Auths)
+	creds, _ := basicKeyring.Lookup(ref)
+	return creds, nil
+}
+
+// hostAuthVariable returns the name of the environment variable with the
+// credentials for the registry host, e.g. BOATER_AUTH_REGISTRY_EXAMPLE_COM_5000
+// for registry.example.com:5000.
+func hostAuthVariable(host string) string {
+	normalized := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, host)
+	return "BOATER_AUTH_" + normalized
+}
+
+// credential is a candidate for authorization and the description of where
+// it was found.
+type credential struct {
+	Source string
+	*client.BasicCredentials
+}
+
+// credentialCandidates returns the credentials for ref in the order they
+// should be tried. Credentials from the command line arguments are used
+// exclusively. Otherwise, the candidates are all matching credentials from
+// pull secrets, config.json, BOATER_AUTH_<host>,
+// BOATER_USERNAME/BOATER_PASSWORD, BOATER_AUTH_CONFIG and .netrc, in this
+// order. If there are several candidates, they are tried in turn and then
+// anonymous access, as kubelet does.
+func credentialCandidates(ref string) []credential {
+	password, havePassword := getPassword()
+	if rootCmdUser != "" || havePassword {
+		if rootCmdVerbose {
+			log.Printf("Using credentials provided by command line arguments: %s:%s", rootCmdUser, redactPassword(password))
+		}
+		return []credential{{
+			Source: "command line arguments",
+			BasicCredentials: &client.BasicCredentials{
+				Username: rootCmdUser,
+				Password: password,
+			},
+		}}
+	}
+
+	var candidates []credential
+	add := func(source string, username, password string) {
+		if rootCmdVerbose {
+			log.Printf("Found credentials in %s: %s:%s", source, username, redactPassword(password))
+		}
+		candidates = append(candidates, credential{
+			Source: source,
+			BasicCredentials: &client.BasicCredentials{
+				Username: username,
+				Password: password,
+			},
+		})
+	}
+	addAuthConfigs := func(source string, creds []credentialprovider.AuthConfig) {
+		for _, c := range creds {
+			add(source, c.Username, c.Password)
+		}
+	}
+
+	if len(rootCmdPullSecrets) > 0 {
+		if rootCmdVerbose {
+			log.Printf("Loading credentials from %s...", strings.Join(rootCmdPullSecrets, ", "))
+		}
+		creds, err := getCredentialsFromPullSecrets(rootCmdPullSecrets, ref)
+		if err != nil {
+			log.Fatalf("unable to load pull secrets: %s", err)
+		}
+		addAuthConfigs("pull secrets", creds)
+	}
+
+	if rootCmdConfigJson != "" {
+		if rootCmdVerbose {
+			log.Printf("Loading credentials from %s...", rootCmdConfigJson)
+		}
+		creds, err := getCredentialsFromConfigJson(rootCmdConfigJson, ref)
+		if err != nil {
+			log.Fatalf("unable to load credentials from %s: %s", rootCmdConfigJson, err)
+		}
+		addAuthConfigs(rootCmdConfigJson, creds)
+	}
+
+	host := ""
+	if named, err := reference.ParseNormalizedNamed(ref); err == nil {
+		host = reference.Domain(named)
+	}
+
+	if host != "" {
+		name := hostAuthVariable(host)
+		if value, ok := os.LookupEnv(name); ok {
+			i := strings.IndexByte(value, ':')
+			if i < 0 {
+				log.Fatalf("invalid value of %s: expected user:password", name)
+			}
+			add(name, value[:i], value[i+1:])
+		}
+	}
+
+	username, haveUsername := os.LookupEnv("BOATER_USERNAME")
+	password, havePassword = os.LookupEnv("BOATER_PASSWORD")
+	if haveUsername || havePassword {
+		add("BOATER_USERNAME/BOATER_PASSWORD", username, password)
+	}
+
+	if authConfig := os.Getenv("BOATER_AUTH_CONFIG"); authConfig != "" {
+		creds, err := getCredentialsFromAuthConfig(authConfig, ref)
+		if err != nil {
+			log.Fatalf("unable to parse BOATER_AUTH_CONFIG: %s", err)
+		}
+		addAuthConfigs("BOATER_AUTH_CONFIG", creds)
+	}
+
+	if host != "" {
+		if path, err := netrc.DefaultPath(); err == nil {
+			machines, err := netrc.Load(path)
+			if err != nil && !os.IsNotExist(err) {
+				log.Fatalf("unable to load credentials from %s: %s", path, err)
+			}
+			if m, ok := netrc.Lookup(machines, host); ok {
+				add(path, m.Login, m.Password)
+			}
+		}
+	}
+
+	if len(candidates) == 0 && rootCmdVerbose {
+		log.Println("No credentials are found, proceeding as anonymous...")
+	}
+
+	return candidates
+}
+
+// newCredentialStore returns the first candidate for ref, or nil if there
+// are no credentials for it.
+func newCredentialStore(ref string) auth.CredentialStore {
+	candidates := credentialCandidates(ref)
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[0].BasicCredentials
+}
+
+// showCredentialSource reports which credentials are used for the registry
+// domain, without the password.
+func showCredentialSource(domain string, c *credential) {
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "Credentials for %s: none, anonymous access\n", domain)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Credentials for %s: %s (username %q)\n", domain, c.Source, c.Username)
+}
@@ -0,0 +1,358 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"archive/tar"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dmage/boater/pkg/client"
+	"github.com/dmage/boater/pkg/layer"
+	"github.com/dmage/boater/pkg/manifests"
+	"github.com/dmage/boater/pkg/printer"
+)
+
+var reWhitespace = regexp.MustCompile(`\s+`)
+
+type diffOp int
+
+const (
+	diffShared diffOp = iota
+	diffRemoved
+	diffAdded
+)
+
+type diffLine struct {
+	Op    diffOp
+	Value string
+}
+
+// diffStrings computes the longest common subsequence of a and b and returns
+// the edit script that transforms a into b.
+func diffStrings(a, b []string) []diffLine {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, diffLine{diffShared, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{diffRemoved, a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{diffAdded, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, diffLine{diffRemoved, a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, diffLine{diffAdded, b[j]})
+	}
+	return lines
+}
+
+// printDiff prints lines and returns true if there are any changes. Shared
+// lines are printed only if withShared is true.
+func printDiff(prefix string, lines []diffLine, withShared bool) bool {
+	changed := false
+	for _, line := range lines {
+		switch line.Op {
+		case diffShared:
+			if withShared {
+				printer.Valueln(prefix+"  ", line.Value)
+			}
+		case diffRemoved:
+			printer.Removedln(prefix, "- "+line.Value)
+			changed = true
+		case diffAdded:
+			printer.Addedln(prefix, "+ "+line.Value)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func hasChanges(lines []diffLine) bool {
+	for _, line := range lines {
+		if line.Op != diffShared {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func labelsList(labels map[string]string) []string {
+	var list []string
+	for k, v := range labels {
+		list = append(list, k+"="+v)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func sortedStrings(list []string) []string {
+	list = append([]string(nil), list...)
+	sort.Strings(list)
+	return list
+}
+
+func quoteList(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	return []string{fmt.Sprintf("%q", list)}
+}
+
+func layersList(layers []manifests.LayerDescriptor) []string {
+	var list []string
+	for _, l := range layers {
+		list = append(list, l.Digest.String())
+	}
+	return list
+}
+
+func historyList(history []manifests.History) []string {
+	var list []string
+	for _, h := range history {
+		s := reWhitespace.ReplaceAllString(strings.TrimSpace(h.CreatedBy), " ")
+		if h.EmptyLayer {
+			s += " (empty layer)"
+		}
+		list = append(list, s)
+	}
+	return list
+}
+
+func diffConfigs(prefix string, a, b manifests.ContainerConfig) bool {
+	fields := []struct {
+		Name string
+		A, B []string
+	}{
+		{"User", nonEmpty(a.User), nonEmpty(b.User)},
+		{"WorkingDir", nonEmpty(a.WorkingDir), nonEmpty(b.WorkingDir)},
+		{"Env", sortedStrings(a.Env), sortedStrings(b.Env)},
+		{"Entrypoint", quoteList(a.Entrypoint), quoteList(b.Entrypoint)},
+		{"Cmd", quoteList(a.Cmd), quoteList(b.Cmd)},
+		{"Labels", labelsList(a.Labels), labelsList(b.Labels)},
+		{"ExposedPorts", sortedKeys(a.ExposedPorts), sortedKeys(b.ExposedPorts)},
+	}
+
+	changed := false
+	for _, field := range fields {
+		lines := diffStrings(field.A, field.B)
+		if !hasChanges(lines) {
+			continue
+		}
+		printer.Keyln(prefix, field.Name)
+		printDiff(prefix+"  ", lines, false)
+		changed = true
+	}
+	return changed
+}
+
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+// imageFS applies all layers of img and returns the resulting file system.
+func imageFS(c *client.Client, img *image) (*layer.FS, error) {
+	fs := layer.NewFS()
+	fs.HashContents = true
+	for _, ld := range img.Schema2.Layers {
+		r, err := openLayer(c, ld)
+		if err != nil {
+			return nil, err
+		}
+		err = fs.Apply(r)
+		if closeErr := r.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", ld.Digest, err)
+		}
+	}
+	return fs, nil
+}
+
+func entryModified(a, b *layer.Entry) bool {
+	ha, hb := a.Header, b.Header
+	return ha.Typeflag != hb.Typeflag ||
+		ha.Mode != hb.Mode ||
+		ha.Uid != hb.Uid ||
+		ha.Gid != hb.Gid ||
+		ha.Linkname != hb.Linkname ||
+		(ha.Typeflag == tar.TypeReg && (ha.Size != hb.Size || a.Digest != b.Digest))
+}
+
+func diffFiles(prefix string, a, b *layer.FS) bool {
+	changed := false
+	entriesA := a.Entries()
+	entriesB := b.Entries()
+	i, j := 0, 0
+	for i < len(entriesA) || j < len(entriesB) {
+		switch {
+		case j == len(entriesB) || (i < len(entriesA) && entriesA[i].Name < entriesB[j].Name):
+			printer.Removedln(prefix, "- /"+entriesA[i].Name)
+			changed = true
+			i++
+		case i == len(entriesA) || entriesB[j].Name < entriesA[i].Name:
+			printer.Addedln(prefix, "+ /"+entriesB[j].Name)
+			changed = true
+			j++
+		default:
+			if entryModified(entriesA[i], entriesB[j]) {
+				printer.Valueln(prefix, "~ /"+entriesB[j].Name)
+				changed = true
+			}
+			i++
+			j++
+		}
+	}
+	return changed
+}
+
+var diffOpts struct {
+	Platform string
+	Files    bool
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <name>[:<tag>|@<digest>] <name>[:<tag>|@<digest>]",
+	Short: "Compare two images",
+	Long: `Compare two images.
+
+Compares the manifests and the configs of two images and prints added (+),
+removed (-) and shared layers, changes in the container config and in the
+history. With --files, the layers of both images are downloaded and the
+flattened file systems are compared: added (+), removed (-) and modified (~)
+paths are printed.
+
+The command exits with status 1 if the images differ.
+
+NOTE: Output of this command is intended for humans, so it is not guaranteed to
+be backward-compatible.
+
+Examples:
+  # Compare two releases of busybox.
+  boater diff busybox:1.34 busybox:1.35
+
+  # Show which files were changed by a base image bump.
+  boater diff --files example.com/app:v1 example.com/app:v2
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ca := newClient(args[0], []string{"pull"})
+		cb := newClient(args[1], []string{"pull"})
+
+		imgA, err := fetchImage(ca, manifestName(ca.Named()), diffOpts.Platform)
+		if err != nil {
+			log.Fatal(err)
+		}
+		imgB, err := fetchImage(cb, manifestName(cb.Named()), diffOpts.Platform)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		printer.Removedln("", fmt.Sprintf("--- %s@%s", ca.Named().Name(), imgA.Manifest.Digest))
+		printer.Addedln("", fmt.Sprintf("+++ %s@%s", cb.Named().Name(), imgB.Manifest.Digest))
+
+		changed := false
+
+		printer.Keyln("", "layers")
+		if printDiff("  ", diffStrings(layersList(imgA.Schema2.Layers), layersList(imgB.Schema2.Layers)), true) {
+			changed = true
+		}
+
+		printer.Keyln("", "config")
+		if diffConfigs("  ", imgA.Config.Config, imgB.Config.Config) {
+			changed = true
+		}
+
+		printer.Keyln("", "history")
+		if printDiff("  ", diffStrings(historyList(imgA.Config.History), historyList(imgB.Config.History)), true) {
+			changed = true
+		}
+
+		if diffOpts.Files {
+			fsA, err := imageFS(ca, imgA)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fsB, err := imageFS(cb, imgB)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			printer.Keyln("", "files")
+			if diffFiles("  ", fsA, fsB) {
+				changed = true
+			}
+		}
+
+		if changed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVar(&diffOpts.Platform, "platform", "linux/amd64", "use the manifest for the specified platform (os/arch[/variant]) from manifest lists")
+	diffCmd.Flags().BoolVar(&diffOpts.Files, "files", false, "compare file systems of the images")
+}
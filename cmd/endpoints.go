@@ -0,0 +1,56 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/dmage/boater/pkg/client"
+)
+
+var (
+	resolverOnce sync.Once
+	resolver     *client.Resolver
+)
+
+// endpointResolver returns the resolver for the base URLs of registries from
+// the configuration file and --endpoint flags. The flags take precedence.
+func endpointResolver() *client.Resolver {
+	resolverOnce.Do(func() {
+		resolver = &client.Resolver{}
+		if c := configFile(); c != nil {
+			for name, r := range c.Registries {
+				if r.Endpoint == "" {
+					continue
+				}
+				if err := resolver.Add(name, r.Endpoint); err != nil {
+					log.Fatalf("invalid endpoint for %s in the configuration file: %s", name, err)
+				}
+			}
+		}
+		for _, e := range rootCmdEndpoints {
+			i := strings.IndexByte(e, '=')
+			if i <= 0 {
+				log.Fatalf("invalid value for --endpoint: %q (expected registry=url)", e)
+			}
+			if err := resolver.Add(e[:i], e[i+1:]); err != nil {
+				log.Fatalf("invalid value for --endpoint: %s", err)
+			}
+		}
+	})
+	return resolver
+}
@@ -0,0 +1,222 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dmage/boater/pkg/client"
+	"github.com/dmage/boater/pkg/layer"
+)
+
+func exportDir(c *client.Client, img *image, dir string) error {
+	extractor := &layer.Extractor{
+		Dir:   dir,
+		Chown: os.Geteuid() == 0,
+	}
+	for _, ld := range img.Schema2.Layers {
+		r, err := openLayer(c, ld)
+		if err != nil {
+			return err
+		}
+		err = extractor.Apply(r)
+		if closeErr := r.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("layer %s: %w", ld.Digest, err)
+		}
+	}
+	return nil
+}
+
+func openLocalLayer(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	r, err := layer.Decompress(f, "")
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return layerReader{
+		ReadCloser: r,
+		body:       f,
+	}, nil
+}
+
+// writeFlattenedLayer writes entries from the layer index that are visible in
+// fs into tw.
+func writeFlattenedLayer(tw *tar.Writer, fs *layer.FS, index int, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entry, ok := fs.Get(hdr.Name)
+		if !ok || entry.Layer != index || entry.Index != i {
+			continue
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// exportTar writes the flattened file system of img as a tarball into w.
+//
+// Layers are downloaded into a temporary directory, as they are read twice:
+// first to find out which entries are visible in the flattened file system,
+// and then to write these entries.
+func exportTar(c *client.Client, img *image, w io.Writer) error {
+	tmpdir, err := ioutil.TempDir("", "boater-export-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	var filenames []string
+	for i, ld := range img.Schema2.Layers {
+		filename := filepath.Join(tmpdir, fmt.Sprintf("layer%d", i))
+		f, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		err = downloadBlob(c, ld.Digest, f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("layer %s: %w", ld.Digest, err)
+		}
+		filenames = append(filenames, filename)
+	}
+
+	fs := layer.NewFS()
+	for i, filename := range filenames {
+		r, err := openLocalLayer(filename)
+		if err != nil {
+			return fmt.Errorf("layer %s: %w", img.Schema2.Layers[i].Digest, err)
+		}
+		err = fs.Apply(r)
+		if closeErr := r.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("layer %s: %w", img.Schema2.Layers[i].Digest, err)
+		}
+	}
+
+	tw := tar.NewWriter(w)
+	for i, filename := range filenames {
+		r, err := openLocalLayer(filename)
+		if err != nil {
+			return fmt.Errorf("layer %s: %w", img.Schema2.Layers[i].Digest, err)
+		}
+		err = writeFlattenedLayer(tw, fs, i, r)
+		if closeErr := r.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("layer %s: %w", img.Schema2.Layers[i].Digest, err)
+		}
+	}
+	return tw.Close()
+}
+
+var exportOpts struct {
+	Platform string
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export <name>[:<tag>|@<digest>] <directory>|<file.tar>|-",
+	Short: "Export the flattened file system of an image",
+	Long: `Export the flattened file system of an image.
+
+Applies the layers of the image in order, honoring whiteouts and opaque
+directories. If the destination ends with .tar or is -, the flattened file
+system is written as a tarball to the file or to stdout. Otherwise the layers
+are extracted into the directory.
+
+Owners of extracted files are preserved only when the command is run as root.
+Device nodes and FIFOs are not extracted into directories.
+
+Examples:
+  # Extract the file system of busybox into ./rootfs.
+  boater export busybox ./rootfs
+
+  # Save the flattened file system as a tarball.
+  boater export busybox ./busybox.tar
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		c := newClient(args[0], []string{"pull"})
+
+		img, err := fetchImage(c, manifestName(c.Named()), exportOpts.Platform)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		dst := args[1]
+		switch {
+		case dst == "-":
+			err = exportTar(c, img, os.Stdout)
+		case strings.HasSuffix(dst, ".tar"):
+			var f *os.File
+			f, err = os.Create(dst)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = exportTar(c, img, f)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		default:
+			err = exportDir(c, img, dst)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringVar(&exportOpts.Platform, "platform", "linux/amd64", "use the manifest for the specified platform (os/arch[/variant]) from manifest lists")
+}
@@ -0,0 +1,98 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dmage/boater/pkg/signature"
+)
+
+// writeNewFile writes data to the file that must not exist yet.
+func writeNewFile(filename string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+var generateKeyPairOpts struct {
+	Algorithm string
+	Output    string
+}
+
+var generateKeyPairCmd = &cobra.Command{
+	Use:   "generate-key-pair",
+	Short: "Generate a key pair for signing manifests",
+	Long: `Generate a key pair for signing manifests.
+
+Writes the unencrypted private key to <prefix>.key and the public key to
+<prefix>.pub. Existing files are not overwritten.
+
+Examples:
+  # Generate boater.key and boater.pub.
+  boater generate-key-pair
+
+  # Generate an ed25519 key pair.
+  boater generate-key-pair --algorithm ed25519 --output-prefix release
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		key, err := signature.GenerateKey(generateKeyPairOpts.Algorithm)
+		if err != nil {
+			log.Fatal(err)
+		}
+		keyData, err := signature.MarshalPrivateKey(key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pubData, err := signature.MarshalPublicKey(key.Public())
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		keyFile := generateKeyPairOpts.Output + ".key"
+		pubFile := generateKeyPairOpts.Output + ".pub"
+		if err := writeNewFile(keyFile, keyData, 0600); err != nil {
+			log.Fatal(err)
+		}
+		if err := writeNewFile(pubFile, pubData, 0644); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("Private key written to %s\n", keyFile)
+		fmt.Printf("Public key written to %s\n", pubFile)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(generateKeyPairCmd)
+
+	generateKeyPairCmd.Flags().StringVar(&generateKeyPairOpts.Algorithm, "algorithm", "ecdsa-p256", "the key algorithm (ecdsa-p256 or ed25519)")
+	generateKeyPairCmd.Flags().StringVar(&generateKeyPairOpts.Output, "output-prefix", "boater", "the prefix of the key files")
+}
@@ -41,8 +41,7 @@ Examples:
 		c := newClient(args[0], []string{"pull"})
 		digest := args[1]
 
-		req, _ := http.NewRequest("GET", c.URL("/v2/%s/blobs/%s", c.Scope(), digest), nil)
-		resp, err := c.Do(req)
+		resp, err := c.GetBlob(digest)
 		if err != nil {
 			log.Fatal(err)
 		}
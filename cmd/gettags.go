@@ -23,11 +23,17 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/opencontainers/go-digest"
 	"github.com/spf13/cobra"
 	"github.com/tomnomnom/linkheader"
 
 	"github.com/dmage/boater/pkg/client"
+	"github.com/dmage/boater/pkg/semver"
 )
 
 type limitedReader struct {
@@ -94,14 +100,219 @@ func getTags(c *client.Client, url string) ([]string, string, error) {
 	return response.Tags, nextLink, nil
 }
 
+// listTags returns all tags in the repository, following pagination links.
+func listTags(c *client.Client) ([]string, error) {
+	var allTags []string
+	tagsURL, err := c.URL("/v2/%s/tags/list", c.Scope())
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tags, nextURL, err := getTags(c, tagsURL)
+		if err != nil {
+			return nil, err
+		}
+
+		allTags = append(allTags, tags...)
+
+		if nextURL == "" {
+			break
+		}
+
+		base, err := url.Parse(tagsURL)
+		if err != nil {
+			return nil, err
+		}
+
+		next, err := c.ResolveReference(base, nextURL)
+		if err != nil {
+			return nil, err
+		}
+
+		tagsURL = next.String()
+	}
+	return allTags, nil
+}
+
+type tagInfo struct {
+	Name    string
+	Digest  digest.Digest
+	Created time.Time
+}
+
+func resolveTag(c *client.Client, tag string, withCreated bool) (tagInfo, error) {
+	info := tagInfo{Name: tag}
+
+	if !withCreated {
+		// The digest is enough, so the manifest is not downloaded unless
+		// the registry does not report the digest.
+		resp, err := c.HeadManifest(tag, client.GetManifestOptions{
+			AcceptKnown: true,
+		})
+		if err != nil {
+			return info, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return info, readResponseError(resp)
+		}
+		if dgst, err := digest.Parse(resp.Header.Get("Docker-Content-Digest")); err == nil {
+			info.Digest = dgst
+			return info, nil
+		}
+	}
+
+	m, err := fetchManifest(c, tag)
+	if err != nil {
+		return info, err
+	}
+	info.Digest = m.Digest
+
+	if withCreated {
+		config, err := fetchImageConfig(c, m)
+		if err != nil {
+			return info, err
+		}
+		info.Created = config.Created
+	}
+
+	return info, nil
+}
+
+// resolveTags resolves tags concurrently using at most jobs workers. No new
+// tags are resolved after the first error.
+func resolveTags(c *client.Client, tags []string, withCreated bool, jobs int) ([]tagInfo, error) {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	infos := make([]tagInfo, len(tags))
+
+	var (
+		errOnce  sync.Once
+		firstErr error
+		failed   = make(chan struct{})
+	)
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				info, err := resolveTag(c, tags[i], withCreated)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("resolve tag %s: %w", tags[i], err)
+						close(failed)
+					})
+					continue
+				}
+				infos[i] = info
+			}
+		}()
+	}
+loop:
+	for i := range tags {
+		select {
+		case indexes <- i:
+		case <-failed:
+			break loop
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return infos, nil
+}
+
+func filterTags(tags []string, match, exclude *regexp.Regexp) []string {
+	var filtered []string
+	for _, tag := range tags {
+		if match != nil && !match.MatchString(tag) {
+			continue
+		}
+		if exclude != nil && exclude.MatchString(tag) {
+			continue
+		}
+		filtered = append(filtered, tag)
+	}
+	return filtered
+}
+
+// semverLess orders semantic versions before other tags, which are sorted
+// lexically.
+func semverLess(a, b string) bool {
+	va, aerr := semver.Parse(a)
+	vb, berr := semver.Parse(b)
+	switch {
+	case aerr == nil && berr == nil:
+		if c := va.Compare(vb); c != 0 {
+			return c < 0
+		}
+		return a < b
+	case aerr == nil:
+		return true
+	case berr == nil:
+		return false
+	}
+	return a < b
+}
+
+func latestSemver(tags []string) []string {
+	var latest string
+	var latestVersion semver.Version
+	for _, tag := range tags {
+		v, err := semver.Parse(tag)
+		if err != nil {
+			continue
+		}
+		if latest == "" || v.Compare(latestVersion) > 0 {
+			latest = tag
+			latestVersion = v
+		}
+	}
+	if latest == "" {
+		return nil
+	}
+	return []string{latest}
+}
+
+var getTagsOpts struct {
+	Match        string
+	Exclude      string
+	Sort         string
+	LatestSemver bool
+	Digests      bool
+	Jobs         int
+}
+
 var getTagsCmd = &cobra.Command{
 	Use:   "get-tags <repository>",
 	Short: "List tags in a repository",
 	Long: `List tags in a repository.
 
+By default tags are printed in the order they are returned by the registry.
+
+Sort orders:
+  lexical   sort tags as strings
+  semver    sort semantic versions (an optional "v" prefix is allowed) in
+            ascending order, followed by other tags sorted lexically
+  created   sort tags by the creation time from their image configs (requires
+            fetching the manifest and the config for every tag)
+
 Examples:
   # List tags in the repository busybox.
   boater get-tags busybox
+
+  # List 1.x tags without the variants based on glibc and musl.
+  boater get-tags busybox --match '^1\.' --exclude 'glibc|musl' --sort=semver
+
+  # Print the latest release and its digest.
+  boater get-tags busybox --match '^[0-9.]+$' --latest-semver --digests
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) != 1 {
@@ -109,38 +320,84 @@ Examples:
 			os.Exit(1)
 		}
 
-		c := newClient(args[0], []string{"pull"})
-
-		tagsURL := c.URL("/v2/%s/tags/list", c.Scope())
-		for {
-			tags, nextURL, err := getTags(c, tagsURL)
+		var match, exclude *regexp.Regexp
+		var err error
+		if getTagsOpts.Match != "" {
+			match, err = regexp.Compile(getTagsOpts.Match)
+			if err != nil {
+				log.Fatalf("invalid --match: %s", err)
+			}
+		}
+		if getTagsOpts.Exclude != "" {
+			exclude, err = regexp.Compile(getTagsOpts.Exclude)
 			if err != nil {
-				log.Fatal(err)
+				log.Fatalf("invalid --exclude: %s", err)
 			}
+		}
+		switch getTagsOpts.Sort {
+		case "", "lexical", "semver", "created":
+		default:
+			log.Fatalf("invalid --sort: %q (expected lexical, semver or created)", getTagsOpts.Sort)
+		}
+
+		c := newClient(args[0], []string{"pull"})
+
+		tags, err := listTags(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tags = filterTags(tags, match, exclude)
+
+		if getTagsOpts.LatestSemver {
+			tags = latestSemver(tags)
+		}
 
+		switch getTagsOpts.Sort {
+		case "lexical":
+			sort.Strings(tags)
+		case "semver":
+			sort.SliceStable(tags, func(i, j int) bool {
+				return semverLess(tags[i], tags[j])
+			})
+		}
+
+		withCreated := getTagsOpts.Sort == "created"
+		if !withCreated && !getTagsOpts.Digests {
 			for _, tag := range tags {
 				fmt.Printf("%s\n", tag)
 			}
+			return
+		}
 
-			if nextURL == "" {
-				break
-			}
+		infos, err := resolveTags(c, tags, withCreated, getTagsOpts.Jobs)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-			base, err := url.Parse(tagsURL)
-			if err != nil {
-				log.Fatal(err)
-			}
+		if withCreated {
+			sort.SliceStable(infos, func(i, j int) bool {
+				return infos[i].Created.Before(infos[j].Created)
+			})
+		}
 
-			ref, err := url.Parse(nextURL)
-			if err != nil {
-				log.Fatal(err)
+		for _, info := range infos {
+			if getTagsOpts.Digests {
+				fmt.Printf("%s %s\n", info.Name, info.Digest)
+			} else {
+				fmt.Printf("%s\n", info.Name)
 			}
-
-			tagsURL = base.ResolveReference(ref).String()
 		}
 	},
 }
 
 func init() {
 	RootCmd.AddCommand(getTagsCmd)
+
+	getTagsCmd.Flags().StringVar(&getTagsOpts.Match, "match", "", "print only tags that match the regular expression")
+	getTagsCmd.Flags().StringVar(&getTagsOpts.Exclude, "exclude", "", "do not print tags that match the regular expression")
+	getTagsCmd.Flags().StringVar(&getTagsOpts.Sort, "sort", "", "sort tags (lexical, semver or created)")
+	getTagsCmd.Flags().BoolVar(&getTagsOpts.LatestSemver, "latest-semver", false, "print only the highest semantic version among the matching tags")
+	getTagsCmd.Flags().BoolVar(&getTagsOpts.Digests, "digests", false, "print manifest digests next to tags")
+	getTagsCmd.Flags().IntVarP(&getTagsOpts.Jobs, "jobs", "j", 8, "number of tags to resolve concurrently")
 }
@@ -0,0 +1,448 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/dmage/boater/pkg/client"
+	"github.com/dmage/boater/pkg/layer"
+	"github.com/dmage/boater/pkg/manifests"
+)
+
+const maxManifestSize = 4 << 20 // 4 megabytes
+
+// manifestBlob is a manifest as it was received from the registry.
+type manifestBlob struct {
+	MediaType string
+	Digest    digest.Digest
+	Data      []byte
+}
+
+func readResponseError(resp *http.Response) error {
+	buf, _ := ioutil.ReadAll(&limitedReader{
+		r: resp.Body,
+		n: 4096,
+	})
+	msg := strings.TrimSpace(string(buf))
+	if msg == "" {
+		return fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL, resp.Status)
+	}
+	return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL, resp.Status, msg)
+}
+
+// fetchManifest gets the manifest name (a tag or a digest) from the
+// repository, accepting all known manifest types.
+func fetchManifest(c *client.Client, name string) (*manifestBlob, error) {
+	resp, err := c.GetManifest(name, client.GetManifestOptions{
+		AcceptKnown: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, readResponseError(resp)
+	}
+
+	data, err := ioutil.ReadAll(&limitedReader{
+		r: resp.Body,
+		n: maxManifestSize,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read manifest %s: %w", name, err)
+	}
+
+	dgst := digest.Digest(resp.Header.Get("Docker-Content-Digest"))
+	if dgst == "" {
+		dgst = digest.FromBytes(data)
+	}
+
+	mediaType := resp.Header.Get("Content-Type")
+	if i := strings.IndexByte(mediaType, ';'); i >= 0 {
+		mediaType = strings.TrimSpace(mediaType[:i])
+	}
+
+	return &manifestBlob{
+		MediaType: mediaType,
+		Digest:    dgst,
+		Data:      data,
+	}, nil
+}
+
+// fetchBlob gets the blob dgst from the repository and verifies its digest.
+func fetchBlob(c *client.Client, dgst digest.Digest) ([]byte, error) {
+	resp, err := c.GetBlob(dgst.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, readResponseError(resp)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read blob %s: %w", dgst, err)
+	}
+
+	if err := dgst.Validate(); err == nil {
+		if actual := dgst.Algorithm().FromBytes(data); actual != dgst {
+			return nil, fmt.Errorf("blob %s has unexpected digest %s", dgst, actual)
+		}
+	}
+
+	return data, nil
+}
+
+// fetchConfig gets the image config dgst from the repository.
+func fetchConfig(c *client.Client, dgst digest.Digest) (manifests.ImageConfig, error) {
+	var config manifests.ImageConfig
+	data, err := fetchBlob(c, dgst)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("decode config %s: %w", dgst, err)
+	}
+	return config, nil
+}
+
+// fetchImageConfig returns the image config for the manifest m. For manifest
+// lists and OCI indexes the config of the first manifest is returned. For
+// schema 1 manifests the config is reconstructed from the topmost
+// v1Compatibility entry.
+func fetchImageConfig(c *client.Client, m *manifestBlob) (manifests.ImageConfig, error) {
+	switch {
+	case manifests.IsImageManifest(m.MediaType):
+		var manifest manifests.Schema2
+		if err := json.Unmarshal(m.Data, &manifest); err != nil {
+			return manifests.ImageConfig{}, fmt.Errorf("decode manifest %s: %w", m.Digest, err)
+		}
+		return fetchConfig(c, manifest.Config.Digest)
+	case manifests.IsIndex(m.MediaType):
+		var manifest manifests.ManifestList
+		if err := json.Unmarshal(m.Data, &manifest); err != nil {
+			return manifests.ImageConfig{}, fmt.Errorf("decode manifest %s: %w", m.Digest, err)
+		}
+		if len(manifest.Manifests) == 0 {
+			return manifests.ImageConfig{}, fmt.Errorf("manifest %s has no children", m.Digest)
+		}
+		child, err := fetchManifest(c, manifest.Manifests[0].Digest.String())
+		if err != nil {
+			return manifests.ImageConfig{}, err
+		}
+		if manifests.IsIndex(child.MediaType) {
+			return manifests.ImageConfig{}, fmt.Errorf("manifest %s: nested indexes are not supported", m.Digest)
+		}
+		return fetchImageConfig(c, child)
+	case manifests.IsSchema1(m.MediaType):
+		var manifest manifests.Schema1
+		if err := json.Unmarshal(m.Data, &manifest); err != nil {
+			return manifests.ImageConfig{}, fmt.Errorf("decode manifest %s: %w", m.Digest, err)
+		}
+		var config manifests.ImageConfig
+		if len(manifest.History) > 0 {
+			if err := json.Unmarshal([]byte(manifest.History[0].V1Compatibility), &config); err != nil {
+				return config, fmt.Errorf("decode v1Compatibility for %s: %w", m.Digest, err)
+			}
+		}
+		return config, nil
+	}
+	return manifests.ImageConfig{}, fmt.Errorf("unsupported manifest type: %s", m.MediaType)
+}
+
+// image is a single-platform image with its config.
+type image struct {
+	Manifest *manifestBlob
+	Schema2  manifests.Schema2
+	Config   manifests.ImageConfig
+}
+
+func parsePlatform(s string) (manifests.PlatformSpec, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return manifests.PlatformSpec{}, fmt.Errorf("invalid platform %q (expected os/arch[/variant])", s)
+	}
+	platform := manifests.PlatformSpec{
+		OS:           parts[0],
+		Architecture: parts[1],
+	}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+func formatPlatform(platform manifests.PlatformSpec) string {
+	s := platform.OS + "/" + platform.Architecture
+	if platform.Variant != "" {
+		s += "/" + platform.Variant
+	}
+	return s
+}
+
+// platformMatches returns true if platform matches want. If want does not
+// have a variant, any variant matches.
+func platformMatches(platform, want manifests.PlatformSpec) bool {
+	return platform.OS == want.OS && platform.Architecture == want.Architecture &&
+		(want.Variant == "" || platform.Variant == want.Variant)
+}
+
+// selectManifest returns the manifest from the list that matches platform
+// (os/arch[/variant]).
+func selectManifest(list manifests.ManifestList, platform string) (manifests.ManifestDescriptor, error) {
+	want, err := parsePlatform(platform)
+	if err != nil {
+		return manifests.ManifestDescriptor{}, err
+	}
+	var available []string
+	for _, md := range list.Manifests {
+		if md.Platform == nil {
+			continue
+		}
+		if platformMatches(*md.Platform, want) {
+			return md, nil
+		}
+		available = append(available, formatPlatform(*md.Platform))
+	}
+	return manifests.ManifestDescriptor{}, fmt.Errorf("no manifest for platform %s (available: %s)", platform, strings.Join(available, ", "))
+}
+
+// fetchImage gets the manifest name and its config. If the manifest is a
+// manifest list or an OCI index, the manifest for platform is used.
+func fetchImage(c *client.Client, name string, platform string) (*image, error) {
+	m, err := fetchManifest(c, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifests.IsIndex(m.MediaType) {
+		var list manifests.ManifestList
+		if err := json.Unmarshal(m.Data, &list); err != nil {
+			return nil, fmt.Errorf("decode manifest %s: %w", m.Digest, err)
+		}
+		md, err := selectManifest(list, platform)
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s: %w", m.Digest, err)
+		}
+		m, err = fetchManifest(c, md.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !manifests.IsImageManifest(m.MediaType) {
+		return nil, fmt.Errorf("manifest %s: unsupported manifest type: %s", m.Digest, m.MediaType)
+	}
+
+	img := &image{
+		Manifest: m,
+	}
+	if err := json.Unmarshal(m.Data, &img.Schema2); err != nil {
+		return nil, fmt.Errorf("decode manifest %s: %w", m.Digest, err)
+	}
+	img.Config, err = fetchConfig(c, img.Schema2.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// verifyingReader returns an error at EOF if the data that has been read does
+// not match the expected digest.
+type verifyingReader struct {
+	r        io.Reader
+	expected digest.Digest
+	verifier digest.Verifier
+}
+
+func newVerifyingReader(r io.Reader, expected digest.Digest) io.Reader {
+	if err := expected.Validate(); err != nil {
+		return r
+	}
+	return &verifyingReader{
+		r:        r,
+		expected: expected,
+		verifier: expected.Verifier(),
+	}
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.verifier.Write(p[:n])
+	if err == io.EOF && !r.verifier.Verified() {
+		return n, fmt.Errorf("blob %s: digest mismatch", r.expected)
+	}
+	return n, err
+}
+
+// layerReader is the uncompressed tarball of a layer. Tar readers stop at the
+// end-of-archive marker, so Close reads the rest of the layer to verify the
+// checksum of the compressed stream and the digest of the blob.
+type layerReader struct {
+	io.ReadCloser
+	blob io.Reader // the compressed data, nil if it needs no verification
+	body io.Closer
+}
+
+func (r layerReader) Close() error {
+	_, err := io.Copy(ioutil.Discard, r.ReadCloser)
+	if err == nil && r.blob != nil {
+		_, err = io.Copy(ioutil.Discard, r.blob)
+	}
+	if closeErr := r.ReadCloser.Close(); err == nil {
+		err = closeErr
+	}
+	if bodyErr := r.body.Close(); err == nil {
+		err = bodyErr
+	}
+	return err
+}
+
+// openLayer returns a reader for the uncompressed tarball of the layer ld.
+func openLayer(c *client.Client, ld manifests.LayerDescriptor) (io.ReadCloser, error) {
+	resp, err := c.GetBlob(ld.Digest.String())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, readResponseError(resp)
+	}
+
+	blob := newVerifyingReader(resp.Body, ld.Digest)
+	r, err := layer.Decompress(blob, ld.MediaType)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("layer %s: %w", ld.Digest, err)
+	}
+	return layerReader{
+		ReadCloser: r,
+		blob:       blob,
+		body:       resp.Body,
+	}, nil
+}
+
+// downloadBlob writes the blob dgst to w and verifies its digest.
+func downloadBlob(c *client.Client, dgst digest.Digest, w io.Writer) error {
+	resp, err := c.GetBlob(dgst.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return readResponseError(resp)
+	}
+
+	_, err = io.Copy(w, newVerifyingReader(resp.Body, dgst))
+	return err
+}
+
+// layerMediaType returns the media type for a layer with the compression c in
+// a manifest of the type manifestType.
+func layerMediaType(manifestType string, c layer.Compression) (string, error) {
+	if manifestType == manifests.MediaTypeOCIManifest {
+		switch c {
+		case layer.Uncompressed:
+			return manifests.MediaTypeOCILayer, nil
+		case layer.Gzip:
+			return manifests.MediaTypeOCILayerGzip, nil
+		case layer.Zstd:
+			return manifests.MediaTypeOCILayerZstd, nil
+		}
+	} else if c == layer.Gzip {
+		return manifests.MediaTypeLayer, nil
+	}
+	return "", fmt.Errorf("%s layers are not supported by %s", c, manifestType)
+}
+
+func sameRepository(a, b *client.Client) bool {
+	return a.Named().Name() == b.Named().Name()
+}
+
+// copyBlob makes sure that the blob desc from the repository of src exists in
+// the repository of dst.
+func copyBlob(src, dst *client.Client, desc manifests.Descriptor) error {
+	if sameRepository(src, dst) {
+		return nil
+	}
+
+	resp, err := dst.HeadBlob(desc.Digest.String())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp, err = src.GetBlob(desc.Digest.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return readResponseError(resp)
+	}
+
+	return putBlob(dst, desc.Digest, newVerifyingReader(resp.Body, desc.Digest), desc.Size)
+}
+
+// putBlob uploads the blob dgst unless it already exists in the repository.
+func putBlob(c *client.Client, dgst digest.Digest, r io.Reader, size int64) error {
+	resp, err := c.HeadBlob(dgst.String())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp, err = c.PutBlob(dgst.String(), r, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return readResponseError(resp)
+	}
+	return nil
+}
+
+// putManifest uploads the manifest and returns its digest.
+func putManifest(c *client.Client, name string, mediaType string, data []byte) (digest.Digest, error) {
+	resp, err := c.PutManifest(name, mediaType, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", readResponseError(resp)
+	}
+	return digest.FromBytes(data), nil
+}
@@ -17,6 +17,7 @@ package cmd
 import (
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -76,15 +77,23 @@ Examples:
 		switch manifestType {
 		case "application/vnd.docker.distribution.manifest.v1+json",
 			"application/vnd.docker.distribution.manifest.v1+prettyjws":
+			data, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				log.Fatal(err)
+			}
 			var manifest manifests.Schema1
-			err = json.NewDecoder(resp.Body).Decode(&manifest)
+			err = json.Unmarshal(data, &manifest)
 			if err != nil {
 				log.Fatal(err)
 			}
+			if manifestType == "application/vnd.docker.distribution.manifest.v1+prettyjws" {
+				manifest.Signatures, manifest.SignaturesErr = manifests.VerifySchema1(data)
+			}
 			printer.Referencef("%s:%s\n", repoName, manifest.Tag)
 			printer.KeyValueln("  ", "Content-Type", manifestType)
 			manifest.Dump("  ")
-		case "application/vnd.docker.distribution.manifest.v2+json":
+		case "application/vnd.docker.distribution.manifest.v2+json",
+			"application/vnd.oci.image.manifest.v1+json":
 			var manifest manifests.Schema2
 			err = json.NewDecoder(resp.Body).Decode(&manifest)
 			if err != nil {
@@ -103,7 +112,8 @@ Examples:
 			printer.Referencef("%s@%s\n", repoName, contentDigest)
 			printer.KeyValueln("  ", "Content-Type", manifestType)
 			manifest.Dump("  ", config)
-		case "application/vnd.docker.distribution.manifest.list.v2+json":
+		case "application/vnd.docker.distribution.manifest.list.v2+json",
+			"application/vnd.oci.image.index.v1+json":
 			var manifest manifests.ManifestList
 			err = json.NewDecoder(resp.Body).Decode(&manifest)
 			if err != nil {
@@ -0,0 +1,176 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/cobra"
+
+	"github.com/dmage/boater/pkg/client"
+	"github.com/dmage/boater/pkg/layer"
+	"github.com/dmage/boater/pkg/manifests"
+	"github.com/dmage/boater/pkg/printer"
+)
+
+func formatOwner(hdr *tar.Header) string {
+	user := hdr.Uname
+	if user == "" {
+		user = fmt.Sprint(hdr.Uid)
+	}
+	group := hdr.Gname
+	if group == "" {
+		group = fmt.Sprint(hdr.Gid)
+	}
+	return user + "/" + group
+}
+
+func formatEntry(hdr *tar.Header) string {
+	s := fmt.Sprintf("%s %-16s %10d /%s", hdr.FileInfo().Mode(), formatOwner(hdr), hdr.Size, layer.Clean(hdr.Name))
+	switch hdr.Typeflag {
+	case tar.TypeSymlink:
+		s += " -> " + hdr.Linkname
+	case tar.TypeLink:
+		s += " link to /" + layer.Clean(hdr.Linkname)
+	}
+	return s
+}
+
+func listLayer(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		s := formatEntry(hdr)
+		if wh, ok := layer.ParseWhiteout(hdr.Name); ok {
+			if wh.Name == "" {
+				s += fmt.Sprintf(" [opaque: /%s]", wh.Dir)
+			} else {
+				s += fmt.Sprintf(" [whiteout: /%s]", wh.Path())
+			}
+		}
+		fmt.Println(s)
+	}
+}
+
+var lsOpts struct {
+	Platform string
+	Layer    string
+	Merged   bool
+}
+
+var lsCmd = &cobra.Command{
+	Use:   "ls <name>[:<tag>|@<digest>]",
+	Short: "List files in image layers",
+	Long: `List files in image layers.
+
+Streams the layers of the image and prints the mode, the owner, the size and
+the path for each entry. Whiteout files are annotated with the paths they
+delete. With --merged, the layers are applied on top of each other and the
+resulting file system is printed.
+
+Examples:
+  # List files in all layers of busybox.
+  boater ls busybox
+
+  # List files in a single layer.
+  boater ls busybox --layer sha256:19d511225f94f9b5cbf3836eb02b3e1f0e5bc7b4e0e7de1c9ac4e9e3ec7e5a0d
+
+  # List files in the flattened image.
+  boater ls busybox --merged
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		if lsOpts.Layer != "" && lsOpts.Merged {
+			log.Fatal("--layer and --merged cannot be used together")
+		}
+
+		c := newClient(args[0], []string{"pull"})
+
+		img, err := fetchImage(c, manifestName(c.Named()), lsOpts.Platform)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		layers := img.Schema2.Layers
+		if lsOpts.Layer != "" {
+			layers = nil
+			for _, ld := range img.Schema2.Layers {
+				if ld.Digest == digest.Digest(lsOpts.Layer) {
+					layers = append(layers, ld)
+					break
+				}
+			}
+			if len(layers) == 0 {
+				log.Fatalf("manifest %s does not have the layer %s", img.Manifest.Digest, lsOpts.Layer)
+			}
+		}
+
+		if lsOpts.Merged {
+			fs, err := imageFS(c, img)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, entry := range fs.Entries() {
+				fmt.Println(formatEntry(entry.Header))
+			}
+			return
+		}
+
+		for _, ld := range layers {
+			if lsOpts.Layer == "" {
+				printer.Referencef("%s\n", ld.Descriptor)
+			}
+			if err := listLayerBlob(c, ld); err != nil {
+				log.Fatalf("layer %s: %s", ld.Digest, err)
+			}
+		}
+	},
+}
+
+func listLayerBlob(c *client.Client, ld manifests.LayerDescriptor) error {
+	r, err := openLayer(c, ld)
+	if err != nil {
+		return err
+	}
+	err = listLayer(r)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func init() {
+	RootCmd.AddCommand(lsCmd)
+
+	lsCmd.Flags().StringVar(&lsOpts.Platform, "platform", "linux/amd64", "use the manifest for the specified platform (os/arch[/variant]) from manifest lists")
+	lsCmd.Flags().StringVar(&lsOpts.Layer, "layer", "", "list files only in the layer with the specified digest")
+	lsCmd.Flags().BoolVar(&lsOpts.Merged, "merged", false, "list files in the flattened file system")
+}
@@ -0,0 +1,428 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/cobra"
+
+	"github.com/dmage/boater/pkg/client"
+	"github.com/dmage/boater/pkg/layer"
+	"github.com/dmage/boater/pkg/manifests"
+)
+
+// preparedLayer is a compressed layer tarball that is ready to be uploaded.
+type preparedLayer struct {
+	Filename    string
+	Compression layer.Compression
+	Digest      digest.Digest
+	Size        int64
+	DiffID      digest.Digest
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// prepareLayer computes the digests of the layer tarball filename. If the
+// tarball is not compressed, it is compressed with gzip into tmpdir.
+func prepareLayer(filename string, tmpdir string) (*preparedLayer, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	compressedDigester := digest.Canonical.Digester()
+	diffIDDigester := digest.Canonical.Digester()
+	counter := &countingWriter{}
+
+	l := &preparedLayer{
+		Filename:    filename,
+		Compression: layer.DetectCompression(magic),
+	}
+	if l.Compression == layer.Uncompressed {
+		out, err := ioutil.TempFile(tmpdir, "layer")
+		if err != nil {
+			return nil, err
+		}
+		defer out.Close()
+		l.Filename = out.Name()
+		l.Compression = layer.Gzip
+
+		w, err := layer.NewCompressor(io.MultiWriter(out, compressedDigester.Hash(), counter), l.Compression, 0)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(io.MultiWriter(w, diffIDDigester.Hash()), br); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		if err := out.Close(); err != nil {
+			return nil, err
+		}
+	} else {
+		// Pipes cannot be read twice, so their contents are saved.
+		spool := ioutil.Discard
+		if fi, err := f.Stat(); err != nil || !fi.Mode().IsRegular() {
+			out, err := ioutil.TempFile(tmpdir, "layer")
+			if err != nil {
+				return nil, err
+			}
+			defer out.Close()
+			l.Filename = out.Name()
+			spool = out
+		}
+
+		tee := io.TeeReader(br, io.MultiWriter(compressedDigester.Hash(), counter, spool))
+		r, err := layer.Decompress(tee, filename)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(diffIDDigester.Hash(), r); err != nil {
+			return nil, fmt.Errorf("decompress %s: %w", filename, err)
+		}
+		r.Close()
+		// The decompressor may stop before the end of the file.
+		if _, err := io.Copy(ioutil.Discard, tee); err != nil {
+			return nil, err
+		}
+	}
+
+	l.Digest = compressedDigester.Digest()
+	l.DiffID = diffIDDigester.Digest()
+	l.Size = counter.n
+	return l, nil
+}
+
+func uploadPreparedLayer(c *client.Client, l *preparedLayer) error {
+	f, err := os.Open(l.Filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return putBlob(c, l.Digest, f, l.Size)
+}
+
+// parseCommand parses the value for --entrypoint and --cmd. JSON arrays are
+// used as is, other values are run by /bin/sh -c like in Dockerfiles.
+func parseCommand(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(s, "[") {
+		var cmd []string
+		if err := json.Unmarshal([]byte(s), &cmd); err != nil {
+			return nil, fmt.Errorf("invalid command %q: %w", s, err)
+		}
+		return cmd, nil
+	}
+	return []string{"/bin/sh", "-c", s}, nil
+}
+
+func parseKeyValue(s string) (string, string, error) {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid value %q (expected KEY=VALUE)", s)
+	}
+	return s[:i], s[i+1:], nil
+}
+
+func setEnv(env []string, key, value string) []string {
+	for i, e := range env {
+		if strings.HasPrefix(e, key+"=") {
+			env[i] = key + "=" + value
+			return env
+		}
+	}
+	return append(env, key+"="+value)
+}
+
+func parseCreated(s string) (time.Time, error) {
+	if s == "now" {
+		return time.Now().UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: %w", s, err)
+	}
+	return t, nil
+}
+
+var mutateOpts struct {
+	Platform     string
+	AppendLayers []string
+	Env          []string
+	Entrypoint   string
+	Cmd          string
+	Labels       []string
+	WorkDir      string
+	User         string
+	Created      string
+}
+
+// mutateContainerConfig applies the options to the config section of the
+// image config.
+func mutateContainerConfig(cmd *cobra.Command, config *manifests.ContainerConfig) error {
+	for _, e := range mutateOpts.Env {
+		key, value, err := parseKeyValue(e)
+		if err != nil {
+			return err
+		}
+		config.Env = setEnv(config.Env, key, value)
+	}
+
+	for _, l := range mutateOpts.Labels {
+		key, value, err := parseKeyValue(l)
+		if err != nil {
+			return err
+		}
+		if config.Labels == nil {
+			config.Labels = make(map[string]string)
+		}
+		config.Labels[key] = value
+	}
+
+	if cmd.Flags().Changed("entrypoint") {
+		entrypoint, err := parseCommand(mutateOpts.Entrypoint)
+		if err != nil {
+			return err
+		}
+		config.Entrypoint = entrypoint
+	}
+
+	if cmd.Flags().Changed("cmd") {
+		command, err := parseCommand(mutateOpts.Cmd)
+		if err != nil {
+			return err
+		}
+		config.Cmd = command
+	}
+
+	if cmd.Flags().Changed("workdir") {
+		config.WorkingDir = mutateOpts.WorkDir
+	}
+
+	if cmd.Flags().Changed("config-user") {
+		config.User = mutateOpts.User
+	}
+
+	return nil
+}
+
+// mutateConfig applies the options to the image config and appends the
+// layers.
+func mutateConfig(cmd *cobra.Command, config *manifests.ImageConfig, layers []*preparedLayer) error {
+	if err := mutateContainerConfig(cmd, &config.Config); err != nil {
+		return err
+	}
+
+	created := time.Now().UTC()
+	if mutateOpts.Created != "" {
+		var err error
+		created, err = parseCreated(mutateOpts.Created)
+		if err != nil {
+			return err
+		}
+		config.Created = created
+	}
+
+	for i, l := range layers {
+		if config.RootFS.Type == "" {
+			config.RootFS.Type = "layers"
+		}
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, l.DiffID.String())
+		config.History = append(config.History, manifests.History{
+			Created:   created,
+			CreatedBy: "boater mutate --append-layer " + filepath.Base(mutateOpts.AppendLayers[i]),
+		})
+	}
+
+	return nil
+}
+
+// mutateManifest returns the manifest with the new config and the layers
+// appended.
+func mutateManifest(img *image, config manifests.Descriptor, layers []*preparedLayer) ([]byte, error) {
+	var manifest manifests.Object
+	if err := json.Unmarshal(img.Manifest.Data, &manifest); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+
+	if err := manifest.Set("config", config); err != nil {
+		return nil, err
+	}
+
+	var layerDescriptors []json.RawMessage
+	if err := manifest.Get("layers", &layerDescriptors); err != nil {
+		return nil, err
+	}
+	for _, l := range layers {
+		mediaType, err := layerMediaType(img.Manifest.MediaType, l.Compression)
+		if err != nil {
+			return nil, err
+		}
+		ld, err := manifests.MarshalJSON(manifests.Descriptor{
+			MediaType: mediaType,
+			Size:      l.Size,
+			Digest:    l.Digest,
+		})
+		if err != nil {
+			return nil, err
+		}
+		layerDescriptors = append(layerDescriptors, ld)
+	}
+	if err := manifest.Set("layers", layerDescriptors); err != nil {
+		return nil, err
+	}
+
+	return manifests.MarshalJSON(manifest)
+}
+
+var mutateCmd = &cobra.Command{
+	Use:   "mutate <src-name>[:<tag>|@<digest>] <dst-name>[:<tag>]",
+	Short: "Append layers to an image and change its config",
+	Long: `Append layers to an image and change its config.
+
+Gets the manifest and the config of the source image, uploads the new layers
+and the updated config into the destination repository and pushes the new
+manifest. Fields of the manifest and the config that are not affected by the
+options are preserved.
+
+Uncompressed layer tarballs are compressed with gzip before they are uploaded.
+
+Values for --entrypoint and --cmd can be JSON arrays (["/bin/app", "-v"]),
+otherwise they are run using /bin/sh -c. An empty value resets the command.
+
+Examples:
+  # Add CA certificates to an image.
+  boater mutate example.com/app:v1 example.com/app:v1-ca --append-layer ./ca-certificates.tar
+
+  # Change the environment and the command.
+  boater mutate example.com/app:v1 example.com/app:v1-debug --env DEBUG=1 --cmd '["/app", "--verbose"]'
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		src := newClient(args[0], []string{"pull"})
+		dst := newClient(args[1], []string{"pull", "push"})
+
+		img, err := fetchImage(src, manifestName(src.Named()), mutateOpts.Platform)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tmpdir, err := ioutil.TempDir("", "boater-mutate-")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(tmpdir)
+
+		var layers []*preparedLayer
+		for _, filename := range mutateOpts.AppendLayers {
+			l, err := prepareLayer(filename, tmpdir)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if _, err := layerMediaType(img.Manifest.MediaType, l.Compression); err != nil {
+				log.Fatalf("%s: %s", filename, err)
+			}
+			layers = append(layers, l)
+		}
+
+		config := img.Config
+		if err := mutateConfig(cmd, &config, layers); err != nil {
+			log.Fatal(err)
+		}
+		newConfigData, err := manifests.MarshalJSON(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		configDescriptor := img.Schema2.Config.Descriptor
+		configDescriptor.Digest = digest.FromBytes(newConfigData)
+		configDescriptor.Size = int64(len(newConfigData))
+
+		manifestData, err := mutateManifest(img, configDescriptor, layers)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, ld := range img.Schema2.Layers {
+			if len(ld.URLs) > 0 {
+				// Foreign layers are not stored in the registry.
+				continue
+			}
+			if err := copyBlob(src, dst, ld.Descriptor); err != nil {
+				log.Fatalf("copy layer %s: %s", ld.Digest, err)
+			}
+		}
+		for i, l := range layers {
+			if err := uploadPreparedLayer(dst, l); err != nil {
+				log.Fatalf("upload layer %s: %s", mutateOpts.AppendLayers[i], err)
+			}
+		}
+		if err := putBlob(dst, configDescriptor.Digest, bytes.NewReader(newConfigData), configDescriptor.Size); err != nil {
+			log.Fatalf("upload config: %s", err)
+		}
+
+		dgst, err := putManifest(dst, manifestName(dst.Named()), img.Manifest.MediaType, manifestData)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(dgst)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(mutateCmd)
+
+	mutateCmd.Flags().StringVar(&mutateOpts.Platform, "platform", "linux/amd64", "use the manifest for the specified platform (os/arch[/variant]) from manifest lists")
+	mutateCmd.Flags().StringArrayVar(&mutateOpts.AppendLayers, "append-layer", nil, "append the layer tarball (uncompressed, gzip or zstd)")
+	mutateCmd.Flags().StringArrayVar(&mutateOpts.Env, "env", nil, "set the environment variable (KEY=VALUE)")
+	mutateCmd.Flags().StringVar(&mutateOpts.Entrypoint, "entrypoint", "", "set the entrypoint")
+	mutateCmd.Flags().StringVar(&mutateOpts.Cmd, "cmd", "", "set the command")
+	mutateCmd.Flags().StringArrayVar(&mutateOpts.Labels, "label", nil, "set the label (KEY=VALUE)")
+	mutateCmd.Flags().StringVar(&mutateOpts.WorkDir, "workdir", "", "set the working directory")
+	mutateCmd.Flags().StringVar(&mutateOpts.User, "config-user", "", "set the user (--user is used for credentials)")
+	mutateCmd.Flags().StringVar(&mutateOpts.Created, "created", "", "set the creation time (RFC 3339 or now)")
+}
@@ -20,7 +20,6 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -69,51 +68,21 @@ Examples:
 			}
 		}
 
-		c := newClient(args[0], []string{"pull", "push"})
-
-		req, err := http.NewRequest("POST", c.URL("/v2/%s/blobs/uploads/", c.Scope()), nil)
-		if err != nil {
-			log.Fatal(err)
+		size := int64(-1)
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			size = fi.Size()
 		}
-		resp, err := c.Do(req)
-		if err != nil {
-			log.Fatal(err)
-		}
-		resp.Body.Close()
 
-		if resp.StatusCode != http.StatusAccepted {
-			log.Fatalf("%s %s: %s", req.Method, req.URL, resp.Status)
-		}
-
-		loc := resp.Header.Get("Location")
-		if loc == "" {
-			log.Fatal("no Location header")
-		}
-
-		uri, err := url.Parse(loc)
-		if err != nil {
-			log.Fatalf("unable to parse Location: %s", err)
-		}
-
-		if uri.RawQuery != "" {
-			uri.RawQuery += "&"
-		}
-		uri.RawQuery += "digest=" + url.QueryEscape(digest)
-
-		req, err = http.NewRequest("PUT", uri.String(), f)
-		if err != nil {
-			log.Fatal(err)
-		}
-		req.Header.Add("Content-Type", "application/octet-stream")
+		c := newClient(args[0], []string{"pull", "push"})
 
-		resp, err = c.Do(req)
+		resp, err := c.PutBlob(digest, f, size)
 		if err != nil {
 			log.Fatal(err)
 		}
 		resp.Body.Close()
 
 		if resp.StatusCode != http.StatusCreated {
-			log.Fatalf("%s %s: %s", req.Method, req.URL, resp.Status)
+			log.Fatalf("%s %s: %s", resp.Request.Method, resp.Request.URL, resp.Status)
 		}
 
 		fmt.Println(resp.Header.Get("Docker-Content-Digest"))
@@ -25,10 +25,28 @@ import (
 
 	"github.com/docker/libtrust"
 	"github.com/spf13/cobra"
+
+	"github.com/dmage/boater/pkg/signature"
 )
 
+// loadSigningKey reads a private key for JWS signatures. Besides the PEM and
+// JWK formats that are supported by libtrust, PKCS #8 keys created by
+// generate-key-pair are accepted.
+func loadSigningKey(filename string) (libtrust.PrivateKey, error) {
+	pk, err := libtrust.LoadKeyFile(filename)
+	if err == nil {
+		return pk, nil
+	}
+	key, err2 := signature.LoadPrivateKey(filename)
+	if err2 != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return libtrust.FromCryptoPrivateKey(key)
+}
+
 var putManifestOpts struct {
 	JSONSignature bool
+	SigningKey    string
 	MediaType     string
 }
 
@@ -40,6 +58,9 @@ var putManifestCmd = &cobra.Command{
 Examples:
   # Put the manifest into the repository.
   boater --config-json ~/.docker/config.json put-manifest docker.io/dmage/foo:latest ./manifest.json --content-type="application/vnd.docker.distribution.manifest.v2+json"
+
+  # Sign a schema 1 manifest with a persistent key.
+  boater put-manifest example.com/foo:latest ./manifest.json --signing-key ./key.pem --content-type="application/vnd.docker.distribution.manifest.v1+prettyjws"
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) != 2 {
@@ -55,10 +76,18 @@ Examples:
 		}
 		defer f.Close()
 
-		if putManifestOpts.JSONSignature {
-			pk, err := libtrust.GenerateECP256PrivateKey()
-			if err != nil {
-				log.Fatal("failed to generate private key for signature: ", err)
+		if putManifestOpts.JSONSignature || putManifestOpts.SigningKey != "" {
+			var pk libtrust.PrivateKey
+			if putManifestOpts.SigningKey != "" {
+				pk, err = loadSigningKey(putManifestOpts.SigningKey)
+				if err != nil {
+					log.Fatal("failed to load private key for signature: ", err)
+				}
+			} else {
+				pk, err = libtrust.GenerateECP256PrivateKey()
+				if err != nil {
+					log.Fatal("failed to generate private key for signature: ", err)
+				}
 			}
 
 			data, err := ioutil.ReadAll(f)
@@ -89,13 +118,7 @@ Examples:
 		c := newClient(args[0], []string{"pull", "push"})
 		tag := manifestName(c.Named())
 
-		req, err := http.NewRequest("PUT", c.URL("/v2/%s/manifests/%s", c.Scope(), tag), f)
-		if err != nil {
-			log.Fatal(err)
-		}
-		req.Header.Add("Content-Type", putManifestOpts.MediaType)
-
-		resp, err := c.Do(req)
+		resp, err := c.PutManifest(tag, putManifestOpts.MediaType, f)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -104,7 +127,7 @@ Examples:
 		if resp.StatusCode != http.StatusCreated {
 			io.Copy(os.Stderr, resp.Body)
 			os.Stderr.Write([]byte("\n"))
-			log.Fatalf("%s %s: %s", req.Method, req.URL, resp.Status)
+			log.Fatalf("%s %s: %s", resp.Request.Method, resp.Request.URL, resp.Status)
 		}
 
 		fmt.Println(resp.Header.Get("Docker-Content-Digest"))
@@ -115,5 +138,6 @@ func init() {
 	RootCmd.AddCommand(putManifestCmd)
 
 	putManifestCmd.Flags().BoolVarP(&putManifestOpts.JSONSignature, "json-signature", "s", false, "sign the manifest with a random key")
+	putManifestCmd.Flags().StringVar(&putManifestOpts.SigningKey, "signing-key", "", "sign the manifest with the key from the specified file (implies --json-signature)")
 	putManifestCmd.Flags().StringVarP(&putManifestOpts.MediaType, "content-type", "t", "application/vnd.docker.distribution.manifest.v1+json", "use the specified media type to upload the manifest")
 }
@@ -0,0 +1,252 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/cobra"
+
+	"github.com/dmage/boater/pkg/client"
+	"github.com/dmage/boater/pkg/layer"
+	"github.com/dmage/boater/pkg/manifests"
+)
+
+// recompressLayer decompresses the layer ld and compresses it again with the
+// compression c into tmpdir.
+func recompressLayer(src *client.Client, ld manifests.LayerDescriptor, c layer.Compression, level int, tmpdir string) (*preparedLayer, error) {
+	out, err := ioutil.TempFile(tmpdir, "layer")
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	compressedDigester := digest.Canonical.Digester()
+	diffIDDigester := digest.Canonical.Digester()
+	counter := &countingWriter{}
+
+	w, err := layer.NewCompressor(io.MultiWriter(out, compressedDigester.Hash(), counter), c, level)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := openLayer(src, ld)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(io.MultiWriter(w, diffIDDigester.Hash()), r)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("layer %s: %w", ld.Digest, err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+
+	return &preparedLayer{
+		Filename:    out.Name(),
+		Compression: c,
+		Digest:      compressedDigester.Digest(),
+		Size:        counter.n,
+		DiffID:      diffIDDigester.Digest(),
+	}, nil
+}
+
+// recompressManifest replaces the layers of the manifest with the recompressed
+// layers. Foreign layers, which are not recompressed, have nil entries in
+// layers.
+func recompressManifest(img *image, manifestType string, layers []*preparedLayer) ([]byte, error) {
+	var manifest manifests.Object
+	if err := json.Unmarshal(img.Manifest.Data, &manifest); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+
+	if manifestType != img.Manifest.MediaType {
+		if err := manifest.Set("mediaType", manifestType); err != nil {
+			return nil, err
+		}
+		config := img.Schema2.Config
+		config.MediaType = manifests.OCIMediaType(config.MediaType)
+		if err := manifest.Set("config", config); err != nil {
+			return nil, err
+		}
+	}
+
+	var layerDescriptors []manifests.Object
+	if err := manifest.Get("layers", &layerDescriptors); err != nil {
+		return nil, err
+	}
+	for i, l := range layers {
+		ld := &layerDescriptors[i]
+		if l == nil {
+			// Foreign layers are not recompressed, but their media types
+			// should match the manifest type.
+			if manifestType != img.Manifest.MediaType {
+				if err := ld.Set("mediaType", manifests.OCIMediaType(img.Schema2.Layers[i].MediaType)); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		mediaType, err := layerMediaType(manifestType, l.Compression)
+		if err != nil {
+			return nil, err
+		}
+		if err := ld.Set("mediaType", mediaType); err != nil {
+			return nil, err
+		}
+		if err := ld.Set("size", l.Size); err != nil {
+			return nil, err
+		}
+		if err := ld.Set("digest", l.Digest); err != nil {
+			return nil, err
+		}
+	}
+	if err := manifest.Set("layers", layerDescriptors); err != nil {
+		return nil, err
+	}
+
+	return manifests.MarshalJSON(manifest)
+}
+
+// formatSavings describes the change of the size from before to after.
+func formatSavings(before, after int64) string {
+	if before == 0 {
+		return fmt.Sprintf("%s -> %s", manifests.HumanSize(before), manifests.HumanSize(after))
+	}
+	percent := float64(before-after) * 100 / float64(before)
+	if after > before {
+		return fmt.Sprintf("%s -> %s, grew by %s (%.1f%%)", manifests.HumanSize(before), manifests.HumanSize(after), manifests.HumanSize(after-before), -percent)
+	}
+	return fmt.Sprintf("%s -> %s, saved %s (%.1f%%)", manifests.HumanSize(before), manifests.HumanSize(after), manifests.HumanSize(before-after), percent)
+}
+
+var recompressOpts struct {
+	Platform    string
+	Compression string
+	Level       int
+}
+
+var recompressCmd = &cobra.Command{
+	Use:   "recompress <src-name>[:<tag>|@<digest>] <dst-name>[:<tag>] --compression gzip|zstd|uncompressed",
+	Short: "Recompress the layers of an image",
+	Long: `Recompress the layers of an image.
+
+Decompresses each layer of the source image, compresses it with the specified
+compression and level, and pushes the new layers and manifest into the
+destination repository. The config is not changed, as the uncompressed layers
+and therefore their diff IDs stay the same. Foreign layers are kept as is.
+
+Docker manifests support only gzip layers, so images with other compressions
+are pushed with OCI manifests.
+
+The sizes of the layers before and after recompression are reported on stderr.
+
+Examples:
+  # Measure how much zstd saves.
+  boater recompress example.com/app:v1 example.com/app:v1-zstd --compression zstd --level 19
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		compression, err := layer.ParseCompression(recompressOpts.Compression)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		src := newClient(args[0], []string{"pull"})
+		dst := newClient(args[1], []string{"pull", "push"})
+
+		img, err := fetchImage(src, manifestName(src.Named()), recompressOpts.Platform)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		manifestType := img.Manifest.MediaType
+		if _, err := layerMediaType(manifestType, compression); err != nil {
+			manifestType = manifests.MediaTypeOCIManifest
+		}
+
+		tmpdir, err := ioutil.TempDir("", "boater-recompress-")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(tmpdir)
+
+		var before, after int64
+		layers := make([]*preparedLayer, len(img.Schema2.Layers))
+		for i, ld := range img.Schema2.Layers {
+			if len(ld.URLs) > 0 {
+				// Foreign layers are not stored in the registry.
+				fmt.Fprintf(os.Stderr, "%s: foreign layer, skipped\n", ld.Digest)
+				continue
+			}
+
+			l, err := recompressLayer(src, ld, compression, recompressOpts.Level, tmpdir)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if i < len(img.Config.RootFS.DiffIDs) && img.Config.RootFS.DiffIDs[i] != l.DiffID.String() {
+				log.Fatalf("layer %s: diff ID %s does not match the config (%s)", ld.Digest, l.DiffID, img.Config.RootFS.DiffIDs[i])
+			}
+			if err := uploadPreparedLayer(dst, l); err != nil {
+				log.Fatalf("upload layer %s: %s", l.Digest, err)
+			}
+			os.Remove(l.Filename)
+			layers[i] = l
+
+			fmt.Fprintf(os.Stderr, "%s -> %s: %s\n", ld.Digest, l.Digest, formatSavings(ld.Size, l.Size))
+			before += ld.Size
+			after += l.Size
+		}
+		fmt.Fprintf(os.Stderr, "total: %s\n", formatSavings(before, after))
+
+		if err := copyBlob(src, dst, img.Schema2.Config.Descriptor); err != nil {
+			log.Fatalf("copy config %s: %s", img.Schema2.Config.Digest, err)
+		}
+
+		manifestData, err := recompressManifest(img, manifestType, layers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dgst, err := putManifest(dst, manifestName(dst.Named()), manifestType, manifestData)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(dgst)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(recompressCmd)
+
+	recompressCmd.Flags().StringVar(&recompressOpts.Platform, "platform", "linux/amd64", "use the manifest for the specified platform (os/arch[/variant]) from manifest lists")
+	recompressCmd.Flags().StringVar(&recompressOpts.Compression, "compression", "zstd", "the compression of the new layers (gzip, zstd or uncompressed)")
+	recompressCmd.Flags().IntVar(&recompressOpts.Level, "level", 0, "the compression level (0 for the default level)")
+}
@@ -0,0 +1,130 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/cobra"
+
+	"github.com/dmage/boater/pkg/client"
+	"github.com/dmage/boater/pkg/manifests"
+	"github.com/dmage/boater/pkg/printer"
+)
+
+func formatReferrer(md manifests.ManifestDescriptor) string {
+	var extra []string
+	if md.ArtifactType != "" {
+		extra = append(extra, md.ArtifactType)
+	} else if md.MediaType != "" {
+		extra = append(extra, md.MediaType)
+	}
+	keys := make([]string, 0, len(md.Annotations))
+	for key := range md.Annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		extra = append(extra, fmt.Sprintf("%s=%s", key, md.Annotations[key]))
+	}
+	if len(extra) == 0 {
+		return md.Digest.String()
+	}
+	return fmt.Sprintf("%s (%s)", md.Digest, strings.Join(extra, ", "))
+}
+
+// printReferrers prints the tree of referrers for the manifest dgst.
+// Referrers of referrers are printed only if recursive is true.
+func printReferrers(c *client.Client, dgst digest.Digest, artifactType string, prefix string, recursive bool, visited map[digest.Digest]bool) error {
+	visited[dgst] = true
+	defer delete(visited, dgst)
+
+	referrers, err := c.Referrers(dgst, artifactType)
+	if err != nil {
+		return err
+	}
+	for i, md := range referrers {
+		branch, indent := "├── ", "│   "
+		if i == len(referrers)-1 {
+			branch, indent = "└── ", "    "
+		}
+		printer.Delim(prefix + branch)
+		printer.Valueln("", formatReferrer(md))
+		if !recursive || visited[md.Digest] {
+			continue
+		}
+		if err := printReferrers(c, md.Digest, "", prefix+indent, recursive, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var referrersOpts struct {
+	ArtifactType string
+	Recursive    bool
+}
+
+var referrersCmd = &cobra.Command{
+	Use:   "referrers <name>[:<tag>|@<digest>]",
+	Short: "List artifacts that refer to a manifest",
+	Long: `List artifacts that refer to a manifest.
+
+Prints a tree of manifests that have the manifest as their subject, such as
+signatures, SBOMs and attestations, and manifests that refer to them. The
+--artifact-type filter is applied only to direct referrers.
+
+If the registry does not support the referrers API, referrers are read from
+the tag that follows the referrers tag schema (sha256-<hex>).
+
+Examples:
+  # List all artifacts attached to the image.
+  boater referrers example.com/app:v1
+
+  # List only SPDX SBOMs.
+  boater referrers example.com/app:v1 --artifact-type application/spdx+json
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		c := newClient(args[0], []string{"pull"})
+
+		m, err := fetchManifest(c, manifestName(c.Named()))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		printer.Referencef("%s@%s\n", c.Named().Name(), m.Digest)
+		err = printReferrers(c, m.Digest, referrersOpts.ArtifactType, "", referrersOpts.Recursive, make(map[digest.Digest]bool))
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(referrersCmd)
+
+	referrersCmd.Flags().StringVar(&referrersOpts.ArtifactType, "artifact-type", "", "list only referrers with the specified artifact type")
+	referrersCmd.Flags().BoolVarP(&referrersOpts.Recursive, "recursive", "r", true, "list referrers of referrers")
+}
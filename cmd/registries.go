@@ -0,0 +1,179 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/docker/distribution/reference"
+
+	"github.com/dmage/boater/pkg/client"
+	"github.com/dmage/boater/pkg/registries"
+)
+
+var (
+	registriesOnce   sync.Once
+	registriesPath   string
+	registriesConfig *registries.Config
+)
+
+// registriesConf returns the configuration from registries.conf, or nil if
+// there is no configuration file.
+func registriesConf() *registries.Config {
+	registriesOnce.Do(func() {
+		registriesPath = rootCmdRegistriesConf
+		if registriesPath == "" {
+			for _, path := range registries.DefaultPaths() {
+				if _, err := os.Stat(path); err == nil {
+					registriesPath = path
+					break
+				}
+			}
+		}
+		if registriesPath == "" {
+			return
+		}
+
+		config, err := registries.Load(registriesPath)
+		if err != nil {
+			log.Fatalf("unable to load registries configuration: %s", err)
+		}
+		if rootCmdVerbose {
+			log.Printf("Loaded registries configuration from %s", registriesPath)
+		}
+		registriesConfig = config
+	})
+	return registriesConfig
+}
+
+// splitReference splits ref into the name and the :tag or @digest suffix.
+func splitReference(ref string) (string, string) {
+	if i := strings.IndexByte(ref, '@'); i >= 0 {
+		return ref[:i], ref[i:]
+	}
+	if i := strings.LastIndexByte(ref, ':'); i > strings.LastIndexByte(ref, '/') {
+		return ref[:i], ref[i:]
+	}
+	return ref, ""
+}
+
+// imageExists returns true if the manifest for ref can be fetched.
+func imageExists(ref string) bool {
+	c, err := setupClient(ref, []string{"pull"})
+	if err != nil {
+		if rootCmdVerbose {
+			log.Printf("Unable to access %s: %s", ref, err)
+		}
+		return false
+	}
+	resp, err := c.GetManifest(manifestName(c.Named()), client.GetManifestOptions{
+		AcceptKnown: true,
+	})
+	if err != nil {
+		if rootCmdVerbose {
+			log.Printf("Unable to access %s: %s", ref, err)
+		}
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// resolveShortName qualifies the short name ref using the aliases and the
+// unqualified-search-registries from registries.conf. If there are several
+// search registries, the first one that has the image is used for pulls.
+// Without registries.conf, short names are Docker Hub references.
+func resolveShortName(ref string, actions []string) (string, error) {
+	config := registriesConf()
+	if config == nil || !registries.IsShortName(ref) {
+		return ref, nil
+	}
+
+	name, suffix := splitReference(ref)
+	if alias, ok := config.Alias(name); ok {
+		if rootCmdVerbose {
+			log.Printf("Resolved short name %s to %s using an alias", ref, alias+suffix)
+		}
+		return alias + suffix, nil
+	}
+
+	search := config.UnqualifiedSearchRegistries
+	switch {
+	case len(search) == 0:
+		return ref, nil
+	case len(search) == 1:
+		return search[0] + "/" + ref, nil
+	case containsString(actions, "push"):
+		return "", fmt.Errorf("%s is a short name and %s has several unqualified-search-registries, use a fully qualified name", ref, registriesPath)
+	}
+
+	for _, registry := range search {
+		candidate := registry + "/" + ref
+		if imageExists(candidate) {
+			if rootCmdVerbose {
+				log.Printf("Resolved short name %s to %s", ref, candidate)
+			}
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("unable to find %s in any of the unqualified-search-registries (%s)", ref, strings.Join(search, ", "))
+}
+
+// registryEndpoints returns the endpoints for the repository named according
+// to registries.conf, or nil if the repository should be accessed directly.
+// Mirrors are used only by clients that do not push.
+func registryEndpoints(named reference.Named, actions []string) ([]client.Endpoint, error) {
+	config := registriesConf()
+	if config == nil {
+		return nil, nil
+	}
+	r := config.Lookup(named.Name())
+	if r == nil {
+		return nil, nil
+	}
+	if r.Blocked {
+		return nil, fmt.Errorf("registry %s is blocked by %s", r.Prefix, registriesPath)
+	}
+
+	var locations []registries.Endpoint
+	if containsString(actions, "push") {
+		locations = []registries.Endpoint{r.Endpoint(named.Name())}
+	} else {
+		_, byDigest := named.(reference.Digested)
+		locations = r.PullEndpoints(named.Name(), byDigest)
+	}
+
+	var endpoints []client.Endpoint
+	for _, l := range locations {
+		endpointNamed, err := reference.ParseNormalizedNamed(l.Name)
+		if err != nil {
+			return nil, fmt.Errorf("registry %s: invalid location %s: %w", r.Prefix, l.Name, err)
+		}
+		e := client.Endpoint{
+			Named:    endpointNamed,
+			Insecure: l.Insecure || allowHTTP(reference.Domain(endpointNamed)),
+		}
+		if endpointNamed.Name() != named.Name() {
+			e.Credentials = newCredentialStore(endpointNamed.Name())
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, nil
+}
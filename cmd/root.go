@@ -15,36 +15,47 @@
 package cmd
 
 import (
-	"crypto/tls"
-	"encoding/json"
-	"io/ioutil"
+	"context"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/distribution/reference"
 	"github.com/docker/distribution/registry/client/auth"
+	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
-	"k8s.io/kubernetes/pkg/credentialprovider"
 
+	"github.com/dmage/boater/pkg/cache"
 	"github.com/dmage/boater/pkg/client"
 	"github.com/dmage/boater/pkg/httplog"
+	"github.com/dmage/boater/pkg/progress"
+	"github.com/dmage/boater/pkg/ratelimit"
+	"github.com/dmage/boater/pkg/redact"
+	"github.com/dmage/boater/pkg/retry"
+	"github.com/dmage/boater/pkg/tlsconfig"
 )
 
 // RootCmd represents the base command when called without any subcommands.
 var RootCmd = &cobra.Command{
 	Use:   "boater",
 	Short: "A Docker Registry HTTP API client",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if rootCmdVerboseUnsafe {
+			rootCmdVerbose = true
+		}
+	},
 }
 
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := RootCmd.Execute(); err != nil {
+	err := RootCmd.Execute()
+	stopProgress()
+	if err != nil {
 		os.Exit(-1)
 	}
 }
@@ -53,16 +64,66 @@ var rootCmdUser string
 var rootCmdPassword string
 var rootCmdPasswordFile string
 var rootCmdConfigJson string
+var rootCmdPullSecrets []string
+var rootCmdShowCredentialSource bool
 var rootCmdInsecure bool
+var rootCmdAllowHTTP bool
+var rootCmdSkipTLSVerify bool
+var rootCmdCAFile string
+var rootCmdCert string
+var rootCmdKey string
+var rootCmdConfig string
+var rootCmdEndpoints []string
 var rootCmdVerbose bool
+var rootCmdVerboseUnsafe bool
+var rootCmdVerboseBodySize int
+var rootCmdHAR string
+var rootCmdHARBodySize int
+var rootCmdRecord string
+var rootCmdReplay string
+var rootCmdReplayMatch []string
+var rootCmdRecordUnsafe bool
+var rootCmdRetries int
+var rootCmdRetryMaxWait time.Duration
+var rootCmdProgress string
+var rootCmdProgressInterval time.Duration
+var rootCmdLimitRate string
+var rootCmdCache bool
+var rootCmdCacheDir string
+var rootCmdRegistriesConf string
 
 func init() {
 	RootCmd.PersistentFlags().StringVarP(&rootCmdUser, "user", "u", "", "use the specified username")
 	RootCmd.PersistentFlags().StringVarP(&rootCmdPassword, "password", "p", "", "use the specified password")
 	RootCmd.PersistentFlags().StringVarP(&rootCmdPasswordFile, "password-file", "", "", "use the password found in the specified file")
 	RootCmd.PersistentFlags().StringVarP(&rootCmdConfigJson, "config-json", "", "", "use credentials from the specified Docker config.json file")
-	RootCmd.PersistentFlags().BoolVar(&rootCmdInsecure, "insecure", false, "send requests using http")
-	RootCmd.PersistentFlags().BoolVarP(&rootCmdVerbose, "verbose", "v", false, "print http requests")
+	RootCmd.PersistentFlags().StringArrayVar(&rootCmdPullSecrets, "pull-secret", nil, "use credentials from the specified Kubernetes Secret manifest of type kubernetes.io/dockerconfigjson or kubernetes.io/dockercfg (YAML or JSON, can be repeated)")
+	RootCmd.PersistentFlags().BoolVar(&rootCmdShowCredentialSource, "show-credential-source", false, "print which credentials are used for the registry, without the password")
+	RootCmd.PersistentFlags().BoolVar(&rootCmdInsecure, "insecure", false, "allow plain http and skip the verification of TLS certificates (same as --allow-http --skip-tls-verify)")
+	RootCmd.PersistentFlags().BoolVar(&rootCmdAllowHTTP, "allow-http", false, "fall back to plain http if https does not work")
+	RootCmd.PersistentFlags().BoolVar(&rootCmdSkipTLSVerify, "skip-tls-verify", false, "do not verify TLS certificates of registries")
+	RootCmd.PersistentFlags().StringVar(&rootCmdCAFile, "ca-file", "", "trust the CA certificates from the specified PEM file in addition to the system ones")
+	RootCmd.PersistentFlags().StringVar(&rootCmdCert, "cert", "", "use the client certificate from the specified PEM file")
+	RootCmd.PersistentFlags().StringVar(&rootCmdKey, "key", "", "use the key for the client certificate from the specified PEM file")
+	RootCmd.PersistentFlags().StringArrayVar(&rootCmdEndpoints, "endpoint", nil, "access the registry at the specified base URL, which may include a path prefix or be unix:///path/to/socket (format: registry=url, can be repeated)")
+	RootCmd.PersistentFlags().StringVar(&rootCmdConfig, "config", "", "read per-registry settings from the specified file (default $HOME/.config/boater/config.json)")
+	RootCmd.PersistentFlags().BoolVarP(&rootCmdVerbose, "verbose", "v", false, "print http requests (credentials are masked)")
+	RootCmd.PersistentFlags().BoolVar(&rootCmdVerboseUnsafe, "verbose-unsafe", false, "print http requests including credentials and tokens (implies --verbose; do not use in CI)")
+	RootCmd.PersistentFlags().IntVar(&rootCmdVerboseBodySize, "verbose-body-size", 256, "the number of bytes of request and response bodies to print in verbose mode")
+	RootCmd.PersistentFlags().StringVar(&rootCmdHAR, "har", "", "save http requests and responses to the specified file in the HAR 1.2 format (credentials are masked)")
+	RootCmd.PersistentFlags().IntVar(&rootCmdHARBodySize, "har-body-size", 64*1024, "the number of bytes of request and response bodies to save in the HAR file")
+	RootCmd.PersistentFlags().StringVar(&rootCmdRecord, "record", "", "save http requests and responses to the specified cassette directory (credentials are masked)")
+	RootCmd.PersistentFlags().StringVar(&rootCmdReplay, "replay", "", "serve http responses from the specified cassette directory instead of the network")
+	RootCmd.PersistentFlags().StringSliceVar(&rootCmdReplayMatch, "replay-match", httplog.DefaultMatchRules, "the parts of requests that should match the recorded ones: method, scheme, host, path, query, body, header:<name>")
+	RootCmd.PersistentFlags().BoolVar(&rootCmdRecordUnsafe, "record-unsafe", false, "keep credentials and tokens in --har files and --record cassettes (do not use in CI)")
+	RootCmd.PersistentFlags().IntVar(&rootCmdRetries, "retries", 3, "retry requests that failed because of network errors or with the status 429, 502, 503 or 504 the specified number of times (interrupted response bodies are not retried)")
+	RootCmd.PersistentFlags().DurationVar(&rootCmdRetryMaxWait, "retry-max-wait", 30*time.Second, "the maximum delay before a retry")
+	RootCmd.PersistentFlags().StringVar(&rootCmdProgress, "progress", "auto", "show the progress of blob transfers on stderr: bar, lines, none, or auto (bars on a terminal, nothing otherwise)")
+	RootCmd.PersistentFlags().DurationVar(&rootCmdProgressInterval, "progress-interval", 10*time.Second, "the interval between progress lines")
+	RootCmd.PersistentFlags().StringVar(&rootCmdLimitRate, "limit-rate", "", "limit the total bandwidth of all transfers to the specified number of bytes per second (e.g. 500K, 10M)")
+	RootCmd.PersistentFlags().BoolVar(&rootCmdCache, "cache", false, "cache manifests and blobs in the cache directory")
+	RootCmd.PersistentFlags().StringVar(&rootCmdRegistriesConf, "registries-conf", "", "read mirrors, insecure and blocked registries and short-name resolution settings from the specified registries.conf file (default $HOME/.config/containers/registries.conf or /etc/containers/registries.conf)")
+	RootCmd.PersistentFlags().StringVar(&rootCmdCacheDir, "cache-dir", "", "use the specified cache directory (implies --cache; default $XDG_CACHE_HOME/boater/blobs)")
 }
 
 func manifestName(named reference.Named) string {
@@ -72,133 +133,344 @@ func manifestName(named reference.Named) string {
 	return reference.TagNameOnly(named).(reference.Tagged).Tag()
 }
 
-func getPassword() (string, bool) {
-	if rootCmdPassword != "" {
-		return rootCmdPassword, true
+// redactURL returns u with credentials masked unless --verbose-unsafe is
+// set.
+func redactURL(u *url.URL) string {
+	if rootCmdVerboseUnsafe {
+		return u.String()
 	}
+	return redact.URL(u)
+}
 
-	if rootCmdPasswordFile != "" {
-		password, err := ioutil.ReadFile(rootCmdPasswordFile)
-		if err != nil {
-			log.Fatal(err)
-		}
+// redactPassword returns the password for logs.
+func redactPassword(password string) string {
+	if rootCmdVerboseUnsafe {
+		return password
+	}
+	return redact.Mask
+}
 
-		return strings.TrimRight(string(password), "\r\n"), true
+func ProxyFromEnvironment(req *http.Request) (*url.URL, error) {
+	u, err := http.ProxyFromEnvironment(req)
+	if u == nil || err != nil {
+		return u, err
+	}
+	if rootCmdVerbose {
+		log.Printf("Using proxy for %s: %s", redactURL(req.URL), redactURL(u))
 	}
+	return u, nil
+}
+
+var (
+	progressOnce sync.Once
+	progressFn   progress.Func
+	progressStop func()
+)
 
-	return "", false
+// progressFunc returns the callback that displays the progress of blob
+// transfers, or nil if the progress should not be shown.
+func progressFunc() progress.Func {
+	progressOnce.Do(func() {
+		mode := rootCmdProgress
+		if mode == "auto" {
+			// Verbose logs would break progress bars.
+			if isatty.IsTerminal(os.Stderr.Fd()) && !rootCmdVerbose {
+				mode = "bar"
+			} else {
+				mode = "none"
+			}
+		}
+		switch mode {
+		case "bar":
+			bar := progress.NewBar(os.Stderr)
+			progressFn, progressStop = bar.Update, bar.Stop
+		case "lines":
+			lines := progress.NewLines(os.Stderr, rootCmdProgressInterval)
+			progressFn, progressStop = lines.Update, lines.Stop
+		case "none":
+		default:
+			log.Fatalf("invalid value for --progress: %q (expected auto, bar, lines or none)", rootCmdProgress)
+		}
+	})
+	return progressFn
 }
 
-func getCredentialsFromConfigJson(configJsonFile string, ref string) ([]credentialprovider.AuthConfig, error) {
-	f, err := os.Open(configJsonFile)
-	if err != nil {
-		return nil, err
+// stopProgress draws the final state of the transfers and stops the progress
+// display.
+func stopProgress() {
+	if progressStop != nil {
+		progressStop()
 	}
-	defer f.Close()
+}
 
-	var dockerConfigJSON credentialprovider.DockerConfigJSON
-	err = json.NewDecoder(f).Decode(&dockerConfigJSON)
-	if err != nil {
-		return nil, err
-	}
+var (
+	limiterOnce sync.Once
+	limiter     *ratelimit.Limiter
+)
 
-	basicKeyring := &credentialprovider.BasicDockerKeyring{}
-	basicKeyring.Add(dockerConfigJSON.Auths)
-	creds, _ := basicKeyring.Lookup(ref)
-	return creds, nil
+// rateLimiter returns the limiter that is shared by all transfers, or nil if
+// the bandwidth is not limited.
+func rateLimiter() *ratelimit.Limiter {
+	limiterOnce.Do(func() {
+		if rootCmdLimitRate == "" {
+			return
+		}
+		rate, err := ratelimit.ParseRate(rootCmdLimitRate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		limiter = ratelimit.NewLimiter(rate)
+	})
+	return limiter
 }
 
-func newCredentialStore(ref string) auth.CredentialStore {
-	password, havePassword := getPassword()
-	if rootCmdUser != "" || havePassword {
-		if rootCmdVerbose {
-			log.Printf("Using credentials provided by command line arguments: %s:%s", rootCmdUser, password)
+var (
+	harOnce sync.Once
+	har     *httplog.HAR
+)
+
+// harLog returns the HAR file that is shared by all transports, or nil if
+// requests should not be saved.
+func harLog() *httplog.HAR {
+	harOnce.Do(func() {
+		if rootCmdHAR == "" {
+			return
 		}
-		return &client.BasicCredentials{
-			Username: rootCmdUser,
-			Password: password,
+		har = &httplog.HAR{
+			Path:    rootCmdHAR,
+			Limit:   rootCmdHARBodySize,
+			Unsafe:  rootCmdRecordUnsafe,
+			Creator: "boater",
 		}
-	}
+	})
+	return har
+}
+
+var (
+	cassetteOnce sync.Once
+	cassette     *httplog.Cassette
+)
 
-	if rootCmdConfigJson != "" {
-		if rootCmdVerbose {
-			log.Printf("Loading credentials from %s...", rootCmdConfigJson)
+// httpCassette returns the cassette for --record or --replay, or nil if
+// neither is set.
+func httpCassette() *httplog.Cassette {
+	cassetteOnce.Do(func() {
+		if rootCmdRecord != "" && rootCmdReplay != "" {
+			log.Fatal("--record and --replay cannot be used together")
 		}
-		creds, err := getCredentialsFromConfigJson(rootCmdConfigJson, ref)
-		if err != nil {
-			log.Fatalf("unable to load credentials from %s: %s", rootCmdConfigJson, err)
+		if rootCmdRecord != "" {
+			cassette = &httplog.Cassette{
+				Dir:    rootCmdRecord,
+				Unsafe: rootCmdRecordUnsafe,
+			}
 		}
-		if len(creds) > 0 {
-			if rootCmdVerbose {
-				log.Printf("Using credentials from config.json: %s:%s", creds[0].Username, creds[0].Password)
+		if rootCmdReplay != "" {
+			match, err := httplog.NewMatcher(rootCmdReplayMatch)
+			if err != nil {
+				log.Fatalf("invalid value for --replay-match: %s", err)
 			}
-			return &client.BasicCredentials{
-				Username: creds[0].Username,
-				Password: creds[0].Password,
+			cassette = &httplog.Cassette{
+				Dir:   rootCmdReplay,
+				Match: match,
 			}
 		}
-	}
+	})
+	return cassette
+}
 
-	if rootCmdVerbose {
-		log.Println("No credentials are found, proceeding as anonymous...")
+// cacheDir returns the directory for the blob cache.
+func cacheDir() string {
+	if rootCmdCacheDir != "" {
+		return rootCmdCacheDir
 	}
-
-	return nil
+	dir, err := cache.DefaultDir()
+	if err != nil {
+		log.Fatalf("unable to find the cache directory: %s", err)
+	}
+	return dir
 }
 
-func ProxyFromEnvironment(req *http.Request) (*url.URL, error) {
-	u, err := http.ProxyFromEnvironment(req)
-	if u == nil || err != nil {
-		return u, err
+// blobCache returns the blob cache, or nil if caching is disabled.
+func blobCache() *cache.Cache {
+	if !rootCmdCache && rootCmdCacheDir == "" {
+		return nil
 	}
-	if rootCmdVerbose {
-		log.Printf("Using proxy for %s: %s", req.URL, u)
-	}
-	return u, nil
+	return cache.New(cacheDir())
 }
 
 func newTransport() http.RoundTripper {
-	t := &http.Transport{
-		Proxy: ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		DualStack: true,
+	}
+	base := &http.Transport{
+		Proxy:                 ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
 		MaxIdleConns:          100,
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 	}
-	if rootCmdInsecure {
-		t.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
+	t := &tlsconfig.HostTransport{
+		NewTransport: func(host string) (http.RoundTripper, error) {
+			config, err := tlsConfig(host)
+			if err != nil {
+				return nil, err
+			}
+			t := base.Clone()
+			t.TLSClientConfig = config
+			if socket := endpointResolver().Socket(host); socket != "" {
+				t.Proxy = nil
+				t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socket)
+				}
+			}
+			return t, nil
+		},
 	}
 
 	rt := http.RoundTripper(t)
+	if c := httpCassette(); c != nil {
+		if rootCmdReplay != "" {
+			rt = &httplog.ReplayRoundTripper{Cassette: c}
+		} else {
+			rt = &httplog.RecordRoundTripper{
+				RoundTripper: rt,
+				Cassette:     c,
+			}
+		}
+	}
+	if h := harLog(); h != nil {
+		rt = &httplog.HARRoundTripper{
+			RoundTripper: rt,
+			HAR:          h,
+		}
+	}
+	if l := rateLimiter(); l != nil {
+		rt = &ratelimit.RoundTripper{
+			RoundTripper: rt,
+			Limiter:      l,
+		}
+	}
 	if rootCmdVerbose {
 		rt = &httplog.RoundTripper{
 			RoundTripper: rt,
-			N:            256,
+			N:            rootCmdVerboseBodySize,
+			Unsafe:       rootCmdVerboseUnsafe,
+		}
+	}
+	if rootCmdRetries > 0 {
+		rt = &retry.RoundTripper{
+			RoundTripper: rt,
+			Retries:      rootCmdRetries,
+			MaxWait:      rootCmdRetryMaxWait,
+			Verbose:      rootCmdVerbose,
 		}
 	}
 	return rt
 }
 
-func newClient(ref string, actions []string) *client.Client {
-	creds := newCredentialStore(ref)
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// setupClient returns a client for the repository ref that is authorized for
+// the actions.
+func setupClient(ref string, actions []string) (*client.Client, error) {
+	ref, err := resolveShortName(ref, actions)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := credentialCandidates(ref)
 	transport := newTransport()
 
-	client, err := client.New(ref, rootCmdInsecure, transport)
+	// The endpoints are set below.
+	c, err := client.New(ref, false, transport)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	err = client.Auth(creds, client.Scope(), actions...)
+	endpoints, err := registryEndpoints(c.Named(), actions)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	if endpoints == nil {
+		endpoints = []client.Endpoint{{
+			Named:    c.Named(),
+			Insecure: allowHTTP(reference.Domain(c.Named())),
+		}}
+	}
+	for i := range endpoints {
+		endpoints[i].Base = endpointResolver().Resolve(reference.Domain(endpoints[i].Named))
+	}
+	c.SetEndpoints(endpoints)
+
+	var creds auth.CredentialStore
+	var used *credential
+	if len(candidates) > 0 {
+		creds = candidates[0].BasicCredentials
+		used = &candidates[0]
+	}
+
+	// Clients that push check whether manifests and blobs exist in their
+	// repositories, so they should not get them from the cache.
+	bc := blobCache()
+	useCache := bc != nil && !containsString(actions, "push")
+	switch {
+	case len(candidates) > 1:
+		// Try each candidate and then anonymous access, as kubelet does.
+		// The candidates are checked immediately, so the cache does not
+		// save requests to the registry.
+		stores := make([]auth.CredentialStore, 0, len(candidates)+1)
+		for _, candidate := range candidates {
+			stores = append(stores, candidate.BasicCredentials)
+		}
+		i, err := c.AuthCandidates(append(stores, nil), c.Scope(), actions...)
+		if err != nil {
+			return nil, err
+		}
+		used = nil
+		if i < len(candidates) {
+			used = &candidates[i]
+		}
+		if used == nil {
+			log.Printf("None of %d credentials for %s are accepted, proceeding as anonymous...", len(candidates), reference.Domain(c.Named()))
+		} else if rootCmdVerbose {
+			log.Printf("Using credentials #%d of %d", i+1, len(candidates))
+		}
+		if useCache {
+			c.SetCache(bc)
+		}
+	case useCache:
+		c.SetCache(bc)
+		c.SetAuth(creds, c.Scope(), actions...)
+	default:
+		err = c.Auth(creds, c.Scope(), actions...)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	if rootCmdShowCredentialSource {
+		showCredentialSource(reference.Domain(c.Named()), used)
+	}
+
+	c.SetProgressFunc(progressFunc())
+
+	return c, nil
+}
+
+func newClient(ref string, actions []string) *client.Client {
+	client, err := setupClient(ref, actions)
+	if err != nil {
+		log.Fatal(err)
+	}
 	return client
 }
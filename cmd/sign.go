@@ -0,0 +1,395 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/docker/libtrust"
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/cobra"
+
+	"github.com/dmage/boater/pkg/client"
+	"github.com/dmage/boater/pkg/manifests"
+	"github.com/dmage/boater/pkg/signature"
+)
+
+// fetchSignatures returns the manifest that is tagged using the signature tag
+// schema for the manifest dgst, or nil if the tag does not exist.
+func fetchSignatures(c *client.Client, dgst digest.Digest) (*manifests.Schema2, error) {
+	resp, err := c.GetManifest(signature.Tag(dgst), client.GetManifestOptions{
+		AcceptSchema2:   true,
+		AcceptOCISchema: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, readResponseError(resp)
+	}
+
+	var m manifests.Schema2
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("decode signatures: %s", err)
+	}
+	return &m, nil
+}
+
+// pushSignatureTag adds the signature layer ld to the manifest that is tagged
+// using the signature tag schema for the manifest dgst.
+func pushSignatureTag(c *client.Client, dgst digest.Digest, ld manifests.LayerDescriptor) (digest.Digest, error) {
+	m, err := fetchSignatures(c, dgst)
+	if err != nil {
+		return "", err
+	}
+	if m == nil {
+		m = &manifests.Schema2{
+			SchemaVersion: 2,
+			MediaType:     manifests.MediaTypeOCIManifest,
+		}
+	}
+
+	found := false
+	for _, l := range m.Layers {
+		if l.Digest == ld.Digest && l.Annotations[signature.AnnotationSignature] == ld.Annotations[signature.AnnotationSignature] {
+			found = true
+			break
+		}
+	}
+	if !found {
+		m.Layers = append(m.Layers, ld)
+	}
+
+	// The payloads are stored uncompressed, so their diff IDs are the same
+	// as their digests.
+	var config manifests.ImageConfig
+	config.RootFS.Type = "layers"
+	for _, l := range m.Layers {
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, l.Digest.String())
+	}
+	configData, err := manifests.MarshalJSON(config)
+	if err != nil {
+		return "", err
+	}
+	m.Config = manifests.ConfigDescriptor{
+		Descriptor: manifests.Descriptor{
+			MediaType: manifests.MediaTypeOCIImageConfig,
+			Size:      int64(len(configData)),
+			Digest:    digest.FromBytes(configData),
+		},
+	}
+	if err := putBlob(c, m.Config.Digest, bytes.NewReader(configData), m.Config.Size); err != nil {
+		return "", err
+	}
+
+	data, err := manifests.MarshalJSON(m)
+	if err != nil {
+		return "", err
+	}
+	return putManifest(c, signature.Tag(dgst), m.MediaType, data)
+}
+
+func logSkippedSignature(format string, args ...interface{}) {
+	if rootCmdVerbose {
+		log.Printf("skipping signature "+format, args...)
+	}
+}
+
+// verifySignatures checks the simple signing layers of the manifest m and
+// returns the payloads with valid signatures for the manifest dgst. Signatures
+// that cannot be verified are reported only in verbose mode, as they are
+// usually made with other keys.
+func verifySignatures(c *client.Client, m *manifests.Schema2, dgst digest.Digest, pub crypto.PublicKey) []signature.Payload {
+	var payloads []signature.Payload
+	for _, l := range m.Layers {
+		if l.MediaType != signature.MediaTypeSimpleSigning {
+			continue
+		}
+		b64, ok := l.Annotations[signature.AnnotationSignature]
+		if !ok {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			logSkippedSignature("%s: decode signature: %s", l.Digest, err)
+			continue
+		}
+		data, err := fetchBlob(c, l.Digest)
+		if err != nil {
+			logSkippedSignature("%s: %s", l.Digest, err)
+			continue
+		}
+		if err := signature.Verify(pub, data, sig); err != nil {
+			logSkippedSignature("%s: %s", l.Digest, err)
+			continue
+		}
+		payload, err := signature.ParsePayload(data)
+		if err != nil {
+			logSkippedSignature("%s: %s", l.Digest, err)
+			continue
+		}
+		if payload.Critical.Image.DockerManifestDigest != dgst {
+			logSkippedSignature("%s: signature is for %s", l.Digest, payload.Critical.Image.DockerManifestDigest)
+			continue
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+// verifySchema1 returns the key IDs of the valid JWS signatures of the signed
+// schema 1 manifest data that are made with the key pub.
+func verifySchema1(data []byte, pub crypto.PublicKey) ([]string, error) {
+	key, err := libtrust.FromCryptoPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	signatures, err := manifests.VerifySchema1(data)
+	if err != nil {
+		return nil, err
+	}
+	var keyIDs []string
+	for _, sig := range signatures {
+		if sig.Err != nil {
+			logSkippedSignature("%s: %s", sig.KeyID, sig.Err)
+			continue
+		}
+		if sig.KeyID == key.KeyID() {
+			keyIDs = append(keyIDs, sig.KeyID)
+		}
+	}
+	return keyIDs, nil
+}
+
+var signOpts struct {
+	Key         string
+	Referrer    bool
+	Annotations []string
+}
+
+var signCmd = &cobra.Command{
+	Use:   "sign <name>[:<tag>|@<digest>] --key <key.pem>",
+	Short: "Sign a manifest",
+	Long: `Sign a manifest.
+
+Creates a simple signing payload for the manifest, signs it with the ECDSA or
+ed25519 private key and stores the signature in the way cosign does: as a
+layer of the manifest tagged sha256-<hex>.sig. With --referrer the signature is
+pushed as an OCI artifact that has the manifest as its subject.
+
+The private key must be an unencrypted PEM file, see generate-key-pair.
+
+Examples:
+  # Sign the image.
+  boater sign example.com/app:v1 --key boater.key
+
+  # Sign the image and record the commit in the payload.
+  boater sign example.com/app:v1 --key boater.key -a commit=0123abc
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 || signOpts.Key == "" {
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		key, err := signature.LoadPrivateKey(signOpts.Key)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		optional := map[string]interface{}{}
+		for _, a := range signOpts.Annotations {
+			k, v, err := parseKeyValue(a)
+			if err != nil {
+				log.Fatal(err)
+			}
+			optional[k] = v
+		}
+
+		c := newClient(args[0], []string{"pull", "push"})
+
+		m, err := fetchManifest(c, manifestName(c.Named()))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		payload := signature.NewPayload(c.Named().Name(), m.Digest)
+		if len(optional) > 0 {
+			payload.Optional = optional
+		}
+		payloadData, err := manifests.MarshalJSON(payload)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sig, err := signature.Sign(key, payloadData)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		ld := manifests.LayerDescriptor{
+			Descriptor: manifests.Descriptor{
+				MediaType: signature.MediaTypeSimpleSigning,
+				Size:      int64(len(payloadData)),
+				Digest:    digest.FromBytes(payloadData),
+				Annotations: map[string]string{
+					signature.AnnotationSignature: base64.StdEncoding.EncodeToString(sig),
+				},
+			},
+		}
+		if err := putBlob(c, ld.Digest, bytes.NewReader(payloadData), ld.Size); err != nil {
+			log.Fatal(err)
+		}
+
+		var dgst digest.Digest
+		if signOpts.Referrer {
+			config, err := putEmptyConfig(c)
+			if err != nil {
+				log.Fatal(err)
+			}
+			dgst, err = pushReferrer(c, m, manifests.Schema2{
+				SchemaVersion: 2,
+				MediaType:     manifests.MediaTypeOCIManifest,
+				ArtifactType:  signature.ArtifactType,
+				Config:        config,
+				Layers:        []manifests.LayerDescriptor{ld},
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			dgst, err = pushSignatureTag(c, m.Digest, ld)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		fmt.Println(dgst)
+	},
+}
+
+var verifyOpts struct {
+	Pub string
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <name>[:<tag>|@<digest>] --pub <pub.pem>",
+	Short: "Verify signatures of a manifest",
+	Long: `Verify signatures of a manifest.
+
+Looks for simple signing signatures in the manifest tagged sha256-<hex>.sig and
+in the referrers of the manifest, and prints the payloads that are signed by
+the public key. For signed schema 1 manifests, the embedded JWS signatures are
+checked as well. Exits with a non-zero status if there are no valid signatures.
+
+Examples:
+  # Verify the image.
+  boater verify example.com/app:v1 --pub boater.pub
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 || verifyOpts.Pub == "" {
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		pub, err := signature.LoadPublicKey(verifyOpts.Pub)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		c := newClient(args[0], []string{"pull"})
+
+		m, err := fetchManifest(c, manifestName(c.Named()))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var keyIDs []string
+		if m.MediaType == manifests.MediaTypeSchema1Signed {
+			keyIDs, err = verifySchema1(m.Data, pub)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		var candidates []*manifests.Schema2
+		sigs, err := fetchSignatures(c, m.Digest)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if sigs != nil {
+			candidates = append(candidates, sigs)
+		}
+
+		// The signatures from the tag are enough if the referrers cannot be
+		// listed.
+		referrers, err := c.Referrers(m.Digest, signature.ArtifactType)
+		if err != nil {
+			log.Printf("Unable to get referrers of %s: %s", m.Digest, err)
+		}
+		for _, md := range referrers {
+			blob, err := fetchManifest(c, md.Digest.String())
+			if err != nil {
+				log.Fatal(err)
+			}
+			var referrer manifests.Schema2
+			if err := json.Unmarshal(blob.Data, &referrer); err != nil {
+				log.Fatalf("decode %s: %s", md.Digest, err)
+			}
+			candidates = append(candidates, &referrer)
+		}
+
+		var payloads []signature.Payload
+		for _, candidate := range candidates {
+			payloads = append(payloads, verifySignatures(c, candidate, m.Digest, pub)...)
+		}
+		if len(payloads) == 0 && len(keyIDs) == 0 {
+			log.Fatalf("no valid signatures found for %s@%s", c.Named().Name(), m.Digest)
+		}
+
+		for _, payload := range payloads {
+			data, err := manifests.MarshalJSON(payload)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(string(data))
+		}
+		for _, keyID := range keyIDs {
+			fmt.Printf("JWS signature by %s\n", keyID)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(signCmd)
+	RootCmd.AddCommand(verifyCmd)
+
+	signCmd.Flags().StringVar(&signOpts.Key, "key", "", "the private key to sign the manifest with (required)")
+	signCmd.Flags().BoolVar(&signOpts.Referrer, "referrer", false, "store the signature as an OCI referrer instead of the sha256-<hex>.sig tag")
+	signCmd.Flags().StringArrayVarP(&signOpts.Annotations, "annotation", "a", nil, "add the annotation to the signed payload (KEY=VALUE)")
+
+	verifyCmd.Flags().StringVar(&verifyOpts.Pub, "pub", "", "the public key to verify signatures with (required)")
+}
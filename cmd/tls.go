@@ -0,0 +1,155 @@
+// Copyright © 2022 Oleg Bulatov <oleg@bulatov.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/dmage/boater/pkg/client"
+	"github.com/dmage/boater/pkg/config"
+	"github.com/dmage/boater/pkg/tlsconfig"
+)
+
+var (
+	configOnce   sync.Once
+	boaterConfig *config.Config
+)
+
+// configFile returns the configuration from the boater configuration file, or
+// nil if there is no configuration file.
+func configFile() *config.Config {
+	configOnce.Do(func() {
+		path := rootCmdConfig
+		if path == "" {
+			defaultPath, err := config.DefaultPath()
+			if err != nil {
+				return
+			}
+			if _, err := os.Stat(defaultPath); err != nil {
+				return
+			}
+			path = defaultPath
+		}
+
+		c, err := config.Load(path)
+		if err != nil {
+			log.Fatalf("unable to load configuration: %s", err)
+		}
+		if rootCmdVerbose {
+			log.Printf("Loaded configuration from %s", path)
+		}
+		boaterConfig = c
+	})
+	return boaterConfig
+}
+
+// insecureHost returns true if the host is marked as insecure in
+// registries.conf, which allows both plain HTTP and unverified certificates.
+func insecureHost(host string) bool {
+	conf := registriesConf()
+	return conf != nil && conf.InsecureHost(host)
+}
+
+// lookupRegistryConfig returns the entry of the configuration file for the
+// registry host. Both registry domains and the hosts that serve them are
+// accepted, so docker.io and index.docker.io share one entry.
+func lookupRegistryConfig(c *config.Config, host string) (config.Registry, bool) {
+	if r, ok := c.Registries[host]; ok {
+		return r, true
+	}
+	var names []string
+	for name := range c.Registries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if client.RegistryHost(name) == client.RegistryHost(host) {
+			return c.Registries[name], true
+		}
+	}
+	return config.Registry{}, false
+}
+
+// registryConfig returns the settings from the configuration file for the
+// registry host. If the host serves endpoints of other registries, for
+// example, a repository manager with path-prefixed endpoints, the settings of
+// those registries are used.
+func registryConfig(host string) config.Registry {
+	c := configFile()
+	if c == nil {
+		return config.Registry{}
+	}
+	if r, ok := lookupRegistryConfig(c, host); ok {
+		return r
+	}
+	for _, domain := range endpointResolver().Domains(host) {
+		if r, ok := lookupRegistryConfig(c, domain); ok {
+			return r
+		}
+	}
+	return config.Registry{}
+}
+
+// allowHTTP returns true if requests to the host may fall back to plain
+// HTTP.
+func allowHTTP(host string) bool {
+	return rootCmdInsecure || rootCmdAllowHTTP || registryConfig(host).AllowHTTP || insecureHost(host)
+}
+
+// skipTLSVerify returns true if the certificate of the host should not be
+// verified.
+func skipTLSVerify(host string) bool {
+	return rootCmdInsecure || rootCmdSkipTLSVerify || registryConfig(host).SkipTLSVerify || insecureHost(host)
+}
+
+// tlsConfig returns the TLS configuration for the host. The certificates from
+// the command line and from the configuration file are used together with the
+// certificates from the certs.d directories.
+func tlsConfig(host string) (*tls.Config, error) {
+	r := registryConfig(host)
+
+	opts := &tlsconfig.Options{
+		SkipVerify: skipTLSVerify(host),
+	}
+	if err := opts.AddCertsDir(tlsconfig.DefaultCertsDirs(), host); err != nil {
+		return nil, fmt.Errorf("%s: %w", host, err)
+	}
+	if rootCmdCAFile != "" {
+		opts.CAFiles = append(opts.CAFiles, rootCmdCAFile)
+	}
+	if r.CAFile != "" {
+		opts.CAFiles = append(opts.CAFiles, r.CAFile)
+	}
+	if rootCmdCert != "" || rootCmdKey != "" {
+		opts.CertFile, opts.KeyFile = rootCmdCert, rootCmdKey
+	}
+	if r.CertFile != "" || r.KeyFile != "" {
+		opts.CertFile, opts.KeyFile = r.CertFile, r.KeyFile
+	}
+
+	config, err := opts.Config()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", host, err)
+	}
+	if rootCmdVerbose {
+		log.Printf("TLS settings for %s: %d additional CA file(s), client certificate %q, skip verify %t", host, len(opts.CAFiles), opts.CertFile, opts.SkipVerify)
+	}
+	return config, nil
+}
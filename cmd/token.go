@@ -23,7 +23,6 @@ import (
 	"net/url"
 	"os"
 
-	"github.com/dmage/boater/pkg/client"
 	"github.com/docker/distribution/registry/client/auth/challenge"
 	"github.com/spf13/cobra"
 )
@@ -51,14 +50,14 @@ Examples:
 		}
 
 		schemes := []string{"https"}
-		if rootCmdInsecure {
+		if allowHTTP(host) && endpointResolver().Resolve(host) == nil {
 			schemes = append(schemes, "http")
 		}
 
 		var resp *http.Response
 		var err error
 		for _, scheme := range schemes {
-			resp, err = httpClient.Get(client.URL(scheme, host, "/v2/"))
+			resp, err = httpClient.Get(endpointResolver().URL(scheme, host, "/v2/"))
 			if err == nil {
 				break
 			}
@@ -0,0 +1,325 @@
+// Package cache implements a content-addressable local cache for manifests
+// and blobs.
+//
+// Blobs are stored as <dir>/<algorithm>/<hex>. The media types of manifests
+// are stored next to them in <hex>.mediatype files. The digests of tags are
+// stored in <dir>/tags/<domain>/<path>/@<tag>. The modification times of
+// blobs are updated when they are used, so that the least recently used blobs
+// can be pruned.
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const mediaTypeSuffix = ".mediatype"
+
+// Cache is a directory with blobs addressed by their digests. It is safe for
+// concurrent use, including by several processes.
+type Cache struct {
+	dir string
+}
+
+// DefaultDir returns the default cache directory,
+// $XDG_CACHE_HOME/boater/blobs.
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "boater", "blobs"), nil
+}
+
+// New returns a cache that is stored in dir. The directory is created when
+// the first blob is stored.
+func New(dir string) *Cache {
+	return &Cache{
+		dir: dir,
+	}
+}
+
+func (c *Cache) blobPath(dgst digest.Digest) (string, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+	return filepath.Join(c.dir, dgst.Algorithm().String(), dgst.Encoded()), nil
+}
+
+// Open returns the blob dgst and marks it as recently used. If the blob is
+// not cached, an error that satisfies os.IsNotExist is returned.
+func (c *Cache) Open(dgst digest.Digest) (*os.File, error) {
+	path, err := c.blobPath(dgst)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return f, nil
+}
+
+// MediaType returns the media type of the manifest dgst, or an empty string
+// if the blob is not a cached manifest.
+func (c *Cache) MediaType(dgst digest.Digest) string {
+	path, err := c.blobPath(dgst)
+	if err != nil {
+		return ""
+	}
+	buf, err := ioutil.ReadFile(path + mediaTypeSuffix)
+	if err != nil {
+		return ""
+	}
+	return string(buf)
+}
+
+// Writer stores a blob in the cache. The blob becomes visible after it is
+// committed.
+type Writer struct {
+	cache     *Cache
+	expected  digest.Digest
+	mediaType string
+	f         *os.File
+	digester  digest.Digester
+}
+
+// NewWriter returns a writer for a blob with the digest expected, or with an
+// unknown digest if expected is empty. If mediaType is not empty, the blob is
+// stored as a manifest with this media type.
+func (c *Cache) NewWriter(expected digest.Digest, mediaType string) (*Writer, error) {
+	algorithm := digest.Canonical
+	if expected != "" {
+		if err := expected.Validate(); err != nil {
+			return nil, err
+		}
+		algorithm = expected.Algorithm()
+	}
+
+	tmpdir := filepath.Join(c.dir, "tmp")
+	if err := os.MkdirAll(tmpdir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile(tmpdir, "blob")
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		cache:     c,
+		expected:  expected,
+		mediaType: mediaType,
+		f:         f,
+		digester:  algorithm.Digester(),
+	}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.digester.Hash().Write(p[:n])
+	return n, err
+}
+
+// Discard removes the data that has been written.
+func (w *Writer) Discard() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
+
+// Commit verifies the digest of the written data and moves it into the cache.
+func (w *Writer) Commit() (digest.Digest, error) {
+	if err := w.f.Close(); err != nil {
+		os.Remove(w.f.Name())
+		return "", err
+	}
+
+	dgst := w.digester.Digest()
+	if w.expected != "" && dgst != w.expected {
+		os.Remove(w.f.Name())
+		return "", fmt.Errorf("blob %s has unexpected digest %s", w.expected, dgst)
+	}
+
+	path, err := w.cache.blobPath(dgst)
+	if err != nil {
+		os.Remove(w.f.Name())
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		os.Remove(w.f.Name())
+		return "", err
+	}
+	if w.mediaType != "" {
+		if err := writeFileAtomic(path+mediaTypeSuffix, []byte(w.mediaType)); err != nil {
+			os.Remove(w.f.Name())
+			return "", err
+		}
+	}
+	if err := os.Rename(w.f.Name(), path); err != nil {
+		os.Remove(w.f.Name())
+		return "", err
+	}
+	return dgst, nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// TagEntry is the state of a tag when its manifest was fetched.
+type TagEntry struct {
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType"`
+	ETag      string        `json:"etag,omitempty"`
+}
+
+func (c *Cache) tagPath(domain, path, tag string) string {
+	return filepath.Join(c.dir, "tags", domain, filepath.FromSlash(path), "@"+tag)
+}
+
+// Tag returns the cached state of the tag in the repository domain/path, or
+// nil if the tag is not cached.
+func (c *Cache) Tag(domain, path, tag string) (*TagEntry, error) {
+	buf, err := ioutil.ReadFile(c.tagPath(domain, path, tag))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entry TagEntry
+	if err := json.Unmarshal(buf, &entry); err != nil {
+		return nil, fmt.Errorf("decode cached tag %s/%s:%s: %w", domain, path, tag, err)
+	}
+	return &entry, nil
+}
+
+// SetTag stores the state of the tag in the repository domain/path.
+func (c *Cache) SetTag(domain, path, tag string, entry TagEntry) error {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	filename := c.tagPath(domain, path, tag)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, buf)
+}
+
+type blobFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// blobs returns all cached blobs.
+func (c *Cache) blobs() ([]blobFile, error) {
+	entries, err := ioutil.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var blobs []blobFile
+	for _, algorithm := range entries {
+		if !algorithm.IsDir() || !digest.Algorithm(algorithm.Name()).Available() {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(c.dir, algorithm.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range files {
+			if !fi.Mode().IsRegular() || strings.HasPrefix(fi.Name(), ".") || strings.HasSuffix(fi.Name(), mediaTypeSuffix) {
+				continue
+			}
+			blobs = append(blobs, blobFile{
+				path:    filepath.Join(c.dir, algorithm.Name(), fi.Name()),
+				size:    fi.Size(),
+				modTime: fi.ModTime(),
+			})
+		}
+	}
+	return blobs, nil
+}
+
+// PruneResult describes the blobs that were removed by Prune.
+type PruneResult struct {
+	Removed   int   // the number of removed blobs
+	Freed     int64 // the total size of removed blobs
+	Remaining int64 // the total size of the remaining blobs
+}
+
+// Prune removes the least recently used blobs until the total size of the
+// cache does not exceed maxSize.
+func (c *Cache) Prune(maxSize int64) (PruneResult, error) {
+	var result PruneResult
+
+	blobs, err := c.blobs()
+	if err != nil {
+		return result, err
+	}
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].modTime.Before(blobs[j].modTime)
+	})
+
+	for _, b := range blobs {
+		result.Remaining += b.size
+	}
+	var errs []string
+	for _, b := range blobs {
+		if result.Remaining <= maxSize {
+			break
+		}
+		if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err.Error())
+			continue
+		}
+		os.Remove(b.path + mediaTypeSuffix)
+		result.Removed++
+		result.Freed += b.size
+		result.Remaining -= b.size
+	}
+
+	// Clean up leftovers from interrupted downloads.
+	tmpdir := filepath.Join(c.dir, "tmp")
+	if files, err := ioutil.ReadDir(tmpdir); err == nil {
+		for _, fi := range files {
+			if time.Since(fi.ModTime()) > 24*time.Hour {
+				os.Remove(filepath.Join(tmpdir, fi.Name()))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return result, errors.New(strings.Join(errs, "; "))
+	}
+	return result, nil
+}
@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func writeBlob(t *testing.T, c *Cache, expected digest.Digest, mediaType string, data string) (digest.Digest, error) {
+	t.Helper()
+	w, err := c.NewWriter(expected, mediaType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	return w.Commit()
+}
+
+func readBlob(t *testing.T, c *Cache, dgst digest.Digest) string {
+	t.Helper()
+	f, err := c.Open(dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestCommit(t *testing.T) {
+	c := New(t.TempDir())
+	data := `{"schemaVersion":2}`
+	want := digest.FromString(data)
+
+	testCases := []struct {
+		name      string
+		expected  digest.Digest
+		mediaType string
+	}{
+		{name: "known digest", expected: want, mediaType: "application/vnd.oci.image.manifest.v1+json"},
+		{name: "unknown digest", expected: ""},
+	}
+	for _, tc := range testCases {
+		dgst, err := writeBlob(t, c, tc.expected, tc.mediaType, data)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if dgst != want {
+			t.Errorf("%s: got digest %s, want %s", tc.name, dgst, want)
+		}
+		if got := readBlob(t, c, dgst); got != data {
+			t.Errorf("%s: got %q, want %q", tc.name, got, data)
+		}
+		if tc.mediaType != "" {
+			if got := c.MediaType(dgst); got != tc.mediaType {
+				t.Errorf("%s: got media type %q, want %q", tc.name, got, tc.mediaType)
+			}
+		}
+	}
+}
+
+func TestCommitUnexpectedDigest(t *testing.T) {
+	c := New(t.TempDir())
+	expected := digest.FromString("expected")
+
+	if _, err := writeBlob(t, c, expected, "", "corrupted"); err == nil {
+		t.Fatal("expected an error for data with a wrong digest")
+	}
+	if _, err := c.Open(expected); !os.IsNotExist(err) {
+		t.Errorf("the blob with a wrong digest is stored: %v", err)
+	}
+	if _, err := c.Open(digest.FromString("corrupted")); !os.IsNotExist(err) {
+		t.Errorf("the data with a wrong digest is stored under its own digest: %v", err)
+	}
+	files, _ := ioutil.ReadDir(filepath.Join(c.dir, "tmp"))
+	if len(files) != 0 {
+		t.Errorf("temporary files are left: %d", len(files))
+	}
+
+	if _, err := c.NewWriter("sha256:invalid", ""); err == nil {
+		t.Error("expected an error for an invalid digest")
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	c := New(t.TempDir())
+	w, err := c.NewWriter("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("partial"))
+	w.Discard()
+	if _, err := c.Open(digest.FromString("partial")); !os.IsNotExist(err) {
+		t.Errorf("the discarded blob is stored: %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	c := New(t.TempDir())
+
+	// Blobs of 10 bytes each, from the least recently used to the most
+	// recently used.
+	names := []string{"blob-0000", "blob-0001", "blob-0002", "blob-0003"}
+	var digests []digest.Digest
+	base := time.Now().Add(-time.Hour)
+	for i, name := range names {
+		dgst, err := writeBlob(t, c, "", "text/plain", name+"\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, _ := c.blobPath(dgst)
+		mtime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		digests = append(digests, dgst)
+	}
+
+	// Using the oldest blob makes it the most recently used one.
+	readBlob(t, c, digests[0])
+
+	result, err := c.Prune(25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Removed != 2 || result.Freed != 20 || result.Remaining != 20 {
+		t.Errorf("got %+v, want 2 removed blobs, 20 bytes freed and 20 bytes remaining", result)
+	}
+
+	for i, dgst := range digests {
+		_, err := c.Open(dgst)
+		removed := os.IsNotExist(err)
+		wantRemoved := i == 1 || i == 2
+		if removed != wantRemoved {
+			t.Errorf("blob %d: removed = %t, want %t", i, removed, wantRemoved)
+		}
+		if wantRemoved && c.MediaType(dgst) != "" {
+			t.Errorf("blob %d: the media type is not removed", i)
+		}
+	}
+
+	result, err = c.Prune(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Removed != 2 || result.Remaining != 0 {
+		t.Errorf("got %+v, want 2 removed blobs and nothing remaining", result)
+	}
+}
+
+func TestTag(t *testing.T) {
+	c := New(t.TempDir())
+
+	entry, err := c.Tag("example.com", "ns/app", "latest")
+	if err != nil || entry != nil {
+		t.Fatalf("got %+v, %v for a missing tag, want nil, nil", entry, err)
+	}
+
+	want := TagEntry{
+		Digest:    digest.FromString("manifest"),
+		MediaType: "application/vnd.oci.image.index.v1+json",
+		ETag:      `"abc"`,
+	}
+	if err := c.SetTag("example.com", "ns/app", "latest", want); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = c.Tag("example.com", "ns/app", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || *entry != want {
+		t.Errorf("got %+v, want %+v", entry, want)
+	}
+
+	if entry, _ := c.Tag("example.com", "ns/other", "latest"); entry != nil {
+		t.Errorf("the tag is visible in another repository: %+v", entry)
+	}
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"io"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/dmage/boater/pkg/cache"
+)
+
+// maxDrainSize is how much data is read from a response body when it is
+// closed, to check whether the whole blob has been read and can be cached.
+const maxDrainSize = 64 * 1024
+
+// cachingReader stores the data that is read from rc in the cache. The blob
+// is committed only if it is read to the end and has the expected digest.
+type cachingReader struct {
+	rc     io.ReadCloser
+	w      *cache.Writer
+	commit func(digest.Digest)
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	if r.w == nil {
+		return n, err
+	}
+	if n > 0 {
+		if _, werr := r.w.Write(p[:n]); werr != nil {
+			r.w.Discard()
+			r.w = nil
+			return n, err
+		}
+	}
+	if err == io.EOF {
+		dgst, cerr := r.w.Commit()
+		r.w = nil
+		if cerr == nil && r.commit != nil {
+			r.commit(dgst)
+		}
+	} else if err != nil {
+		r.w.Discard()
+		r.w = nil
+	}
+	return n, err
+}
+
+func (r *cachingReader) Close() error {
+	if r.w != nil {
+		// Decompressors may stop reading right before the end of the body.
+		buf := make([]byte, 4096)
+		for i := 0; r.w != nil && i < maxDrainSize/len(buf); i++ {
+			if _, err := r.Read(buf); err != nil {
+				break
+			}
+		}
+		if r.w != nil {
+			r.w.Discard()
+			r.w = nil
+		}
+	}
+	return r.rc.Close()
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/dmage/boater/pkg/cache"
+)
+
+// newCachingTestRegistry starts an anonymous registry with a single manifest
+// that is tagged as latest and counts manifest requests by method. If etag is
+// false, the registry does not send ETags.
+func newCachingTestRegistry(t *testing.T, etag bool) (*httptest.Server, map[string]*int32) {
+	dgst := digest.FromString(testManifest)
+	requests := map[string]*int32{
+		"GET":         new(int32),
+		"HEAD":        new(int32),
+		"NotModified": new(int32),
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/v2/repo/manifests/latest" || r.URL.Path == "/v2/repo/manifests/"+dgst.String():
+			atomic.AddInt32(requests[r.Method], 1)
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+			w.Header().Set("Docker-Content-Digest", dgst.String())
+			if etag {
+				w.Header().Set("ETag", `"`+dgst.String()+`"`)
+				if r.Header.Get("If-None-Match") == `"`+dgst.String()+`"` {
+					atomic.AddInt32(requests["NotModified"], 1)
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			}
+			if r.Method == "HEAD" {
+				return
+			}
+			fmt.Fprint(w, testManifest)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests
+}
+
+func getCachedManifest(t *testing.T, c *Client, name string) *http.Response {
+	t.Helper()
+	resp, err := c.GetManifest(name, GetManifestOptions{AcceptSchema2: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := readBody(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != testManifest {
+		t.Fatalf("got manifest %q, want %q", body, testManifest)
+	}
+	return resp
+}
+
+func TestCacheRevalidateTag(t *testing.T) {
+	for _, etag := range []bool{true, false} {
+		srv, requests := newCachingTestRegistry(t, etag)
+		c := newTestClient(t, srv)
+		c.SetCache(cache.New(t.TempDir()))
+		if err := c.Auth(nil, c.Scope(), "pull"); err != nil {
+			t.Fatal(err)
+		}
+
+		getCachedManifest(t, c, "latest")
+		resp := getCachedManifest(t, c, "latest")
+		if got, want := resp.Header.Get("Docker-Content-Digest"), digest.FromString(testManifest).String(); got != want {
+			t.Errorf("etag=%t: the cached response has Docker-Content-Digest %q, want %q", etag, got, want)
+		}
+		if got := resp.Header.Get("Content-Type"); got != "application/vnd.docker.distribution.manifest.v2+json" {
+			t.Errorf("etag=%t: the cached response has Content-Type %q", etag, got)
+		}
+
+		gets, heads, notModified := atomic.LoadInt32(requests["GET"]), atomic.LoadInt32(requests["HEAD"]), atomic.LoadInt32(requests["NotModified"])
+		if etag && (gets != 2 || heads != 0 || notModified != 1) {
+			t.Errorf("with ETag: got %d GET, %d HEAD and %d Not Modified, want a conditional GET", gets, heads, notModified)
+		}
+		if !etag && (gets != 1 || heads != 1) {
+			t.Errorf("without ETag: got %d GET and %d HEAD, want a HEAD request", gets, heads)
+		}
+	}
+}
+
+func TestCacheServesDigests(t *testing.T) {
+	srv, requests := newCachingTestRegistry(t, true)
+	c := newTestClient(t, srv)
+	c.SetCache(cache.New(t.TempDir()))
+	if err := c.Auth(nil, c.Scope(), "pull"); err != nil {
+		t.Fatal(err)
+	}
+
+	dgst := digest.FromString(testManifest).String()
+	getCachedManifest(t, c, dgst)
+	resp := getCachedManifest(t, c, dgst)
+	if got := atomic.LoadInt32(requests["GET"]); got != 1 {
+		t.Errorf("got %d GET requests, want 1", got)
+	}
+	if resp.ContentLength != int64(len(testManifest)) {
+		t.Errorf("the cached response has Content-Length %d, want %d", resp.ContentLength, len(testManifest))
+	}
+	if !strings.HasSuffix(resp.Request.URL.Path, "/v2/repo/manifests/"+dgst) {
+		t.Errorf("the cached response has the request URL %s", resp.Request.URL)
+	}
+
+	// Media types that are not accepted are not served from the cache.
+	_, err := c.GetManifest(dgst, GetManifestOptions{AcceptManifestList: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(requests["GET"]); got != 2 {
+		t.Errorf("got %d GET requests, want 2", got)
+	}
+}
+
+func TestDeferredAuthError(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(srv.Close)
+	c := newTestClient(t, srv)
+	c.SetAuth(nil, c.Scope(), "pull")
+
+	if _, err := c.URL("/v2/%s/tags/list", c.Scope()); err == nil {
+		t.Error("URL: expected the authorization error")
+	}
+	if _, err := c.DeleteManifest("latest"); err == nil {
+		t.Error("DeleteManifest: expected the authorization error")
+	}
+	if _, err := c.GetManifest("latest", GetManifestOptions{AcceptSchema2: true}); err == nil {
+		t.Error("GetManifest: expected the authorization error")
+	}
+}
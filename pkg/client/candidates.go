@@ -0,0 +1,96 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/client"
+	"github.com/docker/distribution/registry/client/auth"
+)
+
+// isAuthError returns true if err means that the registry or its token
+// server did not accept the credentials.
+func isAuthError(err error) bool {
+	var errs errcode.Errors
+	if errors.As(err, &errs) {
+		for _, err := range errs {
+			if isAuthError(err) {
+				return true
+			}
+		}
+		return false
+	}
+	var e errcode.Error
+	if errors.As(err, &e) {
+		return e.Code == errcode.ErrorCodeUnauthorized || e.Code == errcode.ErrorCodeDenied
+	}
+	var code errcode.ErrorCode
+	if errors.As(err, &code) {
+		return code == errcode.ErrorCodeUnauthorized || code == errcode.ErrorCodeDenied
+	}
+	var respErr *client.UnexpectedHTTPResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode == http.StatusUnauthorized || respErr.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// probe checks whether the registry accepts the credentials by requesting the
+// manifest of the repository. The manifest does not have to exist.
+func (c *Client) probe() error {
+	name := "latest"
+	if digested, ok := c.named.(reference.Digested); ok {
+		name = digested.Digest().String()
+	} else if tagged, ok := c.named.(reference.Tagged); ok {
+		name = tagged.Tag()
+	}
+
+	req, err := http.NewRequest("HEAD", c.url("/v2/%s/manifests/%s", c.Scope(), url.PathEscape(name)), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return errcode.ErrorCodeUnauthorized.WithMessage(fmt.Sprintf("%s %s: %s", req.Method, req.URL, resp.Status))
+	}
+	return nil
+}
+
+// AuthCandidates tries the credentials in turn, as kubelet does when an image
+// has several matching pull secrets. A nil CredentialStore means anonymous
+// access. Each candidate is set up with Auth and checked with a request for
+// the manifest; if the registry responds with 401 or 403, the next candidate
+// is tried.
+//
+// It returns the index of the accepted candidate. If none of them are
+// accepted, the errors for all candidates are returned.
+func (c *Client) AuthCandidates(candidates []auth.CredentialStore, scope string, actions ...string) (int, error) {
+	var errs []error
+	for i, creds := range candidates {
+		if err := c.Auth(creds, scope, actions...); err != nil {
+			return -1, err
+		}
+		err := c.probe()
+		if err == nil {
+			return i, nil
+		}
+		if !isAuthError(err) {
+			return -1, err
+		}
+		if creds == nil {
+			err = fmt.Errorf("anonymous: %w", err)
+		} else {
+			err = fmt.Errorf("credentials #%d: %w", i+1, err)
+		}
+		errs = append(errs, err)
+	}
+	return -1, aggregatedError(errs)
+}
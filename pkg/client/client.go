@@ -2,15 +2,23 @@ package client
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/docker/distribution/reference"
 	"github.com/docker/distribution/registry/client/auth"
 	"github.com/docker/distribution/registry/client/auth/challenge"
 	"github.com/docker/distribution/registry/client/transport"
+	"github.com/opencontainers/go-digest"
 	flag "github.com/spf13/pflag"
+
+	"github.com/dmage/boater/pkg/cache"
+	"github.com/dmage/boater/pkg/progress"
 )
 
 type GetManifestOptions struct {
@@ -35,6 +43,45 @@ func (o *GetManifestOptions) AddToFlagSet(fs *flag.FlagSet) {
 	fs.StringArrayVarP(&o.MediaTypes, "accept", "t", o.MediaTypes, "accept manifests with a custom media type")
 }
 
+// mediaTypes returns the media types that should be sent in the Accept
+// header.
+func (o *GetManifestOptions) mediaTypes() []string {
+	var mediaTypes []string
+	if o.AcceptKnown || o.AcceptSchema1 {
+		mediaTypes = append(mediaTypes, "application/vnd.docker.distribution.manifest.v1+json")
+	}
+	if o.AcceptKnown || o.AcceptSchema1Signed {
+		mediaTypes = append(mediaTypes, "application/vnd.docker.distribution.manifest.v1+prettyjws")
+	}
+	if o.AcceptKnown || o.AcceptSchema2 {
+		mediaTypes = append(mediaTypes, "application/vnd.docker.distribution.manifest.v2+json")
+	}
+	if o.AcceptKnown || o.AcceptManifestList {
+		mediaTypes = append(mediaTypes, "application/vnd.docker.distribution.manifest.list.v2+json")
+	}
+	if o.AcceptKnown || o.AcceptOCISchema {
+		mediaTypes = append(mediaTypes, "application/vnd.oci.image.manifest.v1+json")
+	}
+	if o.AcceptKnown || o.AcceptOCIIndex {
+		mediaTypes = append(mediaTypes, "application/vnd.oci.image.index.v1+json")
+	}
+	return append(mediaTypes, o.MediaTypes...)
+}
+
+// accepts returns true if a manifest with the media type mediaType is
+// acceptable for a request with the Accept header mediaTypes.
+func accepts(mediaTypes []string, mediaType string) bool {
+	if len(mediaTypes) == 0 {
+		return true
+	}
+	for _, mt := range mediaTypes {
+		if mt == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
 type aggregatedError []error
 
 func (e aggregatedError) Error() string {
@@ -59,26 +106,42 @@ func (c connectionType) Scheme() string {
 	return "https"
 }
 
+// Client is a client for a repository. It is safe for concurrent use.
 type Client struct {
-	named      reference.Named
-	insecure   bool
-	connection connectionType
-	transport  http.RoundTripper
-	httpClient *http.Client
-}
+	named     reference.Named
+	transport http.RoundTripper
 
-func URL(scheme string, host string, format string, a ...interface{}) string {
-	if host == "docker.io" {
-		host = "index.docker.io"
-	}
+	// mu protects the endpoints, which are updated by Auth, the credentials
+	// for the endpoints that are authorized later, the progress callback and
+	// the cache.
+	mu          sync.RWMutex
+	endpoints   []endpoint
+	active      int
+	authCreds   auth.CredentialStore
+	authScope   string
+	authActions []string
+	progress    progress.Func
+	cache       *cache.Cache
+
+	// authMu protects the authorization that is deferred by SetAuth.
+	authMu       sync.Mutex
+	deferredAuth func() error
+	authErr      error
+}
 
-	u := &url.URL{
-		Scheme: scheme,
-		Host:   host,
-		Path:   fmt.Sprintf(format, a...),
+// RegistryHost returns the host that serves the registry domain.
+func RegistryHost(domain string) string {
+	if domain == "docker.io" {
+		return "index.docker.io"
 	}
-	return u.String()
+	return domain
+}
 
+// URL returns the URL for the path that is built from format and a on the
+// registry domain host. Use Resolver.URL for registries that may have base
+// URLs.
+func URL(scheme string, host string, format string, a ...interface{}) string {
+	return baseURL(nil, scheme, host, fmt.Sprintf(format, a...))
 }
 
 func New(ref string, insecure bool, transport http.RoundTripper) (*Client, error) {
@@ -87,46 +150,211 @@ func New(ref string, insecure bool, transport http.RoundTripper) (*Client, error
 		return nil, err
 	}
 
-	return &Client{
-		named:      named,
-		insecure:   insecure,
-		connection: httpsConnection,
-		transport:  transport,
-		httpClient: &http.Client{
-			Transport: transport,
-		},
-	}, nil
+	c := &Client{
+		named:     named,
+		transport: transport,
+	}
+	c.SetEndpoints([]Endpoint{{
+		Named:    named,
+		Insecure: insecure,
+	}})
+	return c, nil
 }
 
 func (c *Client) Named() reference.Named {
 	return c.named
 }
 
+// Scope returns the path of the repository on the active endpoint.
 func (c *Client) Scope() string {
-	return reference.Path(c.named)
+	e := c.activeEndpoint()
+	return reference.Path(e.Named)
+}
+
+// URL returns the URL for the path that is built from format and a. If
+// authorization is deferred, it is set up first, as the scheme depends on it,
+// and its error is returned.
+func (c *Client) URL(format string, a ...interface{}) (string, error) {
+	if err := c.pendingAuth(); err != nil {
+		return "", err
+	}
+	return c.url(format, a...), nil
+}
+
+func (c *Client) url(format string, a ...interface{}) string {
+	e := c.activeEndpoint()
+	return e.url(format, a...)
 }
 
-func (c *Client) URL(format string, a ...interface{}) string {
-	return URL(c.connection.Scheme(), reference.Domain(c.named), format, a...)
+// ResolveReference resolves the reference ref from a Link or a Location
+// header of a response to a request to u. If the endpoint has a base URL with
+// a path prefix, the prefix is added to absolute paths that do not have it.
+func (c *Client) ResolveReference(u *url.URL, ref string) (*url.URL, error) {
+	e := c.endpointFor(u.Host)
+	return resolveReference(e.Base, u, ref)
 }
 
+// Do sends the request using the endpoint for the host of the request, or
+// using the active endpoint if the host is not an endpoint.
 func (c *Client) Do(req *http.Request) (*http.Response, error) {
-	return c.httpClient.Do(req)
+	if err := c.pendingAuth(); err != nil {
+		return nil, err
+	}
+	e := c.endpointFor(req.URL.Host)
+	return e.httpClient.Do(req)
+}
+
+// SetCache sets the cache that GetManifest and GetBlob use for
+// digest-addressed requests. Manifests fetched by tags are revalidated with
+// conditional requests.
+func (c *Client) SetCache(blobCache *cache.Cache) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache = blobCache
 }
 
-func (c *Client) auth(creds auth.CredentialStore, scope string, actions ...string) error {
-	resp, err := c.httpClient.Get(c.URL("/v2/"))
+func (c *Client) blobCache() *cache.Cache {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cache
+}
+
+// cachedResponse returns a response with the blob dgst from the cache, or
+// nil if the blob is not cached.
+func (c *Client) cachedResponse(blobCache *cache.Cache, path string, dgst digest.Digest, mediaType string) *http.Response {
+	f, err := blobCache.Open(dgst)
+	if err != nil {
+		return nil
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil
+	}
+	req, err := http.NewRequest("GET", c.url("%s", path), nil)
 	if err != nil {
-		return fmt.Errorf("get challenges from /v2/: %s", err)
+		f.Close()
+		return nil
+	}
+
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	header := http.Header{}
+	header.Set("Content-Type", mediaType)
+	header.Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	header.Set("Docker-Content-Digest", dgst.String())
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          f,
+		ContentLength: fi.Size(),
+		Request:       req,
+	}
+}
+
+// cacheManifest makes the response body store the manifest name in the
+// cache after it is read. For tags, the digest and the ETag of the manifest
+// are stored as well.
+func (c *Client) cacheManifest(blobCache *cache.Cache, resp *http.Response, name string) {
+	mediaType := resp.Header.Get("Content-Type")
+	if i := strings.IndexByte(mediaType, ';'); i >= 0 {
+		mediaType = strings.TrimSpace(mediaType[:i])
+	}
+	if mediaType == "" {
+		return
+	}
+
+	expected, err := digest.Parse(name)
+	isTag := err != nil
+	if isTag {
+		expected, _ = digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+	}
+
+	w, err := blobCache.NewWriter(expected, mediaType)
+	if err != nil {
+		return
+	}
+	r := &cachingReader{
+		rc: resp.Body,
+		w:  w,
+	}
+	if isTag {
+		domain, path, etag := reference.Domain(c.named), reference.Path(c.named), resp.Header.Get("ETag")
+		r.commit = func(dgst digest.Digest) {
+			_ = blobCache.SetTag(domain, path, name, cache.TagEntry{
+				Digest:    dgst,
+				MediaType: mediaType,
+				ETag:      etag,
+			})
+		}
+	}
+	resp.Body = r
+}
+
+// SetAuth defers Auth until the first request to the registry, so that
+// responses from the cache do not need the network.
+func (c *Client) SetAuth(creds auth.CredentialStore, scope string, actions ...string) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	c.deferredAuth = func() error {
+		return c.Auth(creds, scope, actions...)
+	}
+}
+
+// pendingAuth runs the deferred Auth, if there is one, and returns its
+// error.
+func (c *Client) pendingAuth() error {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	if c.deferredAuth != nil {
+		c.authErr = c.deferredAuth()
+		c.deferredAuth = nil
+	}
+	return c.authErr
+}
+
+// SetProgressFunc sets the callback that is called as blobs are downloaded by
+// GetBlob and uploaded by PutBlob. Transfers are identified by the digests of
+// the blobs.
+func (c *Client) SetProgressFunc(fn progress.Func) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.progress = fn
+}
+
+func (c *Client) progressFunc() progress.Func {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.progress
+}
+
+// auth returns an HTTP client that authorizes requests to the endpoint using
+// the connection.
+//
+// All requests made by the returned client share one token handler, which
+// fetches tokens while holding a lock and caches them until they expire, so
+// parallel requests cause a single token fetch.
+func (c *Client) auth(e endpoint, connection connectionType, creds auth.CredentialStore, scope string, actions ...string) (*http.Client, error) {
+	httpClient := &http.Client{
+		Transport: c.transport,
+	}
+	resp, err := httpClient.Get(baseURL(e.Base, connection.Scheme(), reference.Domain(e.Named), "/v2/"))
+	if err != nil {
+		return nil, fmt.Errorf("get challenges from /v2/: %s", err)
 	}
 	if resp.StatusCode >= http.StatusInternalServerError {
-		return fmt.Errorf("server responded with error: %d", resp.StatusCode)
+		return nil, fmt.Errorf("server responded with error: %d", resp.StatusCode)
 	}
 	defer resp.Body.Close()
 
 	manager := challenge.NewSimpleManager()
 	if err := manager.AddResponse(resp); err != nil {
-		return fmt.Errorf("add response to challenge manager: %s", err)
+		return nil, fmt.Errorf("add response to challenge manager: %s", err)
 	}
 
 	handlers := []auth.AuthenticationHandler{
@@ -137,70 +365,330 @@ func (c *Client) auth(creds auth.CredentialStore, scope string, actions ...strin
 	}
 
 	authorizer := auth.NewAuthorizer(manager, handlers...)
-	rt := transport.NewTransport(c.transport, authorizer)
-	c.httpClient.Transport = rt
-	return nil
+	httpClient.Transport = transport.NewTransport(c.transport, authorizer)
+	return httpClient, nil
 }
 
-func (c *Client) Auth(creds auth.CredentialStore, scope string, actions ...string) error {
-	connectionTypes := []connectionType{httpsConnection}
-	if c.insecure {
-		connectionTypes = append(connectionTypes, httpConnection)
+// authorize finds the connection type that the endpoint supports and sets
+// up authorization for it.
+func (c *Client) authorize(e endpoint, creds auth.CredentialStore, scope string, actions ...string) (endpoint, error) {
+	if e.Credentials != nil {
+		creds = e.Credentials
+	}
+	if c.isRepositoryScope(scope) {
+		scope = reference.Path(e.Named)
+	}
+
+	var errs []error
+	for _, connection := range e.connectionTypes() {
+		httpClient, err := c.auth(e, connection, creds, scope, actions...)
+		if err == nil {
+			e.connection = connection
+			e.httpClient = httpClient
+			e.authorized = true
+			return e, nil
+		}
+		errs = append(errs, err)
 	}
+	return e, aggregatedError(errs)
+}
+
+// Auth finds the connection type that the registry supports and sets up
+// authorization for requests with the scope and actions. Requests that are
+// made concurrently with Auth use either the old or the new setup.
+//
+// If there are several endpoints, the first endpoint that can be reached
+// becomes active, and the others are authorized when GetManifest falls back
+// to them. Each endpoint uses its own repository path when scope is the path
+// of the repository.
+func (c *Client) Auth(creds auth.CredentialStore, scope string, actions ...string) error {
+	c.mu.RLock()
+	endpoints := append([]endpoint(nil), c.endpoints...)
+	c.mu.RUnlock()
 
 	var errs []error
-	for _, connection := range connectionTypes {
-		c.connection = connection
-		err := c.auth(creds, scope, actions...)
+	for i, e := range endpoints {
+		e, err := c.authorize(e, creds, scope, actions...)
 		if err == nil {
+			c.mu.Lock()
+			c.authCreds = creds
+			c.authScope = scope
+			c.authActions = actions
+			c.endpoints[i] = e
+			c.active = i
+			c.mu.Unlock()
 			return nil
 		}
+		if len(endpoints) > 1 {
+			err = fmt.Errorf("%s: %w", reference.Domain(e.Named), err)
+		}
 		errs = append(errs, err)
 	}
 	return aggregatedError(errs)
 }
 
+// GetManifest gets the manifest name (a tag or a digest). If there are
+// several endpoints, the endpoint that returns the manifest becomes active.
 func (c *Client) GetManifest(name string, opts GetManifestOptions) (*http.Response, error) {
-	req, err := http.NewRequest("GET", c.URL("/v2/%s/manifests/%s", c.Scope(), name), nil)
+	mediaTypes := opts.mediaTypes()
+
+	blobCache := c.blobCache()
+	var tagEntry *cache.TagEntry
+	if blobCache != nil {
+		if dgst, err := digest.Parse(name); err == nil {
+			if mediaType := blobCache.MediaType(dgst); mediaType != "" && accepts(mediaTypes, mediaType) {
+				path := fmt.Sprintf("/v2/%s/manifests/%s", c.Scope(), name)
+				if resp := c.cachedResponse(blobCache, path, dgst, mediaType); resp != nil {
+					return resp, nil
+				}
+			}
+		} else if entry, err := blobCache.Tag(reference.Domain(c.named), reference.Path(c.named), name); err == nil && entry != nil && accepts(mediaTypes, entry.MediaType) {
+			tagEntry = entry
+		}
+	}
+
+	return c.tryEndpoints(true, func(e endpoint) (*http.Response, error) {
+		return c.getManifest(e, name, mediaTypes, tagEntry, blobCache)
+	})
+}
+
+// getManifest gets the manifest name from the endpoint e. If the manifest for
+// the tag is cached, the tag is revalidated.
+func (c *Client) getManifest(e endpoint, name string, mediaTypes []string, tagEntry *cache.TagEntry, blobCache *cache.Cache) (*http.Response, error) {
+	path := fmt.Sprintf("/v2/%s/manifests/%s", reference.Path(e.Named), name)
+	newRequest := func(method string) (*http.Request, error) {
+		req, err := http.NewRequest(method, e.url("%s", path), nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, mediaType := range mediaTypes {
+			req.Header.Add("Accept", mediaType)
+		}
+		return req, nil
+	}
+
+	if tagEntry != nil && tagEntry.ETag == "" {
+		// Without an ETag, check whether the tag still points to the cached
+		// manifest.
+		req, err := newRequest("HEAD")
+		if err != nil {
+			return nil, err
+		}
+		resp, err := e.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && resp.Header.Get("Docker-Content-Digest") == tagEntry.Digest.String() {
+			if cached := c.cachedResponse(blobCache, path, tagEntry.Digest, tagEntry.MediaType); cached != nil {
+				return cached, nil
+			}
+		}
+		tagEntry = nil
+	}
+
+	req, err := newRequest("GET")
 	if err != nil {
 		return nil, err
 	}
-	if opts.AcceptKnown || opts.AcceptSchema1 {
-		req.Header.Add("Accept", "application/vnd.docker.distribution.manifest.v1+json")
+	if tagEntry != nil {
+		req.Header.Set("If-None-Match", tagEntry.ETag)
 	}
-	if opts.AcceptKnown || opts.AcceptSchema1Signed {
-		req.Header.Add("Accept", "application/vnd.docker.distribution.manifest.v1+prettyjws")
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, err
 	}
-	if opts.AcceptKnown || opts.AcceptSchema2 {
-		req.Header.Add("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+	if resp.StatusCode == http.StatusNotModified && tagEntry != nil {
+		resp.Body.Close()
+		if cached := c.cachedResponse(blobCache, path, tagEntry.Digest, tagEntry.MediaType); cached != nil {
+			return cached, nil
+		}
+		// The manifest has been pruned from the cache.
+		req.Header.Del("If-None-Match")
+		resp, err = e.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
 	}
-	if opts.AcceptKnown || opts.AcceptManifestList {
-		req.Header.Add("Accept", "application/vnd.docker.distribution.manifest.list.v2+json")
+	if blobCache != nil && resp.StatusCode == http.StatusOK {
+		c.cacheManifest(blobCache, resp, name)
 	}
-	if opts.AcceptKnown || opts.AcceptOCISchema {
-		req.Header.Add("Accept", "application/vnd.oci.image.manifest.v1+json")
+	return resp, nil
+}
+
+// HeadManifest checks whether the manifest name (a tag or a digest) exists.
+// The response has the Docker-Content-Digest header if the registry knows the
+// digest.
+func (c *Client) HeadManifest(name string, opts GetManifestOptions) (*http.Response, error) {
+	mediaTypes := opts.mediaTypes()
+	return c.tryEndpoints(false, func(e endpoint) (*http.Response, error) {
+		req, err := http.NewRequest("HEAD", e.url("/v2/%s/manifests/%s", reference.Path(e.Named), name), nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, mediaType := range mediaTypes {
+			req.Header.Add("Accept", mediaType)
+		}
+		return e.httpClient.Do(req)
+	})
+}
+
+func (c *Client) DeleteManifest(name string) (*http.Response, error) {
+	u, err := c.URL("/v2/%s/manifests/%s", c.Scope(), name)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("DELETE", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+func (c *Client) GetBlob(name string) (*http.Response, error) {
+	path := fmt.Sprintf("/v2/%s/blobs/%s", c.Scope(), name)
+
+	blobCache := c.blobCache()
+	dgst, dgstErr := digest.Parse(name)
+	if blobCache != nil && dgstErr == nil {
+		if resp := c.cachedResponse(blobCache, path, dgst, ""); resp != nil {
+			return resp, nil
+		}
 	}
-	if opts.AcceptKnown || opts.AcceptOCIIndex {
-		req.Header.Add("Accept", "application/vnd.oci.image.index.v1+json")
+
+	resp, err := c.tryEndpoints(false, func(e endpoint) (*http.Response, error) {
+		req, err := http.NewRequest("GET", e.url("/v2/%s/blobs/%s", reference.Path(e.Named), name), nil)
+		if err != nil {
+			return nil, err
+		}
+		return e.httpClient.Do(req)
+	})
+	if err != nil {
+		return nil, err
 	}
-	for _, mediatype := range opts.MediaTypes {
-		req.Header.Add("Accept", mediatype)
+	if blobCache != nil && dgstErr == nil && resp.StatusCode == http.StatusOK {
+		if w, err := blobCache.NewWriter(dgst, ""); err == nil {
+			resp.Body = &cachingReader{
+				rc: resp.Body,
+				w:  w,
+			}
+		}
+	}
+	if fn := c.progressFunc(); fn != nil && resp.StatusCode == http.StatusOK {
+		resp.Body = progress.NewReader(resp.Body, fn, progress.Event{
+			ID:     name,
+			Action: progress.Download,
+			Total:  resp.ContentLength,
+		})
+	}
+	return resp, nil
+}
+
+func (c *Client) HeadBlob(name string) (*http.Response, error) {
+	u, err := c.URL("/v2/%s/blobs/%s", c.Scope(), name)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("HEAD", u, nil)
+	if err != nil {
+		return nil, err
 	}
 	return c.Do(req)
 }
 
-func (c *Client) DeleteManifest(name string) (*http.Response, error) {
-	req, err := http.NewRequest("DELETE", c.URL("/v2/%s/manifests/%s", c.Scope(), name), nil)
+// PutBlob uploads the blob using a monolithic upload. If size is negative,
+// the blob is sent using chunked transfer encoding.
+//
+// If the registry does not accept the upload, the response for the failed
+// request is returned.
+func (c *Client) PutBlob(digest string, body io.Reader, size int64) (*http.Response, error) {
+	u, err := c.URL("/v2/%s/blobs/uploads/", c.Scope())
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Starting another upload is harmless, so the request can be repeated.
+	req.GetBody = func() (io.ReadCloser, error) {
+		return http.NoBody, nil
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return nil, fmt.Errorf("%s %s: no Location header", req.Method, req.URL)
+	}
+
+	uri, err := c.ResolveReference(req.URL, loc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse Location: %s", err)
+	}
+
+	if uri.RawQuery != "" {
+		uri.RawQuery += "&"
+	}
+	uri.RawQuery += "digest=" + url.QueryEscape(digest)
+
+	req, err = http.NewRequest("PUT", uri.String(), body)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Add("Content-Type", "application/octet-stream")
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	if seeker, ok := body.(io.ReadSeeker); ok && req.GetBody == nil {
+		// Let the request be sent again if the body is a file. The transport
+		// closes request bodies, so the file is not passed to it directly.
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			req.Body = ioutil.NopCloser(seeker)
+			req.GetBody = func() (io.ReadCloser, error) {
+				if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+					return nil, err
+				}
+				return ioutil.NopCloser(seeker), nil
+			}
+		}
+	}
+	if fn := c.progressFunc(); fn != nil && size != 0 {
+		event := progress.Event{
+			ID:     digest,
+			Action: progress.Upload,
+			Total:  size,
+		}
+		req.Body = progress.NewReader(req.Body, fn, event)
+		if getBody := req.GetBody; getBody != nil {
+			req.GetBody = func() (io.ReadCloser, error) {
+				body, err := getBody()
+				if err != nil {
+					return nil, err
+				}
+				return progress.NewReader(body, fn, event), nil
+			}
+		}
+	}
 	return c.Do(req)
 }
 
-func (c *Client) GetBlob(name string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", c.URL("/v2/%s/blobs/%s", c.Scope(), name), nil)
+func (c *Client) PutManifest(name string, mediaType string, body io.Reader) (*http.Response, error) {
+	u, err := c.URL("/v2/%s/manifests/%s", c.Scope(), name)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("PUT", u, body)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Add("Content-Type", mediaType)
 	return c.Do(req)
 }
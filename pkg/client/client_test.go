@@ -0,0 +1,234 @@
+package client
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/docker/distribution/reference"
+)
+
+const (
+	testToken    = "secret-token"
+	testManifest = `{"schemaVersion":2}`
+	testBlob     = "blob contents"
+)
+
+// newTestRegistry starts a registry that requires bearer tokens and counts
+// token requests.
+func newTestRegistry(t *testing.T) (*httptest.Server, *int32) {
+	var tokenRequests int32
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			atomic.AddInt32(&tokenRequests, 1)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"token":      testToken,
+				"expires_in": 300,
+			})
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case strings.HasPrefix(r.URL.Path, "/v2/repo/manifests/"):
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+			fmt.Fprint(w, testManifest)
+		case strings.HasPrefix(r.URL.Path, "/v2/repo/blobs/"):
+			fmt.Fprint(w, testBlob)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &tokenRequests
+}
+
+func newTestClient(t *testing.T, srv *httptest.Server) *Client {
+	host := strings.TrimPrefix(srv.URL, "https://")
+	c, err := New(host+"/repo", false, srv.Client().Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func readBody(resp *http.Response) (string, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL, resp.Status)
+	}
+	buf, err := ioutil.ReadAll(resp.Body)
+	return string(buf), err
+}
+
+func TestParallelFetches(t *testing.T) {
+	srv, tokenRequests := newTestRegistry(t)
+	c := newTestClient(t, srv)
+	if err := c.Auth(nil, c.Scope(), "pull"); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 32
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := c.GetManifest(fmt.Sprintf("tag%d", i), GetManifestOptions{AcceptSchema2: true})
+			if err != nil {
+				errs <- err
+				return
+			}
+			body, err := readBody(resp)
+			if err != nil {
+				errs <- err
+			} else if body != testManifest {
+				errs <- fmt.Errorf("got manifest %q, want %q", body, testManifest)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := c.GetBlob(fmt.Sprintf("sha256:%064d", i))
+			if err != nil {
+				errs <- err
+				return
+			}
+			body, err := readBody(resp)
+			if err != nil {
+				errs <- err
+			} else if body != testBlob {
+				errs <- fmt.Errorf("got blob %q, want %q", body, testBlob)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got := atomic.LoadInt32(tokenRequests); got != 1 {
+		t.Errorf("got %d token requests, want 1", got)
+	}
+}
+
+func TestAuthDuringRequests(t *testing.T) {
+	srv, _ := newTestRegistry(t)
+	c := newTestClient(t, srv)
+	if err := c.Auth(nil, c.Scope(), "pull"); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i := 0; i < 16; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := c.Auth(nil, c.Scope(), "pull"); err != nil {
+				errs <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			resp, err := c.GetBlob("sha256:" + strings.Repeat("0", 64))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if _, err := readBody(resp); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestParallelFallback(t *testing.T) {
+	primary := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(primary.Close)
+	mirror, tokenRequests := newTestRegistry(t)
+	var pings int32
+	mirror.Config.Handler = func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v2/" {
+				atomic.AddInt32(&pings, 1)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}(mirror.Config.Handler)
+
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	c := newTestClient(t, primary)
+	c.transport = transport
+	named := func(srv *httptest.Server) reference.Named {
+		named, err := reference.ParseNormalizedNamed(strings.TrimPrefix(srv.URL, "https://") + "/repo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return named
+	}
+	c.SetEndpoints([]Endpoint{{Named: named(primary)}, {Named: named(mirror)}})
+	if err := c.Auth(nil, c.Scope(), "pull"); err != nil {
+		t.Fatal(err)
+	}
+	pingsAfterAuth := atomic.LoadInt32(&pings)
+
+	const n = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.GetManifest("latest", GetManifestOptions{AcceptSchema2: true})
+			if err != nil {
+				errs <- err
+				return
+			}
+			if _, err := readBody(resp); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got := atomic.LoadInt32(&pings) - pingsAfterAuth; got != 1 {
+		t.Errorf("the mirror is authorized %d times, want 1", got)
+	}
+	if got := atomic.LoadInt32(tokenRequests); got != 1 {
+		t.Errorf("got %d token requests, want 1", got)
+	}
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/client/auth"
+)
+
+// Endpoint is a location of the repository, for example, a mirror.
+type Endpoint struct {
+	Named       reference.Named      // the repository at the location
+	Insecure    bool                 // allow plain HTTP
+	Credentials auth.CredentialStore // if nil, the credentials passed to Auth are used
+	Base        *url.URL             // the base URL of the API (see Resolver), if it is not derived from the domain
+}
+
+// endpoint is an Endpoint with the state of its connection.
+type endpoint struct {
+	Endpoint
+	connection connectionType
+	httpClient *http.Client
+	authorized bool
+
+	// authorizing is shared by the copies of the endpoint, so that
+	// concurrent requests that fall back to it authorize it once.
+	authorizing *sync.Mutex
+}
+
+func (e *endpoint) url(format string, a ...interface{}) string {
+	return baseURL(e.Base, e.connection.Scheme(), reference.Domain(e.Named), fmt.Sprintf(format, a...))
+}
+
+// host returns the host in the URLs of the endpoint.
+func (e *endpoint) host() string {
+	if e.Base != nil && e.Base.Scheme != "unix" {
+		return e.Base.Host
+	}
+	return RegistryHost(reference.Domain(e.Named))
+}
+
+// connectionTypes returns the connection types that the endpoint may use in
+// the order of preference.
+func (e *endpoint) connectionTypes() []connectionType {
+	switch {
+	case e.Base == nil && e.Insecure:
+		return []connectionType{httpsConnection, httpConnection}
+	case e.Base == nil || e.Base.Scheme == "https":
+		return []connectionType{httpsConnection}
+	}
+	return []connectionType{httpConnection}
+}
+
+// SetEndpoints sets the locations of the repository. GetManifest tries them
+// in order until one of them has the manifest, and this endpoint is used for
+// the following requests. It should be called before Auth.
+func (c *Client) SetEndpoints(endpoints []Endpoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.endpoints = make([]endpoint, len(endpoints))
+	for i, e := range endpoints {
+		c.endpoints[i] = endpoint{
+			Endpoint: e,
+			httpClient: &http.Client{
+				Transport: c.transport,
+			},
+			authorizing: &sync.Mutex{},
+		}
+		c.endpoints[i].connection = c.endpoints[i].connectionTypes()[0]
+	}
+	c.active = 0
+}
+
+func (c *Client) activeEndpoint() endpoint {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.endpoints[c.active]
+}
+
+func (c *Client) setActive(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active = i
+}
+
+// endpointFor returns the endpoint for requests to host, or the active
+// endpoint if host does not belong to any endpoint.
+func (c *Client) endpointFor(host string) endpoint {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.endpoints[c.active].host() == host {
+		return c.endpoints[c.active]
+	}
+	for _, e := range c.endpoints {
+		if e.authorized && e.host() == host {
+			return e
+		}
+	}
+	return c.endpoints[c.active]
+}
+
+// isRepositoryScope returns true if scope is the path of the repository on
+// any of the endpoints.
+func (c *Client) isRepositoryScope(scope string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if scope == reference.Path(c.named) {
+		return true
+	}
+	for _, e := range c.endpoints {
+		if scope == reference.Path(e.Named) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizedEndpoint returns the endpoint i. If Auth has been called and the
+// endpoint is not authorized yet, it is authorized with the same
+// credentials. Concurrent callers wait for a single authorization.
+func (c *Client) authorizedEndpoint(i int) (endpoint, error) {
+	c.mu.RLock()
+	e := c.endpoints[i]
+	authRequested := c.authScope != "" || c.authActions != nil
+	c.mu.RUnlock()
+
+	if e.authorized || !authRequested {
+		return e, nil
+	}
+
+	e.authorizing.Lock()
+	defer e.authorizing.Unlock()
+
+	c.mu.RLock()
+	e = c.endpoints[i]
+	creds, scope, actions := c.authCreds, c.authScope, c.authActions
+	c.mu.RUnlock()
+	if e.authorized {
+		return e, nil
+	}
+
+	e, err := c.authorize(e, creds, scope, actions...)
+	if err != nil {
+		return e, err
+	}
+
+	c.mu.Lock()
+	c.endpoints[i] = e
+	c.mu.Unlock()
+	return e, nil
+}
+
+// tryEndpoints calls do for the endpoints starting from the active one until
+// it succeeds, and returns the last response. If activate is true, the
+// endpoint that succeeded becomes active.
+func (c *Client) tryEndpoints(activate bool, do func(e endpoint) (*http.Response, error)) (*http.Response, error) {
+	if err := c.pendingAuth(); err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	start, n := c.active, len(c.endpoints)
+	c.mu.RUnlock()
+	for i := start; ; i++ {
+		e, err := c.authorizedEndpoint(i)
+		var resp *http.Response
+		if err == nil {
+			resp, err = do(e)
+		}
+		succeeded := err == nil && resp.StatusCode == http.StatusOK
+		if succeeded && activate {
+			c.setActive(i)
+		}
+		if succeeded || i == n-1 {
+			return resp, err
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+}
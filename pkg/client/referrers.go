@@ -0,0 +1,148 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/tomnomnom/linkheader"
+
+	"github.com/dmage/boater/pkg/manifests"
+)
+
+// ReferrersTag returns the tag that is used to store referrers of the
+// manifest dgst in registries without the referrers API.
+func ReferrersTag(dgst digest.Digest) string {
+	return fmt.Sprintf("%s-%s", dgst.Algorithm(), dgst.Encoded())
+}
+
+func filterArtifactType(descriptors []manifests.ManifestDescriptor, artifactType string) []manifests.ManifestDescriptor {
+	if artifactType == "" {
+		return descriptors
+	}
+	var filtered []manifests.ManifestDescriptor
+	for _, md := range descriptors {
+		if md.ArtifactType == artifactType {
+			filtered = append(filtered, md)
+		}
+	}
+	return filtered
+}
+
+// getReferrersPage returns the referrers from the page u and the URL of the
+// next page. If the registry does not support the referrers API, the returned
+// response has the status code 404.
+func (c *Client) getReferrersPage(u string, artifactType string) ([]manifests.ManifestDescriptor, string, *http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	req.Header.Add("Accept", manifests.MediaTypeOCIIndex)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", resp, nil
+	}
+
+	var index manifests.ManifestList
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return nil, "", resp, fmt.Errorf("decode referrers: %s", err)
+	}
+
+	descriptors := index.Manifests
+	if resp.Header.Get("OCI-Filters-Applied") != "artifactType" {
+		descriptors = filterArtifactType(descriptors, artifactType)
+	}
+
+	next := ""
+	for _, link := range linkheader.ParseMultiple(resp.Header.Values("Link")) {
+		if link.Rel == "next" {
+			nextURL, err := c.ResolveReference(req.URL, link.URL)
+			if err != nil {
+				return nil, "", resp, fmt.Errorf("parse Link: %s", err)
+			}
+			next = nextURL.String()
+			break
+		}
+	}
+
+	return descriptors, next, resp, nil
+}
+
+// Referrers returns the descriptors of manifests that have the manifest dgst
+// as their subject. If artifactType is not empty, only descriptors with this
+// artifact type are returned.
+//
+// If the registry does not support the referrers API, the referrers are read
+// from the index that is tagged using the referrers tag schema.
+func (c *Client) Referrers(dgst digest.Digest, artifactType string) ([]manifests.ManifestDescriptor, error) {
+	u, err := c.URL("/v2/%s/referrers/%s", c.Scope(), dgst)
+	if err != nil {
+		return nil, err
+	}
+	if artifactType != "" {
+		u += "?" + url.Values{"artifactType": []string{artifactType}}.Encode()
+	}
+
+	var referrers []manifests.ManifestDescriptor
+	for u != "" {
+		descriptors, next, resp, err := c.getReferrersPage(u, artifactType)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusNotFound && referrers == nil {
+			return c.referrersFromTag(dgst, artifactType)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL, resp.Status)
+		}
+		referrers = append(referrers, descriptors...)
+		u = next
+	}
+	if referrers == nil {
+		referrers = []manifests.ManifestDescriptor{}
+	}
+	return referrers, nil
+}
+
+// ReferrersIndex returns the index that is tagged using the referrers tag
+// schema for the manifest dgst. If the tag does not exist, nil is returned.
+func (c *Client) ReferrersIndex(dgst digest.Digest) (*manifests.ManifestList, error) {
+	resp, err := c.GetManifest(ReferrersTag(dgst), GetManifestOptions{
+		AcceptOCIIndex: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL, resp.Status)
+	}
+
+	var index manifests.ManifestList
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return nil, fmt.Errorf("decode referrers index: %s", err)
+	}
+	return &index, nil
+}
+
+func (c *Client) referrersFromTag(dgst digest.Digest, artifactType string) ([]manifests.ManifestDescriptor, error) {
+	index, err := c.ReferrersIndex(dgst)
+	if err != nil {
+		return nil, err
+	}
+	if index == nil {
+		return []manifests.ManifestDescriptor{}, nil
+	}
+	return filterArtifactType(index.Manifests, artifactType), nil
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Resolver maps registry domains to the base URLs of their APIs. A base URL
+// is either an http or https URL that may include a path prefix, for
+// example, https://example.com/artifactory/api/docker/registry, or
+// unix:///path/to/socket for a registry that listens on a Unix socket.
+//
+// Registries without a base URL are accessed at https://domain/v2/ or, if
+// plain HTTP is allowed, at http://domain/v2/.
+type Resolver struct {
+	bases map[string]*url.URL
+}
+
+// ParseBaseURL parses the base URL of a registry API.
+func ParseBaseURL(base string) (*url.URL, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("base URL %s should not have a query or a fragment", base)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("base URL %s has no host", base)
+		}
+		u.Path = strings.TrimRight(u.Path, "/")
+		u.RawPath = ""
+	case "unix":
+		if u.Host != "" || u.Path == "" {
+			return nil, fmt.Errorf("invalid socket URL %s, expected unix:///path/to/socket", base)
+		}
+	default:
+		return nil, fmt.Errorf("base URL %s should use http, https or unix", base)
+	}
+	return u, nil
+}
+
+// Add sets the base URL for the registry domain.
+func (r *Resolver) Add(domain string, base string) error {
+	u, err := ParseBaseURL(base)
+	if err != nil {
+		return err
+	}
+	if r.bases == nil {
+		r.bases = map[string]*url.URL{}
+	}
+	r.bases[domain] = u
+	return nil
+}
+
+// Resolve returns the base URL for the registry domain, or nil if the domain
+// does not have one. A nil Resolver has no base URLs.
+func (r *Resolver) Resolve(domain string) *url.URL {
+	if r == nil {
+		return nil
+	}
+	return r.bases[domain]
+}
+
+// Socket returns the path to the Unix socket that should be used for
+// requests to host, or an empty string if the host is not served by a Unix
+// socket.
+func (r *Resolver) Socket(host string) string {
+	if r == nil {
+		return ""
+	}
+	for domain, base := range r.bases {
+		if base.Scheme == "unix" && RegistryHost(domain) == host {
+			return base.Path
+		}
+	}
+	return ""
+}
+
+// Domains returns the registry domains whose base URLs are on host in
+// sorted order.
+func (r *Resolver) Domains(host string) []string {
+	if r == nil {
+		return nil
+	}
+	var domains []string
+	for domain, base := range r.bases {
+		if base.Scheme != "unix" && base.Host == host {
+			domains = append(domains, domain)
+		}
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// URL returns the URL for the path that is built from format and a on the
+// registry domain. The scheme is used if the domain does not have a base URL.
+func (r *Resolver) URL(scheme string, domain string, format string, a ...interface{}) string {
+	return baseURL(r.Resolve(domain), scheme, domain, fmt.Sprintf(format, a...))
+}
+
+// baseURL returns the URL for the path on the registry domain that has the
+// base URL base. Requests to Unix sockets use the registry host in their URLs,
+// so that transports can find the socket for them.
+func baseURL(base *url.URL, scheme string, domain string, path string) string {
+	u := &url.URL{
+		Scheme: scheme,
+		Host:   RegistryHost(domain),
+		Path:   path,
+	}
+	if base != nil {
+		switch base.Scheme {
+		case "unix":
+			u.Scheme = "http"
+		default:
+			u.Scheme = base.Scheme
+			u.Host = base.Host
+			u.Path = base.Path + path
+		}
+	}
+	return u.String()
+}
+
+// resolveReference resolves the reference ref from a Link or a Location
+// header of a response to a request to u. Registries behind reverse proxies
+// often do not know about the path prefix, so it is added to absolute paths
+// that do not have it.
+func resolveReference(base *url.URL, u *url.URL, ref string) (*url.URL, error) {
+	r, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	resolved := u.ResolveReference(r)
+	if base == nil || base.Scheme == "unix" || base.Path == "" {
+		return resolved, nil
+	}
+	if r.Scheme == "" && r.Host == "" && strings.HasPrefix(r.Path, "/v2/") {
+		resolved.Path = base.Path + r.Path
+		resolved.RawPath = ""
+	}
+	return resolved, nil
+}
@@ -0,0 +1,71 @@
+// Package config reads the boater configuration file, which holds settings
+// for individual registries.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Registry is the configuration for a registry host.
+type Registry struct {
+	// Endpoint is the base URL of the registry API, which may include a path
+	// prefix, or unix:///path/to/socket.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// CAFile is an additional CA certificate for the registry.
+	CAFile string `json:"caFile,omitempty"`
+
+	// CertFile and KeyFile are the client certificate and its key.
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+
+	// SkipTLSVerify disables the verification of the server certificate.
+	SkipTLSVerify bool `json:"skipTLSVerify,omitempty"`
+
+	// AllowHTTP allows to fall back to plain HTTP.
+	AllowHTTP bool `json:"allowHTTP,omitempty"`
+}
+
+// Config is the contents of the configuration file.
+type Config struct {
+	// Registries maps registry hosts (host[:port]) to their configuration.
+	Registries map[string]Registry `json:"registries,omitempty"`
+}
+
+// DefaultPath returns the location of the configuration file.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "boater", "config.json"), nil
+}
+
+// Load reads the configuration from the file path.
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var config Config
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// Registry returns the configuration for the registry host. A nil Config
+// has no settings for any host.
+func (c *Config) Registry(host string) Registry {
+	if c == nil {
+		return Registry{}
+	}
+	return c.Registries[host]
+}
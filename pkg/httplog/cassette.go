@@ -0,0 +1,526 @@
+package httplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dmage/boater/pkg/redact"
+)
+
+// Interaction is an HTTP exchange in a cassette. It is stored as
+// <id>.json, and the bodies are stored as <id>.request and <id>.response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+
+	// Redacted is true if credentials in the interaction are masked.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// RecordedRequest is a request in a cassette.
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"` // the name of the file with the body
+}
+
+// RecordedResponse is a response in a cassette.
+type RecordedResponse struct {
+	Status     string      `json:"status"`
+	StatusCode int         `json:"statusCode"`
+	Proto      string      `json:"proto"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"` // the name of the file with the body
+
+	// Truncated is true if the client did not read the whole body.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// DefaultMatchRules are the rules that are used if a cassette does not have
+// a Matcher.
+var DefaultMatchRules = []string{"method", "host", "path", "query"}
+
+// Matcher decides whether a recorded request matches a request. The rules
+// are the parts of requests that should be equal: method, scheme, host, path,
+// query, body and header:<name>.
+type Matcher struct {
+	rules []string
+}
+
+// NewMatcher returns a matcher for the rules.
+func NewMatcher(rules []string) (*Matcher, error) {
+	for _, rule := range rules {
+		switch {
+		case rule == "method", rule == "scheme", rule == "host", rule == "path", rule == "query", rule == "body":
+		case strings.HasPrefix(rule, "header:") && len(rule) > len("header:"):
+		default:
+			return nil, fmt.Errorf("invalid match rule %q (expected method, scheme, host, path, query, body or header:<name>)", rule)
+		}
+	}
+	return &Matcher{rules: rules}, nil
+}
+
+func (m *Matcher) needsBody() bool {
+	for _, rule := range m.rules {
+		if rule == "body" {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns true if the recorded request matches the request with the
+// URL u, the header and the body.
+func (m *Matcher) match(method string, u *url.URL, header http.Header, body []byte, recorded *http.Request, recordedBody []byte) bool {
+	for _, rule := range m.rules {
+		switch rule {
+		case "method":
+			if method != recorded.Method {
+				return false
+			}
+		case "scheme":
+			if u.Scheme != recorded.URL.Scheme {
+				return false
+			}
+		case "host":
+			if u.Host != recorded.URL.Host {
+				return false
+			}
+		case "path":
+			if u.EscapedPath() != recorded.URL.EscapedPath() {
+				return false
+			}
+		case "query":
+			if u.Query().Encode() != recorded.URL.Query().Encode() {
+				return false
+			}
+		case "body":
+			if !bytes.Equal(body, recordedBody) {
+				return false
+			}
+		default:
+			name := strings.TrimPrefix(rule, "header:")
+			if strings.Join(header.Values(name), ", ") != strings.Join(recorded.Header.Values(name), ", ") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Cassette is a directory with recorded HTTP exchanges. RecordRoundTripper
+// adds exchanges to it, and ReplayRoundTripper serves the responses from it
+// without the network. Credentials are masked in recorded exchanges unless
+// Unsafe is set.
+//
+// A Cassette can be shared by several round trippers.
+type Cassette struct {
+	Dir    string
+	Unsafe bool     // record credentials as is
+	Match  *Matcher // the rules for replays, DefaultMatchRules if nil
+
+	mu           sync.Mutex
+	next         int
+	loaded       bool
+	ids          []string
+	interactions []*Interaction
+	used         []bool
+}
+
+// nextID reserves the id for a new interaction.
+func (c *Cassette) nextID() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.next == 0 {
+		if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+			return "", err
+		}
+		ids, err := c.list()
+		if err != nil {
+			return "", err
+		}
+		c.next = 1
+		if len(ids) > 0 {
+			n, _ := strconv.Atoi(ids[len(ids)-1])
+			c.next = n + 1
+		}
+	}
+	id := fmt.Sprintf("%04d", c.next)
+	c.next++
+	return id, nil
+}
+
+// list returns the ids of the interactions in the cassette in order.
+func (c *Cassette) list() ([]string, error) {
+	files, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, f := range files {
+		id := strings.TrimSuffix(f.Name(), ".json")
+		if _, err := strconv.Atoi(id); err == nil && id != f.Name() {
+			ids = append(ids, id)
+		}
+	}
+	// The ids are sorted numerically, as they are wider than four digits
+	// after 9999.
+	sort.Slice(ids, func(i, j int) bool {
+		a, _ := strconv.Atoi(ids[i])
+		b, _ := strconv.Atoi(ids[j])
+		return a < b
+	})
+	return ids, nil
+}
+
+func (c *Cassette) path(name string) string {
+	return filepath.Join(c.Dir, name)
+}
+
+func (c *Cassette) save(id string, interaction *Interaction) error {
+	buf, err := json.MarshalIndent(interaction, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path(id+".json"), append(buf, '\n'), 0o644)
+}
+
+// isRedactable returns true if bodies of the content type may have
+// credentials that redact.Body masks.
+func isRedactable(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return strings.HasSuffix(mediaType, "json") || mediaType == "application/x-www-form-urlencoded"
+}
+
+// redactFile masks credentials in the body file.
+func (c *Cassette) redactFile(name string, contentType string) error {
+	if c.Unsafe || name == "" || !isRedactable(contentType) {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(c.path(name))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path(name), redact.Body(buf), 0o644)
+}
+
+func (c *Cassette) header(header http.Header) http.Header {
+	if c.Unsafe {
+		return header.Clone()
+	}
+	redacted := http.Header{}
+	for k, values := range header {
+		for _, v := range values {
+			redacted.Add(k, redact.Header(k, v))
+		}
+	}
+	return redacted
+}
+
+// teeReadCloser writes the data that is read from rc to the file w. done is
+// called once when rc is read to the end or closed.
+type teeReadCloser struct {
+	rc   io.ReadCloser
+	w    *os.File
+	eof  bool
+	once sync.Once
+	done func(eof bool)
+}
+
+func (t *teeReadCloser) Read(p []byte) (int, error) {
+	n, err := t.rc.Read(p)
+	if n > 0 {
+		if _, werr := t.w.Write(p[:n]); werr != nil {
+			return n, werr
+		}
+	}
+	if err == io.EOF {
+		t.eof = true
+		t.finish()
+	}
+	return n, err
+}
+
+func (t *teeReadCloser) Close() error {
+	err := t.rc.Close()
+	t.finish()
+	return err
+}
+
+func (t *teeReadCloser) finish() {
+	t.once.Do(func() {
+		t.w.Close()
+		if t.done != nil {
+			t.done(t.eof)
+		}
+	})
+}
+
+// RecordRoundTripper sends requests using RoundTripper and adds the
+// exchanges to Cassette. An exchange is saved when the transport has closed
+// the request body and the response body is read to the end or closed.
+type RecordRoundTripper struct {
+	http.RoundTripper
+	Cassette *Cassette
+}
+
+func (rt *RecordRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := rt.RoundTripper
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	c := rt.Cassette
+
+	id, err := c.nextID()
+	if err != nil {
+		return nil, fmt.Errorf("record %s %s: %w", req.Method, redact.URL(req.URL), err)
+	}
+
+	u := req.URL.String()
+	if !c.Unsafe {
+		u = redact.URL(req.URL)
+	}
+	interaction := &Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    u,
+			Header: c.header(req.Header),
+		},
+		Redacted: !c.Unsafe,
+	}
+
+	save := func() {
+		if err := c.save(id, interaction); err != nil {
+			log.Printf("unable to record %s %s: %s", req.Method, u, err)
+		}
+	}
+
+	b := &barrier{n: 1}
+	var roundTripErr error
+	b.fn = func() {
+		if roundTripErr != nil {
+			if interaction.Request.Body != "" {
+				os.Remove(c.path(interaction.Request.Body))
+			}
+			return
+		}
+		if err := c.redactFile(interaction.Request.Body, req.Header.Get("Content-Type")); err != nil {
+			log.Printf("unable to record %s %s: %s", req.Method, u, err)
+		}
+		if err := c.redactFile(interaction.Response.Body, interaction.Response.Header.Get("Content-Type")); err != nil {
+			log.Printf("unable to record %s %s: %s", req.Method, u, err)
+		}
+		save()
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		f, err := os.Create(c.path(id + ".request"))
+		if err != nil {
+			return nil, fmt.Errorf("record %s %s: %w", req.Method, u, err)
+		}
+		interaction.Request.Body = id + ".request"
+		b.n++
+		req.Body = &teeReadCloser{
+			rc:   req.Body,
+			w:    f,
+			done: func(bool) { b.done() },
+		}
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		roundTripErr = err
+		b.done()
+		return nil, err
+	}
+
+	interaction.Response = RecordedResponse{
+		Status:     resp.Status,
+		StatusCode: resp.StatusCode,
+		Proto:      resp.Proto,
+		Header:     c.header(resp.Header),
+	}
+	if resp.Body == nil || resp.Body == http.NoBody {
+		b.done()
+		return resp, nil
+	}
+
+	f, err := os.Create(c.path(id + ".response"))
+	if err != nil {
+		resp.Body.Close()
+		roundTripErr = err
+		b.done()
+		return nil, fmt.Errorf("record %s %s: %w", req.Method, u, err)
+	}
+	interaction.Response.Body = id + ".response"
+	resp.Body = &teeReadCloser{
+		rc: resp.Body,
+		w:  f,
+		done: func(eof bool) {
+			interaction.Response.Truncated = !eof
+			b.done()
+		},
+	}
+	return resp, nil
+}
+
+// load reads the interactions from the cassette.
+func (c *Cassette) load() error {
+	if c.loaded {
+		return nil
+	}
+	ids, err := c.list()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		buf, err := ioutil.ReadFile(c.path(id + ".json"))
+		if err != nil {
+			return err
+		}
+		var interaction Interaction
+		if err := json.Unmarshal(buf, &interaction); err != nil {
+			return fmt.Errorf("%s: %w", c.path(id+".json"), err)
+		}
+		c.ids = append(c.ids, id)
+		c.interactions = append(c.interactions, &interaction)
+	}
+	c.used = make([]bool, len(c.interactions))
+	c.loaded = true
+	return nil
+}
+
+// find returns the index of the interaction for the request. Interactions
+// are replayed in the recorded order; if all matching interactions have been
+// used, the last of them is replayed again.
+func (c *Cassette) find(req *http.Request, body []byte) (int, error) {
+	match := c.Match
+	if match == nil {
+		match = &Matcher{rules: DefaultMatchRules}
+	}
+
+	found := -1
+	for i, interaction := range c.interactions {
+		recordedURL, err := url.Parse(interaction.Request.URL)
+		if err != nil {
+			return -1, fmt.Errorf("%s: invalid URL: %w", c.path(c.ids[i]+".json"), err)
+		}
+		recorded := &http.Request{
+			Method: interaction.Request.Method,
+			URL:    recordedURL,
+			Header: interaction.Request.Header,
+		}
+
+		u, header := req.URL, req.Header
+		if interaction.Redacted {
+			// Compare the request as it would be recorded.
+			u, _ = url.Parse(redact.URL(req.URL))
+			header = (&Cassette{}).header(req.Header)
+		}
+
+		var recordedBody []byte
+		if match.needsBody() && interaction.Request.Body != "" {
+			recordedBody, err = ioutil.ReadFile(c.path(interaction.Request.Body))
+			if err != nil {
+				return -1, err
+			}
+		}
+
+		if !match.match(req.Method, u, header, body, recorded, recordedBody) {
+			continue
+		}
+		if !c.used[i] {
+			return i, nil
+		}
+		found = i
+	}
+	return found, nil
+}
+
+// ReplayRoundTripper serves responses from Cassette without sending
+// requests.
+type ReplayRoundTripper struct {
+	Cassette *Cassette
+}
+
+func (rt *ReplayRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := rt.Cassette
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	err := c.load()
+	i := -1
+	if err == nil {
+		i, err = c.find(req, body)
+	}
+	if i >= 0 {
+		c.used[i] = true
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("replay %s %s: %w", req.Method, redact.URL(req.URL), err)
+	}
+	if i < 0 {
+		return nil, fmt.Errorf("replay %s %s: no matching request in %s", req.Method, redact.URL(req.URL), c.Dir)
+	}
+
+	recorded := c.interactions[i].Response
+	major, minor, ok := http.ParseHTTPVersion(recorded.Proto)
+	if !ok {
+		major, minor = 1, 1
+	}
+	resp := &http.Response{
+		Status:     recorded.Status,
+		StatusCode: recorded.StatusCode,
+		Proto:      recorded.Proto,
+		ProtoMajor: major,
+		ProtoMinor: minor,
+		Header:     recorded.Header.Clone(),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+	if recorded.Body != "" {
+		f, err := os.Open(c.path(recorded.Body))
+		if err != nil {
+			return nil, fmt.Errorf("replay %s %s: %w", req.Method, redact.URL(req.URL), err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		resp.Body = f
+		resp.ContentLength = fi.Size()
+		if resp.Header.Get("Content-Length") != "" {
+			resp.Header.Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,207 @@
+package httplog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// handlerTransport serves requests with a handler, without the network. The
+// request body is closed before the response is returned, so that recorded
+// exchanges are complete when the response body is read.
+type handlerTransport struct {
+	http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil {
+		req.Body = http.NoBody
+	}
+	rec := httptest.NewRecorder()
+	t.ServeHTTP(rec, req)
+	req.Body.Close()
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func newTestHandler() http.Handler {
+	manifests := 0
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if err := r.ParseForm(); err != nil || r.PostForm.Get("password") != "pw" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"token":"secret-token","expires_in":300}`)
+		case "/v2/app/manifests/latest":
+			if r.Header.Get("Authorization") != "Bearer secret-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			manifests++
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprintf(w, "manifest %d", manifests)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func do(t *testing.T, client *http.Client, method string, u string, header http.Header, body string) (*http.Response, string) {
+	t.Helper()
+	var req *http.Request
+	var err error
+	if body != "" {
+		req, err = http.NewRequest(method, u, strings.NewReader(body))
+	} else {
+		req, err = http.NewRequest(method, u, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %s", method, u, err)
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: %s", method, u, err)
+	}
+	return resp, string(buf)
+}
+
+var (
+	formHeader = http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	authHeader = http.Header{"Authorization": {"Bearer secret-token"}}
+)
+
+func record(t *testing.T, c *Cassette) {
+	client := &http.Client{
+		Transport: &RecordRoundTripper{
+			RoundTripper: handlerTransport{newTestHandler()},
+			Cassette:     c,
+		},
+	}
+	do(t, client, "POST", "https://registry.example.com/token", formHeader, "grant_type=password&username=user&password=pw")
+	for i := 0; i < 2; i++ {
+		do(t, client, "GET", "https://registry.example.com/v2/app/manifests/latest?access_token=secret-token", authHeader, "")
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	record(t, &Cassette{Dir: dir})
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, name := range files {
+		names = append(names, filepath.Base(name))
+		buf, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(buf), "secret-token") || strings.Contains(string(buf), "password=pw") {
+			t.Errorf("%s has credentials:\n%s", filepath.Base(name), buf)
+		}
+	}
+	wantNames := "0001.json 0001.request 0001.response 0002.json 0002.response 0003.json 0003.response"
+	if got := strings.Join(names, " "); got != wantNames {
+		t.Errorf("cassette files: got %s, want %s", got, wantNames)
+	}
+	if buf, _ := ioutil.ReadFile(filepath.Join(dir, "0001.response")); string(buf) != `{"token":"***","expires_in":300}` {
+		t.Errorf("0001.response: got %s", buf)
+	}
+
+	matcher, err := NewMatcher([]string{"method", "host", "path", "query", "header:Authorization"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{
+		Transport: &ReplayRoundTripper{
+			Cassette: &Cassette{Dir: dir, Match: matcher},
+		},
+	}
+
+	// The requests have other credentials, but they match the recorded
+	// requests after masking.
+	otherAuth := http.Header{"Authorization": {"Bearer other-token"}}
+	manifestURL := "https://registry.example.com/v2/app/manifests/latest?access_token=other-token"
+	testCases := []struct {
+		method string
+		url    string
+		header http.Header
+		body   string
+		want   string
+	}{
+		{"POST", "https://registry.example.com/token", formHeader, "grant_type=password&username=user&password=other", `{"token":"***","expires_in":300}`},
+		{"GET", manifestURL, otherAuth, "", "manifest 1"},
+		{"GET", manifestURL, otherAuth, "", "manifest 2"},
+		{"GET", manifestURL, otherAuth, "", "manifest 2"}, // the last match is replayed again
+	}
+	for _, tc := range testCases {
+		resp, body := do(t, client, tc.method, tc.url, tc.header, tc.body)
+		if resp.StatusCode != http.StatusOK || body != tc.want {
+			t.Errorf("%s %s: got %s %q, want %q", tc.method, tc.url, resp.Status, body, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		url    string
+		header http.Header
+	}{
+		{manifestURL, http.Header{"Authorization": {"Basic dXNlcjpwdw=="}}},
+		{"https://registry.example.com/v2/app/manifests/latest", otherAuth},
+		{"https://registry.example.com/v2/app/manifests/v1?access_token=other-token", otherAuth},
+	} {
+		req, err := http.NewRequest("GET", tc.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = tc.header
+		if _, err := client.Do(req); err == nil || !strings.Contains(err.Error(), "no matching request") {
+			t.Errorf("GET %s %v: got %v, want no matching request", tc.url, tc.header, err)
+		}
+	}
+}
+
+func TestRecordReplayUnsafe(t *testing.T) {
+	dir := t.TempDir()
+	record(t, &Cassette{Dir: dir, Unsafe: true})
+
+	if buf, _ := ioutil.ReadFile(filepath.Join(dir, "0001.response")); string(buf) != `{"token":"secret-token","expires_in":300}` {
+		t.Errorf("0001.response: got %s", buf)
+	}
+
+	client := &http.Client{
+		Transport: &ReplayRoundTripper{
+			Cassette: &Cassette{Dir: dir},
+		},
+	}
+	_, body := do(t, client, "GET", "https://registry.example.com/v2/app/manifests/latest?access_token=secret-token", nil, "")
+	if body != "manifest 1" {
+		t.Errorf("got %q, want %q", body, "manifest 1")
+	}
+
+	// The credentials are not masked, so they have to be the same.
+	req, err := http.NewRequest("GET", "https://registry.example.com/v2/app/manifests/latest?access_token=other-token", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(req); err == nil {
+		t.Errorf("a request with another token is replayed")
+	}
+}
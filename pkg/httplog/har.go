@@ -0,0 +1,391 @@
+package httplog
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dmage/boater/pkg/redact"
+)
+
+// The HAR 1.2 format, see http://www.softwareishard.com/blog/har-12-spec/.
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// HAR collects HTTP exchanges and writes them to the file Path in the HAR 1.2
+// format. Entries are appended to the file as exchanges complete, and the file
+// is kept valid after each of them, so it is complete even if the program
+// exits abruptly. Credentials are masked unless Unsafe is set.
+//
+// A HAR can be shared by several HARRoundTrippers.
+type HAR struct {
+	Path    string
+	Limit   int  // keep at most Limit bytes of each body
+	Unsafe  bool // keep credentials as is
+	Creator string
+
+	mu      sync.Mutex
+	f       *os.File
+	off     int64 // the offset of the end of the last entry
+	entries int
+}
+
+const (
+	harEntryIndent = "      "
+	harFooter      = "\n    ]\n  }\n}\n"
+)
+
+func (h *HAR) add(entry harEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.write(entry); err != nil {
+		log.Printf("unable to write %s: %s", h.Path, err)
+	}
+}
+
+// write appends the entry to the file and writes the end of the log after it.
+// The next entry overwrites the end of the log.
+func (h *HAR) write(entry harEntry) error {
+	if h.f == nil {
+		creator, err := json.Marshal(harCreator{Name: h.Creator, Version: "1"})
+		if err != nil {
+			return err
+		}
+		f, err := os.Create(h.Path)
+		if err != nil {
+			return err
+		}
+		header := "{\n  \"log\": {\n    \"version\": \"1.2\",\n    \"creator\": " + string(creator) + ",\n    \"entries\": ["
+		if _, err := f.WriteString(header); err != nil {
+			f.Close()
+			return err
+		}
+		h.f = f
+		h.off = int64(len(header))
+	}
+
+	buf, err := json.MarshalIndent(entry, harEntryIndent, "  ")
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if h.entries > 0 {
+		data = append(data, ',')
+	}
+	data = append(data, '\n')
+	data = append(data, harEntryIndent...)
+	data = append(data, buf...)
+	if _, err := h.f.WriteAt(append(data, harFooter...), h.off); err != nil {
+		return err
+	}
+	h.off += int64(len(data))
+	h.entries++
+	return nil
+}
+
+func (h *HAR) headers(header http.Header) []harNameValue {
+	list := []harNameValue{}
+	var keys []string
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range header[k] {
+			if !h.Unsafe {
+				v = redact.Header(k, v)
+			}
+			list = append(list, harNameValue{Name: k, Value: v})
+		}
+	}
+	return list
+}
+
+// text returns the body for a HAR entry: the text itself if it is valid
+// UTF-8, otherwise the base64 encoding.
+func (h *HAR) text(body []byte, truncated bool) (text string, encoding string, comment string) {
+	if !h.Unsafe {
+		body = redact.Body(body)
+	}
+	if truncated {
+		comment = "truncated"
+	}
+	if utf8.Valid(body) {
+		return string(body), "", comment
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64", comment
+}
+
+// capture keeps the first bytes of a body and counts its size. done is
+// called once when the body is read to the end or closed.
+type capture struct {
+	rc    io.ReadCloser
+	limit int
+	buf   []byte
+	size  int64
+	trunc bool
+	once  sync.Once
+	done  func(c *capture)
+}
+
+func (c *capture) Read(p []byte) (int, error) {
+	n, err := c.rc.Read(p)
+	c.size += int64(n)
+	if keep := c.limit - len(c.buf); keep > 0 {
+		if keep > n {
+			keep = n
+		}
+		c.buf = append(c.buf, p[:keep]...)
+	}
+	if len(c.buf) < int(c.size) {
+		c.trunc = true
+	}
+	if err != nil {
+		c.finish()
+	}
+	return n, err
+}
+
+func (c *capture) Close() error {
+	err := c.rc.Close()
+	c.finish()
+	return err
+}
+
+func (c *capture) finish() {
+	c.once.Do(func() {
+		if c.done != nil {
+			c.done(c)
+		}
+	})
+}
+
+// barrier calls fn when done has been called n times, i.e. when all parts of
+// an exchange are complete. The transport may still read the request body
+// after the response is returned, so the request body is a separate part.
+type barrier struct {
+	mu sync.Mutex
+	n  int
+	fn func()
+}
+
+func (b *barrier) done() {
+	b.mu.Lock()
+	b.n--
+	last := b.n == 0
+	b.mu.Unlock()
+	if last {
+		b.fn()
+	}
+}
+
+// HARRoundTripper adds the exchanges to HAR.
+type HARRoundTripper struct {
+	http.RoundTripper
+	HAR *HAR
+}
+
+func (rt *HARRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := rt.RoundTripper
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	h := rt.HAR
+
+	started := time.Now()
+	u := req.URL.String()
+	if !h.Unsafe {
+		u = redact.URL(req.URL)
+	}
+	entry := harEntry{
+		StartedDateTime: started.Format(time.RFC3339Nano),
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         u,
+			HTTPVersion: req.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     h.headers(req.Header),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    0,
+		},
+	}
+	query := req.URL.Query()
+	var keys []string
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range query[k] {
+			if !h.Unsafe && redact.IsSecretParameter(k) {
+				v = redact.Mask
+			}
+			entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{Name: k, Value: v})
+		}
+	}
+
+	b := &barrier{n: 1}
+	var reqBody *capture
+	if req.Body != nil && req.Body != http.NoBody {
+		b.n++
+		reqBody = &capture{
+			rc:    req.Body,
+			limit: h.Limit,
+			done:  func(*capture) { b.done() },
+		}
+		req.Body = reqBody
+	}
+	b.fn = func() {
+		if reqBody != nil {
+			text, encoding, comment := h.text(reqBody.buf, reqBody.trunc)
+			entry.Request.BodySize = reqBody.size
+			entry.Request.PostData = &harPostData{
+				MimeType: req.Header.Get("Content-Type"),
+				Text:     text,
+				Encoding: encoding,
+				Comment:  comment,
+			}
+		}
+		h.add(entry)
+	}
+
+	resp, err := transport.RoundTrip(req)
+	wait := time.Since(started)
+	if err != nil {
+		entry.Time = ms(wait)
+		entry.Timings = harTimings{Send: 0, Wait: ms(wait), Receive: 0}
+		entry.Response = harResponse{
+			Cookies:     []harNameValue{},
+			Headers:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		}
+		entry.Comment = err.Error()
+		b.done()
+		return nil, err
+	}
+
+	entry.Response = harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode))),
+		HTTPVersion: resp.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     h.headers(resp.Header),
+		RedirectURL: "",
+		HeadersSize: -1,
+		Content: harContent{
+			MimeType: resp.Header.Get("Content-Type"),
+		},
+	}
+	if loc := resp.Header.Get("Location"); loc != "" {
+		entry.Response.RedirectURL = loc
+		if !h.Unsafe {
+			entry.Response.RedirectURL = redact.URLString(loc)
+		}
+	}
+
+	finish := func(c *capture) {
+		total := time.Since(started)
+		entry.Time = ms(total)
+		entry.Timings = harTimings{Send: 0, Wait: ms(wait), Receive: ms(total - wait)}
+		entry.Response.BodySize = c.size
+		entry.Response.Content.Size = c.size
+		entry.Response.Content.Text, entry.Response.Content.Encoding, entry.Response.Content.Comment = h.text(c.buf, c.trunc)
+		b.done()
+	}
+	if resp.Body == nil || resp.Body == http.NoBody {
+		finish(&capture{})
+		return resp, nil
+	}
+	resp.Body = &capture{
+		rc:    resp.Body,
+		limit: h.Limit,
+		done:  finish,
+	}
+	return resp, nil
+}
+
+// ms returns d in milliseconds.
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
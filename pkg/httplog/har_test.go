@@ -0,0 +1,118 @@
+package httplog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHAR(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/small":
+			w.Write([]byte("ok"))
+		case "/upload":
+			if len(body) != 100 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case "/exact":
+			w.Write([]byte(strings.Repeat("b", 10)))
+		case "/big":
+			w.Write([]byte(strings.Repeat("b", 100)))
+		}
+	})
+
+	path := filepath.Join(t.TempDir(), "boater.har")
+	h := &HAR{Path: path, Limit: 10, Creator: "boater"}
+	client := &http.Client{
+		Transport: &HARRoundTripper{
+			RoundTripper: handlerTransport{handler},
+			HAR:          h,
+		},
+	}
+
+	type want struct {
+		url        string
+		status     int
+		reqText    string
+		reqSize    int64
+		reqComment string
+		text       string
+		size       int64
+		comment    string
+	}
+	testCases := []struct {
+		method string
+		url    string
+		body   string
+		want   want
+	}{
+		{
+			method: "GET",
+			url:    "https://example.com/small?token=abc",
+			want:   want{url: "https://example.com/small?token=***", status: 200, text: "ok", size: 2},
+		},
+		{
+			method: "PUT",
+			url:    "https://example.com/upload",
+			body:   strings.Repeat("a", 100),
+			want:   want{url: "https://example.com/upload", status: 201, reqText: "aaaaaaaaaa", reqSize: 100, reqComment: "truncated"},
+		},
+		{
+			method: "GET",
+			url:    "https://example.com/exact",
+			want:   want{url: "https://example.com/exact", status: 200, text: "bbbbbbbbbb", size: 10},
+		},
+		{
+			method: "GET",
+			url:    "https://example.com/big",
+			want:   want{url: "https://example.com/big", status: 200, text: "bbbbbbbbbb", size: 100, comment: "truncated"},
+		},
+	}
+	for i, tc := range testCases {
+		do(t, client, tc.method, tc.url, authHeader, tc.body)
+
+		// The file is valid after each exchange.
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var har struct {
+			Log harLog `json:"log"`
+		}
+		if err := json.Unmarshal(buf, &har); err != nil {
+			t.Fatalf("after %s %s: %s:\n%s", tc.method, tc.url, err, buf)
+		}
+		if har.Log.Version != "1.2" || har.Log.Creator.Name != "boater" {
+			t.Errorf("after %s %s: got version %q, creator %q", tc.method, tc.url, har.Log.Version, har.Log.Creator.Name)
+		}
+		if len(har.Log.Entries) != i+1 {
+			t.Fatalf("after %s %s: got %d entries, want %d", tc.method, tc.url, len(har.Log.Entries), i+1)
+		}
+
+		entry := har.Log.Entries[i]
+		got := want{
+			url:    entry.Request.URL,
+			status: entry.Response.Status,
+			text:   entry.Response.Content.Text,
+			size:   entry.Response.Content.Size,
+		}
+		got.comment = entry.Response.Content.Comment
+		if pd := entry.Request.PostData; pd != nil {
+			got.reqText, got.reqComment = pd.Text, pd.Comment
+			got.reqSize = entry.Request.BodySize
+		}
+		if got != tc.want {
+			t.Errorf("%s %s: got %+v, want %+v", tc.method, tc.url, got, tc.want)
+		}
+		if auth := entry.Request.Headers; len(auth) != 1 || auth[0].Value != "Bearer ***" {
+			t.Errorf("%s %s: got headers %v, want a masked Authorization header", tc.method, tc.url, auth)
+		}
+	}
+}
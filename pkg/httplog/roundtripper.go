@@ -6,9 +6,11 @@ import (
 	"log"
 	"net/http"
 	"sort"
+
+	"github.com/dmage/boater/pkg/redact"
 )
 
-func logHeader(prefix string, header http.Header) {
+func logHeader(prefix string, header http.Header, unsafe bool) {
 	var keys []string
 	for k := range header {
 		keys = append(keys, k)
@@ -16,6 +18,9 @@ func logHeader(prefix string, header http.Header) {
 	sort.Strings(keys)
 	for _, k := range keys {
 		for _, v := range header[k] {
+			if !unsafe {
+				v = redact.Header(k, v)
+			}
 			log.Printf("%s%s: %s", prefix, k, v)
 		}
 	}
@@ -26,19 +31,21 @@ type logReader struct {
 	prefix  string
 	rc      io.ReadCloser
 	n       int
+	unsafe  bool
 	toLog   []byte
 	trunc   bool
 	printed bool
 }
 
-func newLogReader(prefix string, rc io.ReadCloser, n int) io.ReadCloser {
-	if rc == nil {
-		return nil
+func newLogReader(prefix string, rc io.ReadCloser, n int, unsafe bool) io.ReadCloser {
+	if rc == nil || n <= 0 {
+		return rc
 	}
 	return &logReader{
 		prefix: prefix,
 		rc:     rc,
 		n:      n,
+		unsafe: unsafe,
 	}
 }
 
@@ -67,15 +74,21 @@ func (r *logReader) print() {
 	if r.printed {
 		return
 	}
-	for offset := 0; offset < len(r.toLog); offset += 0x10 {
-		chunk := r.toLog[offset:]
+	toLog := r.toLog
+	if !r.unsafe {
+		toLog = redact.Body(toLog)
+	}
+	for offset := 0; offset < len(toLog); offset += 0x10 {
+		chunk := toLog[offset:]
 		if len(chunk) > 0x10 {
 			chunk = chunk[:0x10]
 		}
 
-		chunkHex := fmt.Sprintf("% 02x", chunk[:8])
+		var chunkHex string
 		if len(chunk) > 8 {
-			chunkHex += "  " + fmt.Sprintf("% 02x", chunk[8:])
+			chunkHex = fmt.Sprintf("% 02x  % 02x", chunk[:8], chunk[8:])
+		} else {
+			chunkHex = fmt.Sprintf("% 02x", chunk)
 		}
 
 		for i, b := range chunk {
@@ -97,21 +110,28 @@ func (r *logReader) Close() error {
 	return r.rc.Close()
 }
 
-func logRequest(req *http.Request, n int) {
-	log.Printf("-> %s %s %s", req.Method, req.URL, req.Proto)
-	logHeader("-> ", req.Header)
-	req.Body = newLogReader("-> ", req.Body, n)
+func logRequest(req *http.Request, n int, unsafe bool) {
+	u := req.URL.String()
+	if !unsafe {
+		u = redact.URL(req.URL)
+	}
+	log.Printf("-> %s %s %s", req.Method, u, req.Proto)
+	logHeader("-> ", req.Header, unsafe)
+	req.Body = newLogReader("-> ", req.Body, n, unsafe)
 }
 
-func logResponse(resp *http.Response, n int) {
+func logResponse(resp *http.Response, n int, unsafe bool) {
 	log.Printf("<- %s %s", resp.Proto, resp.Status)
-	logHeader("<- ", resp.Header)
-	resp.Body = newLogReader("<- ", resp.Body, n)
+	logHeader("<- ", resp.Header, unsafe)
+	resp.Body = newLogReader("<- ", resp.Body, n, unsafe)
 }
 
+// RoundTripper logs requests and responses. Credentials in headers, URLs and
+// bodies are masked unless Unsafe is set.
 type RoundTripper struct {
 	http.RoundTripper
-	N int // log N first bytes
+	N      int  // log N first bytes
+	Unsafe bool // log credentials as is
 }
 
 func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -120,14 +140,14 @@ func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 		transport = http.DefaultTransport
 	}
 
-	logRequest(req, rt.N)
+	logRequest(req, rt.N, rt.Unsafe)
 
 	resp, err := transport.RoundTrip(req)
 	if err != nil {
 		return nil, err
 	}
 
-	logResponse(resp, rt.N)
+	logResponse(resp, rt.N, rt.Unsafe)
 
 	return resp, err
 }
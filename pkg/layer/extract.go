@@ -0,0 +1,203 @@
+package layer
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Extractor applies layers to a directory on the local file system.
+//
+// Device nodes and FIFOs are skipped, as creating them requires privileges.
+type Extractor struct {
+	Dir string
+
+	// Chown enables changing owners of extracted files. It usually requires
+	// root privileges.
+	Chown bool
+}
+
+// securePath returns the local path for name and checks that none of its
+// parent directories inside Dir is a symlink, so that entries cannot be
+// written outside of Dir.
+func (e *Extractor) securePath(name string) (string, error) {
+	parts := strings.Split(name, "/")
+	p := e.Dir
+	for _, part := range parts[:len(parts)-1] {
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if !fi.IsDir() {
+			return "", fmt.Errorf("%s: parent %s is not a directory", name, p)
+		}
+	}
+	return filepath.Join(e.Dir, filepath.FromSlash(name)), nil
+}
+
+func (e *Extractor) removeChildren(dir string, keep map[string]bool) error {
+	p, err := e.securePath(dir)
+	if err != nil {
+		return err
+	}
+	children, err := ioutil.ReadDir(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		name := Clean(path.Join(dir, child.Name()))
+		if keep[name] {
+			continue
+		}
+		if child.IsDir() {
+			if err := e.removeChildren(name, keep); err != nil {
+				return err
+			}
+			if hasKept(name, keep) {
+				continue
+			}
+		}
+		if err := os.RemoveAll(filepath.Join(p, child.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasKept(dir string, keep map[string]bool) bool {
+	for name := range keep {
+		if IsUnder(name, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply reads the uncompressed layer tarball r and applies it to Dir.
+func (e *Extractor) Apply(r io.Reader) error {
+	if err := os.MkdirAll(e.Dir, 0755); err != nil {
+		return err
+	}
+
+	// Opaque directories hide only entries from lower layers, so we need to
+	// know which entries are provided by this layer.
+	extracted := make(map[string]bool)
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if wh, ok := ParseWhiteout(hdr.Name); ok {
+			if wh.Name == "" {
+				err = e.removeChildren(wh.Dir, extracted)
+			} else {
+				var p string
+				p, err = e.securePath(wh.Path())
+				if err == nil {
+					err = os.RemoveAll(p)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", hdr.Name, err)
+			}
+			continue
+		}
+
+		name := Clean(hdr.Name)
+		if name == "" {
+			continue
+		}
+		if err := e.extract(name, hdr, tr); err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+		extracted[name] = true
+	}
+}
+
+func (e *Extractor) extract(name string, hdr *tar.Header, r io.Reader) error {
+	p, err := e.securePath(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	fi, err := os.Lstat(p)
+	if err == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+
+	mode := os.FileMode(hdr.Mode).Perm()
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(p, 0755); err != nil {
+			return err
+		}
+		if err := os.Chmod(p, mode|0700); err != nil {
+			return err
+		}
+	case tar.TypeReg:
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode|0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(p, mode); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, p); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		target, err := e.securePath(Clean(hdr.Linkname))
+		if err != nil {
+			return err
+		}
+		if err := os.Link(target, p); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	if e.Chown {
+		if err := os.Lchown(p, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+	if hdr.Typeflag == tar.TypeReg {
+		if err := os.Chtimes(p, hdr.ModTime, hdr.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
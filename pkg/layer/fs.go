@@ -0,0 +1,135 @@
+package layer
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Entry is a file system entry.
+type Entry struct {
+	// Name is the cleaned path of the entry.
+	Name string
+
+	Header *tar.Header
+
+	// Digest is the digest of the file contents. It is set only for regular
+	// files and only if the contents were hashed.
+	Digest digest.Digest
+
+	// Layer is the index of the layer that provided the entry.
+	Layer int
+
+	// Index is the position of the entry in the layer tarball.
+	Index int
+}
+
+// FS is a flattened view of layers that were applied on top of each other.
+// It keeps only metadata, file contents are not stored.
+type FS struct {
+	entries map[string]*Entry
+	layers  int
+
+	// HashContents enables computing digests for regular files.
+	HashContents bool
+}
+
+// NewFS returns an empty file system.
+func NewFS() *FS {
+	return &FS{
+		entries: make(map[string]*Entry),
+	}
+}
+
+// Apply reads the uncompressed layer tarball r and applies it on top of the
+// file system. Whiteouts in the layer hide entries from lower layers only.
+func (fs *FS) Apply(r io.Reader) error {
+	var entries []*Entry
+	var whiteouts []Whiteout
+
+	tr := tar.NewReader(r)
+	for index := 0; ; index++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("layer %d: %w", fs.layers, err)
+		}
+
+		if wh, ok := ParseWhiteout(hdr.Name); ok {
+			whiteouts = append(whiteouts, wh)
+			continue
+		}
+
+		entry := &Entry{
+			Name:   Clean(hdr.Name),
+			Header: hdr,
+			Layer:  fs.layers,
+			Index:  index,
+		}
+		if fs.HashContents && hdr.Typeflag == tar.TypeReg {
+			digester := digest.Canonical.Digester()
+			if _, err := io.Copy(digester.Hash(), tr); err != nil {
+				return fmt.Errorf("layer %d: %s: %w", fs.layers, hdr.Name, err)
+			}
+			entry.Digest = digester.Digest()
+		}
+		entries = append(entries, entry)
+	}
+
+	for _, wh := range whiteouts {
+		if wh.Name == "" {
+			fs.removeChildren(wh.Dir)
+		} else {
+			fs.remove(wh.Path())
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.Name == "" {
+			continue
+		}
+		if old, ok := fs.entries[entry.Name]; ok && old.Header.Typeflag == tar.TypeDir && entry.Header.Typeflag != tar.TypeDir {
+			fs.removeChildren(entry.Name)
+		}
+		fs.entries[entry.Name] = entry
+	}
+
+	fs.layers++
+	return nil
+}
+
+func (fs *FS) remove(name string) {
+	delete(fs.entries, name)
+	fs.removeChildren(name)
+}
+
+func (fs *FS) removeChildren(dir string) {
+	for name := range fs.entries {
+		if name != dir && IsUnder(name, dir) {
+			delete(fs.entries, name)
+		}
+	}
+}
+
+// Get returns the entry for the path name.
+func (fs *FS) Get(name string) (*Entry, bool) {
+	entry, ok := fs.entries[Clean(name)]
+	return entry, ok
+}
+
+// Entries returns all entries sorted by their names.
+func (fs *FS) Entries() []*Entry {
+	entries := make([]*Entry, 0, len(fs.entries))
+	for _, entry := range fs.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
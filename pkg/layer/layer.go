@@ -0,0 +1,115 @@
+// Package layer reads image layers and applies them on top of each other.
+package layer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// WhiteoutPrefix is the prefix of files that mark deleted paths.
+	WhiteoutPrefix = ".wh."
+
+	// WhiteoutOpaqueDir is the name of the file that marks a directory as
+	// opaque, i.e. all its contents from lower layers are hidden.
+	WhiteoutOpaqueDir = ".wh..wh..opq"
+)
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc readCloser) Close() error {
+	return rc.close()
+}
+
+// Decompress returns a reader for the uncompressed tarball of the layer r.
+// The compression is detected by the magic bytes at the beginning of the
+// stream, so mediaType is used only in error messages.
+func Decompress(r io.Reader, mediaType string) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch DetectCompression(magic) {
+	case Gzip:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("decompress %s: %w", mediaType, err)
+		}
+		return zr, nil
+	case Zstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("decompress %s: %w", mediaType, err)
+		}
+		return readCloser{
+			Reader: zr,
+			close: func() error {
+				zr.Close()
+				return nil
+			},
+		}, nil
+	}
+
+	return readCloser{
+		Reader: br,
+		close:  func() error { return nil },
+	}, nil
+}
+
+// Clean returns the canonical form of the path name from a tar header: it is
+// relative to the root and it does not have trailing slashes.
+func Clean(name string) string {
+	name = path.Clean("/" + name)
+	return strings.TrimPrefix(name, "/")
+}
+
+// Whiteout describes a whiteout file.
+type Whiteout struct {
+	// Dir is the directory that contains the whiteout file.
+	Dir string
+
+	// Name is the name of the deleted file, or an empty string if the
+	// whiteout marks Dir as opaque.
+	Name string
+}
+
+// Path returns the deleted path, or the opaque directory.
+func (w Whiteout) Path() string {
+	if w.Name == "" {
+		return w.Dir
+	}
+	return Clean(path.Join(w.Dir, w.Name))
+}
+
+// ParseWhiteout checks if name is a whiteout file.
+func ParseWhiteout(name string) (Whiteout, bool) {
+	name = Clean(name)
+	dir, base := path.Split(name)
+	dir = Clean(dir)
+	if base == WhiteoutOpaqueDir {
+		return Whiteout{Dir: dir}, true
+	}
+	if strings.HasPrefix(base, WhiteoutPrefix) {
+		return Whiteout{Dir: dir, Name: strings.TrimPrefix(base, WhiteoutPrefix)}, true
+	}
+	return Whiteout{}, false
+}
+
+// IsUnder returns true if name is dir itself or is located inside dir.
+func IsUnder(name, dir string) bool {
+	if dir == "" {
+		return true
+	}
+	return name == dir || strings.HasPrefix(name, dir+"/")
+}
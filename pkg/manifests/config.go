@@ -1,6 +1,7 @@
 package manifests
 
 import (
+	"reflect"
 	"regexp"
 	"time"
 
@@ -9,48 +10,109 @@ import (
 
 var reNewLine = regexp.MustCompile(`( {4,}|[ \t]*\t[ \t]*)|\n`)
 
+func dumpList(prefix string, key string, values []string) {
+	if len(values) > 0 {
+		printer.Keyln(prefix, key)
+		for _, value := range values {
+			printer.Valueln(prefix+"- ", value)
+		}
+	}
+}
+
 type HealthConfig struct {
-	Test     []string
-	Interval int
-	Timeout  int
-	Retries  int
+	Test          []string `json:"Test,omitempty"`
+	Interval      int64    `json:"Interval,omitempty"`
+	Timeout       int64    `json:"Timeout,omitempty"`
+	StartPeriod   int64    `json:"StartPeriod,omitempty"`
+	StartInterval int64    `json:"StartInterval,omitempty"`
+	Retries       int      `json:"Retries,omitempty"`
+
+	raw Object
+}
+
+type jsonHealthConfig HealthConfig
+
+func (hc *HealthConfig) UnmarshalJSON(data []byte) error {
+	return hc.raw.unmarshal(data, (*jsonHealthConfig)(hc))
+}
+
+func (hc HealthConfig) MarshalJSON() ([]byte, error) {
+	return hc.raw.marshal(jsonHealthConfig(hc))
 }
 
 func (hc HealthConfig) Dump(prefix string) {
-	if len(hc.Test) > 0 {
-		printer.Keyln(prefix, "test")
-		for _, test := range hc.Test {
-			printer.Valueln(prefix+"- ", test)
-		}
-	}
+	dumpList(prefix, "test", hc.Test)
 	if hc.Interval != 0 {
-		printer.KeyValueln(prefix, "interval", hc.Interval)
+		printer.KeyValueln(prefix, "interval", time.Duration(hc.Interval))
 	}
 	if hc.Timeout != 0 {
-		printer.KeyValueln(prefix, "timeout", hc.Timeout)
+		printer.KeyValueln(prefix, "timeout", time.Duration(hc.Timeout))
+	}
+	if hc.StartPeriod != 0 {
+		printer.KeyValueln(prefix, "start_period", time.Duration(hc.StartPeriod))
+	}
+	if hc.StartInterval != 0 {
+		printer.KeyValueln(prefix, "start_interval", time.Duration(hc.StartInterval))
 	}
 	if hc.Retries != 0 {
 		printer.KeyValueln(prefix, "retries", hc.Retries)
 	}
+	hc.raw.dumpExtra(prefix, reflect.TypeOf(jsonHealthConfig{}))
 }
 
+// ContainerConfig is the configuration for containers that are run from an
+// image. It contains fields from both the OCI image spec and the Docker
+// container config.
 type ContainerConfig struct {
-	User         string
-	Memory       int64
-	MemorySwap   int64
-	CpuShares    int64
-	ExposedPorts map[string]struct{}
-	Env          []string
-	Entrypoint   []string
-	Cmd          []string
-	Healthcheck  HealthConfig
-	Volumes      map[string]struct{}
-	WorkingDir   string
-	Labels       map[string]string
-	StopSignal   string
+	Hostname        string              `json:"Hostname,omitempty"`
+	Domainname      string              `json:"Domainname,omitempty"`
+	User            string              `json:"User,omitempty"`
+	Memory          int64               `json:"Memory,omitempty"`
+	MemorySwap      int64               `json:"MemorySwap,omitempty"`
+	CpuShares       int64               `json:"CpuShares,omitempty"`
+	AttachStdin     bool                `json:"AttachStdin,omitempty"`
+	AttachStdout    bool                `json:"AttachStdout,omitempty"`
+	AttachStderr    bool                `json:"AttachStderr,omitempty"`
+	ExposedPorts    map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Tty             bool                `json:"Tty,omitempty"`
+	OpenStdin       bool                `json:"OpenStdin,omitempty"`
+	StdinOnce       bool                `json:"StdinOnce,omitempty"`
+	Env             []string            `json:"Env,omitempty"`
+	Entrypoint      []string            `json:"Entrypoint,omitempty"`
+	Cmd             []string            `json:"Cmd,omitempty"`
+	Healthcheck     HealthConfig        `json:"Healthcheck,omitempty"`
+	ArgsEscaped     bool                `json:"ArgsEscaped,omitempty"`
+	Image           string              `json:"Image,omitempty"`
+	Volumes         map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir      string              `json:"WorkingDir,omitempty"`
+	NetworkDisabled bool                `json:"NetworkDisabled,omitempty"`
+	MacAddress      string              `json:"MacAddress,omitempty"`
+	OnBuild         []string            `json:"OnBuild,omitempty"`
+	Labels          map[string]string   `json:"Labels,omitempty"`
+	StopSignal      string              `json:"StopSignal,omitempty"`
+	StopTimeout     *int                `json:"StopTimeout,omitempty"`
+	Shell           []string            `json:"Shell,omitempty"`
+
+	raw Object
+}
+
+type jsonContainerConfig ContainerConfig
+
+func (cc *ContainerConfig) UnmarshalJSON(data []byte) error {
+	return cc.raw.unmarshal(data, (*jsonContainerConfig)(cc))
+}
+
+func (cc ContainerConfig) MarshalJSON() ([]byte, error) {
+	return cc.raw.marshal(jsonContainerConfig(cc))
 }
 
 func (cc ContainerConfig) Dump(prefix string) {
+	if cc.Hostname != "" {
+		printer.KeyValueln(prefix, "Hostname", cc.Hostname)
+	}
+	if cc.Domainname != "" {
+		printer.KeyValueln(prefix, "Domainname", cc.Domainname)
+	}
 	if cc.User != "" {
 		printer.KeyValueln(prefix, "User", cc.User)
 	}
@@ -63,34 +125,43 @@ func (cc ContainerConfig) Dump(prefix string) {
 	if cc.CpuShares != 0 {
 		printer.KeyValueln(prefix, "CpuShares", cc.CpuShares)
 	}
+	if cc.AttachStdin {
+		printer.KeyValueln(prefix, "AttachStdin", cc.AttachStdin)
+	}
+	if cc.AttachStdout {
+		printer.KeyValueln(prefix, "AttachStdout", cc.AttachStdout)
+	}
+	if cc.AttachStderr {
+		printer.KeyValueln(prefix, "AttachStderr", cc.AttachStderr)
+	}
 	if len(cc.ExposedPorts) > 0 {
 		printer.Keyln(prefix, "ExposedPorts")
 		for port := range cc.ExposedPorts {
 			printer.Valueln(prefix+"- ", port)
 		}
 	}
-	if len(cc.Env) > 0 {
-		printer.Keyln(prefix, "Env")
-		for _, env := range cc.Env {
-			printer.Valueln(prefix+"- ", env)
-		}
+	if cc.Tty {
+		printer.KeyValueln(prefix, "Tty", cc.Tty)
 	}
-	if len(cc.Entrypoint) > 0 {
-		printer.Keyln(prefix, "Entrypoint")
-		for _, entrypoint := range cc.Entrypoint {
-			printer.Valueln(prefix+"- ", entrypoint)
-		}
+	if cc.OpenStdin {
+		printer.KeyValueln(prefix, "OpenStdin", cc.OpenStdin)
 	}
-	if len(cc.Cmd) > 0 {
-		printer.Keyln(prefix, "Cmd")
-		for _, cmd := range cc.Cmd {
-			printer.Valueln(prefix+"- ", cmd)
-		}
+	if cc.StdinOnce {
+		printer.KeyValueln(prefix, "StdinOnce", cc.StdinOnce)
 	}
+	dumpList(prefix, "Env", cc.Env)
+	dumpList(prefix, "Entrypoint", cc.Entrypoint)
+	dumpList(prefix, "Cmd", cc.Cmd)
 	if len(cc.Healthcheck.Test) > 0 {
 		printer.Keyln(prefix, "Healthcheck")
 		cc.Healthcheck.Dump(prefix + "  ")
 	}
+	if cc.ArgsEscaped {
+		printer.KeyValueln(prefix, "ArgsEscaped", cc.ArgsEscaped)
+	}
+	if cc.Image != "" {
+		printer.KeyValueln(prefix, "Image", cc.Image)
+	}
 	if len(cc.Volumes) > 0 {
 		printer.Keyln(prefix, "Volumes")
 		for volume := range cc.Volumes {
@@ -100,6 +171,13 @@ func (cc ContainerConfig) Dump(prefix string) {
 	if cc.WorkingDir != "" {
 		printer.KeyValueln(prefix, "WorkingDir", cc.WorkingDir)
 	}
+	if cc.NetworkDisabled {
+		printer.KeyValueln(prefix, "NetworkDisabled", cc.NetworkDisabled)
+	}
+	if cc.MacAddress != "" {
+		printer.KeyValueln(prefix, "MacAddress", cc.MacAddress)
+	}
+	dumpList(prefix, "OnBuild", cc.OnBuild)
 	if len(cc.Labels) > 0 {
 		printer.Keyln(prefix, "Labels")
 		for key, value := range cc.Labels {
@@ -109,11 +187,28 @@ func (cc ContainerConfig) Dump(prefix string) {
 	if cc.StopSignal != "" {
 		printer.KeyValueln(prefix, "StopSignal", cc.StopSignal)
 	}
+	if cc.StopTimeout != nil {
+		printer.KeyValueln(prefix, "StopTimeout", *cc.StopTimeout)
+	}
+	dumpList(prefix, "Shell", cc.Shell)
+	cc.raw.dumpExtra(prefix, reflect.TypeOf(jsonContainerConfig{}))
 }
 
 type RootFS struct {
 	Type    string   `json:"type"`
 	DiffIDs []string `json:"diff_ids"`
+
+	raw Object
+}
+
+type jsonRootFS RootFS
+
+func (rfs *RootFS) UnmarshalJSON(data []byte) error {
+	return rfs.raw.unmarshal(data, (*jsonRootFS)(rfs))
+}
+
+func (rfs RootFS) MarshalJSON() ([]byte, error) {
+	return rfs.raw.marshal(jsonRootFS(rfs))
 }
 
 func (rfs RootFS) Dump(prefix string) {
@@ -122,14 +217,27 @@ func (rfs RootFS) Dump(prefix string) {
 	for _, diffID := range rfs.DiffIDs {
 		printer.Valueln(prefix+"- ", diffID)
 	}
+	rfs.raw.dumpExtra(prefix, reflect.TypeOf(jsonRootFS{}))
 }
 
 type History struct {
 	Created    time.Time `json:"created"`
-	Author     string    `json:"author"`
-	CreatedBy  string    `json:"created_by"`
-	Comment    string    `json:"comment"`
-	EmptyLayer bool      `json:"empty_layer"`
+	Author     string    `json:"author,omitempty"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	EmptyLayer bool      `json:"empty_layer,omitempty"`
+
+	raw Object
+}
+
+type jsonHistory History
+
+func (h *History) UnmarshalJSON(data []byte) error {
+	return h.raw.unmarshal(data, (*jsonHistory)(h))
+}
+
+func (h History) MarshalJSON() ([]byte, error) {
+	return h.raw.marshal(jsonHistory(h))
 }
 
 func (h History) Dump(prefix string, secondPrefix string) {
@@ -153,37 +261,94 @@ func (h History) Dump(prefix string, secondPrefix string) {
 		printer.KeyValueln(prefix, "empty_layer", "true")
 		prefix = secondPrefix
 	}
+	for _, key := range h.raw.extraFields(reflect.TypeOf(jsonHistory{})) {
+		printer.KeyValueln(prefix, key, string(h.raw.fields[key]))
+		prefix = secondPrefix
+	}
 }
 
+// ImageConfig is the image config. It contains fields from both the OCI
+// image spec and the Docker image spec.
+//
+// Fields that are not known are preserved when the config is decoded and
+// encoded back.
 type ImageConfig struct {
-	Created      time.Time       `json:"created"`
-	Author       string          `json:"author"`
-	Architecture string          `json:"architecture"`
-	OS           string          `json:"os"`
-	Config       ContainerConfig `json:"config"`
-	RootFS       RootFS          `json:"rootfs"`
-	History      []History       `json:"history"`
+	ID              string          `json:"id,omitempty"`
+	Parent          string          `json:"parent,omitempty"`
+	Comment         string          `json:"comment,omitempty"`
+	Created         time.Time       `json:"created"`
+	Container       string          `json:"container,omitempty"`
+	ContainerConfig ContainerConfig `json:"container_config,omitempty"`
+	DockerVersion   string          `json:"docker_version,omitempty"`
+	Author          string          `json:"author,omitempty"`
+	Architecture    string          `json:"architecture"`
+	Variant         string          `json:"variant,omitempty"`
+	OS              string          `json:"os"`
+	OSVersion       string          `json:"os.version,omitempty"`
+	OSFeatures      []string        `json:"os.features,omitempty"`
+	Config          ContainerConfig `json:"config"`
+	RootFS          RootFS          `json:"rootfs"`
+	History         []History       `json:"history,omitempty"`
+
+	raw Object
+}
+
+type jsonImageConfig ImageConfig
+
+func (ic *ImageConfig) UnmarshalJSON(data []byte) error {
+	return ic.raw.unmarshal(data, (*jsonImageConfig)(ic))
+}
+
+func (ic ImageConfig) MarshalJSON() ([]byte, error) {
+	return ic.raw.marshal(jsonImageConfig(ic))
 }
 
 func (ic ImageConfig) Dump(prefix string) {
+	if ic.ID != "" {
+		printer.KeyValueln(prefix, "id", ic.ID)
+	}
+	if ic.Parent != "" {
+		printer.KeyValueln(prefix, "parent", ic.Parent)
+	}
+	if ic.Comment != "" {
+		printer.KeyValueln(prefix, "comment", ic.Comment)
+	}
 	if !ic.Created.IsZero() {
 		printer.KeyValueln(prefix, "created", ic.Created.Format(time.RFC3339))
 	}
+	if ic.Container != "" {
+		printer.KeyValueln(prefix, "container", ic.Container)
+	}
+	if ic.DockerVersion != "" {
+		printer.KeyValueln(prefix, "docker_version", ic.DockerVersion)
+	}
 	if ic.Author != "" {
 		printer.KeyValueln(prefix, "author", ic.Author)
 	}
 	if ic.Architecture != "" {
 		printer.KeyValueln(prefix, "architecture", ic.Architecture)
 	}
+	if ic.Variant != "" {
+		printer.KeyValueln(prefix, "variant", ic.Variant)
+	}
 	if ic.OS != "" {
 		printer.KeyValueln(prefix, "os", ic.OS)
 	}
+	if ic.OSVersion != "" {
+		printer.KeyValueln(prefix, "os.version", ic.OSVersion)
+	}
+	dumpList(prefix, "os.features", ic.OSFeatures)
 	printer.Keyln(prefix, "config")
 	ic.Config.Dump(prefix + "  ")
+	if _, ok := ic.raw.fields["container_config"]; ok {
+		printer.Keyln(prefix, "container_config")
+		ic.ContainerConfig.Dump(prefix + "  ")
+	}
 	printer.Keyln(prefix, "rootfs")
 	ic.RootFS.Dump(prefix + "  ")
 	printer.Keyln(prefix, "history")
 	for _, history := range ic.History {
 		history.Dump(prefix+"- ", prefix+"  ")
 	}
+	ic.raw.dumpExtra(prefix, reflect.TypeOf(jsonImageConfig{}))
 }
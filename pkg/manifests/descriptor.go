@@ -7,7 +7,8 @@ import (
 	"github.com/opencontainers/go-digest"
 )
 
-func humanSize(b int64) string {
+// HumanSize formats the size b in bytes using binary units.
+func HumanSize(b int64) string {
 	if b < 1024 {
 		return fmt.Sprintf("%d B", b)
 	}
@@ -21,9 +22,11 @@ func humanSize(b int64) string {
 }
 
 type Descriptor struct {
-	MediaType string        `json:"mediaType"`
-	Size      int64         `json:"size"`
-	Digest    digest.Digest `json:"digest"`
+	MediaType    string            `json:"mediaType"`
+	Size         int64             `json:"size"`
+	Digest       digest.Digest     `json:"digest"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
 }
 
 func (d Descriptor) String() string {
@@ -32,7 +35,7 @@ func (d Descriptor) String() string {
 		extra = append(extra, d.MediaType)
 	}
 	if d.Size > 0 {
-		extra = append(extra, humanSize(d.Size))
+		extra = append(extra, HumanSize(d.Size))
 	}
 	if len(extra) > 0 {
 		return fmt.Sprintf("%s (%s)", d.Digest, strings.Join(extra, ", "))
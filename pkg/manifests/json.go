@@ -0,0 +1,272 @@
+package manifests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/dmage/boater/pkg/printer"
+)
+
+// MarshalJSON is like json.Marshal, but it does not escape HTML characters,
+// so that strings like "&&" in commands are kept as is.
+func MarshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// Object keeps the original representation of a JSON object, so that it can
+// be modified and encoded back without reordering or reformatting the fields
+// that are not modified.
+//
+// Object is also used by structs to keep the fields that they do not know
+// about. When such a struct is encoded, the original keys are written in
+// their original order. Values of known fields are kept as is unless they
+// were changed, and new non-zero fields are appended at the end.
+type Object struct {
+	keys   []string
+	fields map[string]json.RawMessage
+}
+
+// decode remembers the fields of the JSON object data. It returns false if
+// data is not an object.
+func (o *Object) decode(data []byte) (bool, error) {
+	o.keys = nil
+	o.fields = nil
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return false, err
+	}
+	if tok != json.Delim('{') {
+		return false, nil
+	}
+
+	o.fields = make(map[string]json.RawMessage)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return false, err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return false, fmt.Errorf("unexpected token %v", tok)
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return false, err
+		}
+		if _, ok := o.fields[key]; !ok {
+			o.keys = append(o.keys, key)
+		}
+		o.fields[key] = value
+	}
+	return true, nil
+}
+
+func (o *Object) UnmarshalJSON(data []byte) error {
+	isObject, err := o.decode(data)
+	if err != nil {
+		return err
+	}
+	if !isObject {
+		return fmt.Errorf("expected a JSON object")
+	}
+	return nil
+}
+
+func (o Object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, key := range o.keys {
+		if err := writeField(&buf, key, o.fields[key]); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Get decodes the value of the field key into v. If there is no such field,
+// v is not changed.
+func (o Object) Get(key string, v interface{}) error {
+	raw, ok := o.fields[key]
+	if !ok {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("decode %s: %w", key, err)
+	}
+	return nil
+}
+
+// Set replaces the value of the field key with v. New fields are appended
+// at the end.
+func (o *Object) Set(key string, v interface{}) error {
+	data, err := MarshalJSON(v)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", key, err)
+	}
+	if o.fields == nil {
+		o.fields = make(map[string]json.RawMessage)
+	}
+	if _, ok := o.fields[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.fields[key] = data
+	return nil
+}
+
+// Delete removes the field key and reports whether it was present.
+func (o *Object) Delete(key string) bool {
+	if _, ok := o.fields[key]; !ok {
+		return false
+	}
+	delete(o.fields, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i:i], o.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// writeField writes the field key with the value to the object that is being
+// encoded into buf.
+func writeField(buf *bytes.Buffer, key string, value json.RawMessage) error {
+	if buf.Len() > 1 {
+		buf.WriteByte(',')
+	}
+	k, err := MarshalJSON(key)
+	if err != nil {
+		return err
+	}
+	buf.Write(k)
+	buf.WriteByte(':')
+	buf.Write(value)
+	return nil
+}
+
+func jsonFieldName(f reflect.StructField) string {
+	tag := f.Tag.Get("json")
+	name := strings.Split(tag, ",")[0]
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+func knownFields(t reflect.Type) map[string]bool {
+	known := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if name := jsonFieldName(f); name != "-" {
+			known[strings.ToLower(name)] = true
+		}
+	}
+	return known
+}
+
+// unmarshal decodes data into v, which should be a pointer to a struct, and
+// remembers the original fields.
+func (o *Object) unmarshal(data []byte, v interface{}) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	_, err := o.decode(data)
+	return err
+}
+
+// marshal encodes v, which should be a struct, together with the fields that
+// v does not know about.
+func (o Object) marshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	rt := rv.Type()
+
+	values := make(map[string]json.RawMessage)
+	var newKeys []string
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		name := jsonFieldName(f)
+		if f.PkgPath != "" || name == "-" {
+			continue
+		}
+		fv := rv.Field(i)
+
+		if raw, ok := o.fields[name]; ok {
+			orig := reflect.New(f.Type)
+			if json.Unmarshal(raw, orig.Interface()) == nil && reflect.DeepEqual(orig.Elem().Interface(), fv.Interface()) {
+				values[name] = raw
+				continue
+			}
+		} else if fv.IsZero() {
+			continue
+		} else {
+			newKeys = append(newKeys, name)
+		}
+
+		data, err := MarshalJSON(fv.Interface())
+		if err != nil {
+			return nil, fmt.Errorf("encode %s: %w", name, err)
+		}
+		values[name] = data
+	}
+
+	known := knownFields(rt)
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, key := range o.keys {
+		value, ok := values[key]
+		if !ok {
+			// Keys that match known fields only case-insensitively are
+			// dropped, as encoding/json decoded them into the known fields.
+			if known[strings.ToLower(key)] {
+				continue
+			}
+			value = o.fields[key]
+		}
+		if err := writeField(&buf, key, value); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range newKeys {
+		if err := writeField(&buf, key, values[key]); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// extraFields returns the fields that are not known to the struct type t in
+// their original order.
+func (o Object) extraFields(t reflect.Type) []string {
+	known := knownFields(t)
+	var extra []string
+	for _, key := range o.keys {
+		if !known[strings.ToLower(key)] {
+			extra = append(extra, key)
+		}
+	}
+	return extra
+}
+
+// dumpExtra prints the fields that are not known to the struct type t as raw
+// JSON values.
+func (o Object) dumpExtra(prefix string, t reflect.Type) {
+	for _, key := range o.extraFields(t) {
+		printer.KeyValueln(prefix, key, string(o.fields[key]))
+	}
+}
@@ -0,0 +1,99 @@
+package manifests
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// dockerConfig is the config of a Docker image built by docker build.
+const dockerConfig = `{"architecture":"amd64","config":{"Hostname":"","Domainname":"","User":"","AttachStdin":false,"AttachStdout":false,"AttachStderr":false,"Tty":false,"OpenStdin":false,"StdinOnce":false,"Env":["PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"],"Cmd":["/bin/sh"],"Image":"sha256:9a6e5b4bd5aa7e4a3c1f0e6fc2b32c4b8c0ad80c1e8e2ad3b0e3e19a27f8f6a1","Volumes":null,"WorkingDir":"","Entrypoint":null,"OnBuild":null,"Labels":null},"container":"4d2fcf04f32f6bc2c6d8cb2cf2e4a6a7b47ae9d2c3e36e1c7c9c6cf1ad2a2b0c","container_config":{"Hostname":"4d2fcf04f32f","Domainname":"","User":"","AttachStdin":false,"AttachStdout":false,"AttachStderr":false,"Tty":false,"OpenStdin":false,"StdinOnce":false,"Env":["PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"],"Cmd":["/bin/sh","-c","#(nop) ","CMD [\"/bin/sh\"]"],"Image":"sha256:9a6e5b4bd5aa7e4a3c1f0e6fc2b32c4b8c0ad80c1e8e2ad3b0e3e19a27f8f6a1","Volumes":null,"WorkingDir":"","Entrypoint":null,"OnBuild":null,"Labels":{}},"created":"2022-08-09T17:19:53.47374331Z","docker_version":"20.10.12","history":[{"created":"2022-08-09T17:19:53.274069586Z","created_by":"/bin/sh -c #(nop) ADD file:2a949686d9886ac7c10582a6c29116fd29d3077d02755e87e111870d63607725 in / "},{"created":"2022-08-09T17:19:53.47374331Z","created_by":"/bin/sh -c #(nop)  CMD [\"/bin/sh\"]","empty_layer":true},{"created":"2022-08-10T08:01:02.5Z","created_by":"/bin/sh -c apk add --no-cache curl && rm -rf /var/cache/apk/*"}],"os":"linux","rootfs":{"type":"layers","diff_ids":["sha256:994393dc58e7931862558d06e46aa2bb17487044f670f310dffe1d24e4d1f3ae"]}}`
+
+// ociConfig is the config of an OCI image built by buildkit.
+const ociConfig = `{"architecture":"arm64","variant":"v8","config":{"Env":["PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"],"Entrypoint":["/app"],"WorkingDir":"/","Labels":{"org.opencontainers.image.source":"https://github.com/example/app?tab=readme&lang=en"},"OnBuild":null,"ArgsEscaped":true},"created":"2023-01-02T03:04:05Z","history":[{"created":"2023-01-02T03:04:05Z","created_by":"COPY app /app # buildkit","comment":"buildkit.dockerfile.v0"}],"moby.buildkit.buildinfo.v1":"eyJmcm9udGVuZCI6ImRvY2tlcmZpbGUudjAifQ==","os":"linux","rootfs":{"type":"layers","diff_ids":["sha256:5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef"]}}`
+
+func TestImageConfigRoundTrip(t *testing.T) {
+	for name, config := range map[string]string{
+		"docker": dockerConfig,
+		"oci":    ociConfig,
+	} {
+		var ic ImageConfig
+		if err := json.Unmarshal([]byte(config), &ic); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		data, err := MarshalJSON(ic)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if string(data) != config {
+			t.Errorf("%s: round trip changed the config:\ngot:  %s\nwant: %s", name, data, config)
+		}
+
+		var obj Object
+		if err := json.Unmarshal([]byte(config), &obj); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		data, err = MarshalJSON(obj)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if string(data) != config {
+			t.Errorf("%s: object round trip changed the config:\ngot:  %s\nwant: %s", name, data, config)
+		}
+	}
+}
+
+func TestImageConfigChange(t *testing.T) {
+	var ic ImageConfig
+	if err := json.Unmarshal([]byte(ociConfig), &ic); err != nil {
+		t.Fatal(err)
+	}
+	ic.Config.User = "nobody"
+	ic.Author = "a&b"
+	data, err := MarshalJSON(ic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"architecture":"arm64","variant":"v8","config":{"Env":["PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"],"Entrypoint":["/app"],"WorkingDir":"/","Labels":{"org.opencontainers.image.source":"https://github.com/example/app?tab=readme&lang=en"},"OnBuild":null,"ArgsEscaped":true,"User":"nobody"},"created":"2023-01-02T03:04:05Z","history":[{"created":"2023-01-02T03:04:05Z","created_by":"COPY app /app # buildkit","comment":"buildkit.dockerfile.v0"}],"moby.buildkit.buildinfo.v1":"eyJmcm9udGVuZCI6ImRvY2tlcmZpbGUudjAifQ==","os":"linux","rootfs":{"type":"layers","diff_ids":["sha256:5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef"]},"author":"a&b"}`
+	if string(data) != want {
+		t.Errorf("got:  %s\nwant: %s", data, want)
+	}
+}
+
+func TestObject(t *testing.T) {
+	var obj Object
+	if err := json.Unmarshal([]byte(`{"b":1,"a":{"x": 1},"c":"<&>"}`), &obj); err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.Set("a", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.Set("d", "&&"); err != nil {
+		t.Fatal(err)
+	}
+	if !obj.Delete("b") {
+		t.Error("Delete(b) = false, want true")
+	}
+	if obj.Delete("missing") {
+		t.Error("Delete(missing) = true, want false")
+	}
+	var c string
+	if err := obj.Get("c", &c); err != nil {
+		t.Fatal(err)
+	}
+	if c != "<&>" {
+		t.Errorf("Get(c) = %q, want %q", c, "<&>")
+	}
+
+	data, err := MarshalJSON(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"a":2,"c":"<&>","d":"&&"}`
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+
+	if err := json.Unmarshal([]byte(`[1]`), &obj); err == nil {
+		t.Error("expected an error for a JSON array")
+	}
+}
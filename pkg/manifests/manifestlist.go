@@ -1,16 +1,18 @@
 package manifests
 
 import (
+	"sort"
+
 	"github.com/dmage/boater/pkg/printer"
 )
 
 type PlatformSpec struct {
 	Architecture string   `json:"architecture"`
 	OS           string   `json:"os"`
-	OSVersion    string   `json:"os.version"`
-	OSFeatures   []string `json:"os.features"`
-	Variant      string   `json:"variant"`
-	Features     []string `json:"features"`
+	OSVersion    string   `json:"os.version,omitempty"`
+	OSFeatures   []string `json:"os.features,omitempty"`
+	Variant      string   `json:"variant,omitempty"`
+	Features     []string `json:"features,omitempty"`
 }
 
 func (ps PlatformSpec) Dump(prefix string) {
@@ -40,21 +42,52 @@ func (ps PlatformSpec) Dump(prefix string) {
 	}
 }
 
+func dumpAnnotations(prefix string, annotations map[string]string) {
+	if len(annotations) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	printer.Keyln(prefix, "annotations")
+	for _, key := range keys {
+		printer.KeyValueln(prefix+"  ", key, annotations[key])
+	}
+}
+
 type ManifestDescriptor struct {
 	Descriptor
-	Platform PlatformSpec `json:"platform"`
+	Platform *PlatformSpec `json:"platform,omitempty"`
 }
 
 func (md ManifestDescriptor) Dump(prefix string) {
 	printer.KeyValueln(prefix, "descriptor", md.Descriptor)
-	printer.Keyln(prefix, "platform")
-	md.Platform.Dump(prefix + "  ")
+	if md.Platform != nil {
+		printer.Keyln(prefix, "platform")
+		md.Platform.Dump(prefix + "  ")
+	}
+	dumpAnnotations(prefix, md.Annotations)
 }
 
 type ManifestList struct {
 	SchemaVersion int                  `json:"schemaVersion"`
 	MediaType     string               `json:"mediaType"`
 	Manifests     []ManifestDescriptor `json:"manifests"`
+	Annotations   map[string]string    `json:"annotations,omitempty"`
+
+	raw Object
+}
+
+type jsonManifestList ManifestList
+
+func (ml *ManifestList) UnmarshalJSON(data []byte) error {
+	return ml.raw.unmarshal(data, (*jsonManifestList)(ml))
+}
+
+func (ml ManifestList) MarshalJSON() ([]byte, error) {
+	return ml.raw.marshal(jsonManifestList(ml))
 }
 
 func (ml ManifestList) Dump(prefix string, repoName string) {
@@ -66,4 +99,5 @@ func (ml ManifestList) Dump(prefix string, repoName string) {
 		printer.Referencef("%s@%s\n", repoName, md.Digest)
 		md.Dump(prefix + "  ")
 	}
+	dumpAnnotations(prefix, ml.Annotations)
 }
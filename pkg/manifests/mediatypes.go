@@ -0,0 +1,69 @@
+package manifests
+
+const (
+	MediaTypeSchema1       = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeSchema1Signed = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	MediaTypeSchema2       = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeManifestList  = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest   = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex      = "application/vnd.oci.image.index.v1+json"
+
+	MediaTypeImageConfig    = "application/vnd.docker.container.image.v1+json"
+	MediaTypeOCIImageConfig = "application/vnd.oci.image.config.v1+json"
+	MediaTypeOCIEmpty       = "application/vnd.oci.empty.v1+json"
+
+	MediaTypeLayer               = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeForeignLayer        = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+	MediaTypeOCILayer            = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeOCILayerGzip        = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeOCILayerZstd        = "application/vnd.oci.image.layer.v1.tar+zstd"
+	MediaTypeOCIForeignLayer     = "application/vnd.oci.image.layer.nondistributable.v1.tar"
+	MediaTypeOCIForeignLayerGzip = "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip"
+	MediaTypeOCIForeignLayerZstd = "application/vnd.oci.image.layer.nondistributable.v1.tar+zstd"
+)
+
+// IsImageManifest returns true if mediaType is a manifest for a single image
+// that references a config blob.
+func IsImageManifest(mediaType string) bool {
+	return mediaType == MediaTypeSchema2 || mediaType == MediaTypeOCIManifest
+}
+
+// IsIndex returns true if mediaType is a manifest list or an OCI index.
+func IsIndex(mediaType string) bool {
+	return mediaType == MediaTypeManifestList || mediaType == MediaTypeOCIIndex
+}
+
+// IsSchema1 returns true if mediaType is a signed or unsigned schema 1
+// manifest.
+func IsSchema1(mediaType string) bool {
+	return mediaType == MediaTypeSchema1 || mediaType == MediaTypeSchema1Signed
+}
+
+var dockerToOCI = map[string]string{
+	MediaTypeSchema2:      MediaTypeOCIManifest,
+	MediaTypeManifestList: MediaTypeOCIIndex,
+	MediaTypeImageConfig:  MediaTypeOCIImageConfig,
+	MediaTypeLayer:        MediaTypeOCILayerGzip,
+	MediaTypeForeignLayer: MediaTypeOCIForeignLayerGzip,
+}
+
+// OCIMediaType returns the OCI counterpart of the Docker media type. Other
+// media types are returned as is.
+func OCIMediaType(mediaType string) string {
+	if t, ok := dockerToOCI[mediaType]; ok {
+		return t
+	}
+	return mediaType
+}
+
+// DockerMediaType returns the Docker counterpart of the OCI media type. Docker
+// media types are returned as is. If the media type cannot be represented in
+// Docker manifests, ok is false.
+func DockerMediaType(mediaType string) (t string, ok bool) {
+	for docker, oci := range dockerToOCI {
+		if mediaType == docker || mediaType == oci {
+			return docker, true
+		}
+	}
+	return "", false
+}
@@ -1,6 +1,11 @@
 package manifests
 
 import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/docker/libtrust"
+
 	"github.com/dmage/boater/pkg/printer"
 )
 
@@ -20,12 +25,90 @@ func (h Schema1History) Dump(prefix string) {
 	printer.KeyValueln(prefix, "v1Compatibility", h.V1Compatibility)
 }
 
+// Schema1Signature is a JWS signature of a signed schema 1 manifest and the
+// result of its verification.
+type Schema1Signature struct {
+	KeyID     string
+	Algorithm string
+	Err       error
+}
+
+func (s Schema1Signature) Dump(prefix string, secondPrefix string) {
+	printer.KeyValueln(prefix, "keyID", s.KeyID)
+	prefix = secondPrefix
+	printer.KeyValueln(prefix, "algorithm", s.Algorithm)
+	if s.Err != nil {
+		printer.KeyValueln(prefix, "verified", fmt.Sprintf("false (%s)", s.Err))
+	} else {
+		printer.KeyValueln(prefix, "verified", true)
+	}
+}
+
+// VerifySchema1 parses the JWS signatures of the signed schema 1 manifest data
+// and verifies each of them. An error is returned only if the signatures
+// cannot be parsed.
+func VerifySchema1(data []byte) ([]Schema1Signature, error) {
+	js, err := libtrust.ParsePrettySignature(data, "signatures")
+	if err != nil {
+		return nil, fmt.Errorf("parse JWS: %w", err)
+	}
+	payload, err := js.Payload()
+	if err != nil {
+		return nil, fmt.Errorf("parse JWS: %w", err)
+	}
+	blobs, err := js.Signatures()
+	if err != nil {
+		return nil, fmt.Errorf("parse JWS: %w", err)
+	}
+
+	var signatures []Schema1Signature
+	for _, blob := range blobs {
+		var header struct {
+			Header struct {
+				JWK       json.RawMessage `json:"jwk"`
+				Algorithm string          `json:"alg"`
+			} `json:"header"`
+		}
+		if err := json.Unmarshal(blob, &header); err != nil {
+			return nil, fmt.Errorf("parse JWS signature: %w", err)
+		}
+
+		sig := Schema1Signature{
+			Algorithm: header.Header.Algorithm,
+		}
+		if len(header.Header.JWK) > 0 {
+			if key, err := libtrust.UnmarshalPublicKeyJWK(header.Header.JWK); err == nil {
+				sig.KeyID = key.KeyID()
+			}
+		}
+
+		single, err := libtrust.NewJSONSignature(payload, blob)
+		if err != nil {
+			sig.Err = err
+		} else if keys, err := single.Verify(); err != nil {
+			sig.Err = err
+		} else {
+			sig.KeyID = keys[0].KeyID()
+		}
+		signatures = append(signatures, sig)
+	}
+	return signatures, nil
+}
+
 type Schema1 struct {
 	Name         string           `json:"name"`
 	Tag          string           `json:"tag"`
 	Architecture string           `json:"architecture"`
 	FSLayers     []FSLayer        `json:"fsLayers"`
 	History      []Schema1History `json:"history"`
+
+	// Signatures are not decoded from JSON, they are set by the caller using
+	// VerifySchema1.
+	Signatures []Schema1Signature `json:"-"`
+
+	// SignaturesErr is the error from VerifySchema1 if the signatures cannot
+	// be parsed.
+	SignaturesErr error `json:"-"`
 }
 
 func (s Schema1) Dump(prefix string) {
@@ -50,4 +133,12 @@ func (s Schema1) Dump(prefix string) {
 			history.Dump(prefix + "- ")
 		}
 	}
+	if s.SignaturesErr != nil {
+		printer.KeyValueln(prefix, "signatures", fmt.Sprintf("invalid (%s)", s.SignaturesErr))
+	} else if len(s.Signatures) > 0 {
+		printer.Keyln(prefix, "signatures")
+		for _, sig := range s.Signatures {
+			sig.Dump(prefix+"- ", prefix+"  ")
+		}
+	}
 }
@@ -14,7 +14,7 @@ func (cd ConfigDescriptor) Dump(prefix string) {
 
 type LayerDescriptor struct {
 	Descriptor
-	URLs []string `json:"urls"`
+	URLs []string `json:"urls,omitempty"`
 }
 
 func (ld LayerDescriptor) Dump(prefix string, secondPrefix string) {
@@ -26,23 +26,36 @@ func (ld LayerDescriptor) Dump(prefix string, secondPrefix string) {
 			printer.Valueln(prefix+"- ", url)
 		}
 	}
+	dumpAnnotations(prefix, ld.Annotations)
 }
 
 type Schema2 struct {
 	SchemaVersion int               `json:"schemaVersion"`
 	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
 	Config        ConfigDescriptor  `json:"config"`
 	Layers        []LayerDescriptor `json:"layers"`
+	Subject       *Descriptor       `json:"subject,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
 }
 
 func (s Schema2) Dump(prefix string, config ImageConfig) {
 	printer.KeyValueln(prefix, "schemaVersion", s.SchemaVersion)
 	printer.KeyValueln(prefix, "mediaType", s.MediaType)
+	if s.ArtifactType != "" {
+		printer.KeyValueln(prefix, "artifactType", s.ArtifactType)
+	}
 	printer.Keyln(prefix, "config")
 	s.Config.Dump(prefix + "  ")
-	config.Dump(prefix + "  ")
+	if s.Config.MediaType == MediaTypeImageConfig || s.Config.MediaType == MediaTypeOCIImageConfig {
+		config.Dump(prefix + "  ")
+	}
 	printer.Keyln(prefix, "layers")
 	for _, layer := range s.Layers {
 		layer.Dump(prefix+"- ", prefix+"  ")
 	}
+	if s.Subject != nil {
+		printer.KeyValueln(prefix, "subject", *s.Subject)
+	}
+	dumpAnnotations(prefix, s.Annotations)
 }
@@ -0,0 +1,184 @@
+// Package netrc reads credentials from .netrc files.
+package netrc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Machine is an entry of a .netrc file. The default entry has an empty
+// Name.
+type Machine struct {
+	Name     string
+	Login    string
+	Password string
+}
+
+// DefaultPath returns the location of the .netrc file: $NETRC or
+// $HOME/.netrc.
+func DefaultPath() (string, error) {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".netrc"), nil
+}
+
+// Load reads the entries from the file path.
+func Load(path string) ([]Machine, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	machines, err := Parse(string(buf))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return machines, nil
+}
+
+// token is a word of a .netrc file.
+type token struct {
+	text   string
+	quoted bool
+}
+
+// splitTokens splits the line into tokens separated by whitespace. Tokens can
+// be quoted with double quotes, so that they can contain spaces; a backslash
+// in a quoted token escapes the next character.
+func splitTokens(line string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(line); {
+		switch line[i] {
+		case ' ', '\t', '\r':
+			i++
+			continue
+		case '"':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(line) {
+					return nil, fmt.Errorf("unterminated quoted string")
+				}
+				c := line[i]
+				i++
+				if c == '"' {
+					break
+				}
+				if c == '\\' && i < len(line) {
+					c = line[i]
+					i++
+					switch c {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					}
+				}
+				b.WriteByte(c)
+			}
+			tokens = append(tokens, token{text: b.String(), quoted: true})
+		default:
+			start := i
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' && line[i] != '\r' {
+				i++
+			}
+			tokens = append(tokens, token{text: line[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+// Parse parses the contents of a .netrc file. Values that contain spaces can
+// be quoted (password "a b"). Macro definitions are skipped.
+func Parse(data string) ([]Machine, error) {
+	var machines []Machine
+	var current *Machine
+
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		tokens, err := splitTokens(lines[i])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		for k := 0; k < len(tokens); k++ {
+			keyword := tokens[k].text
+			if tokens[k].quoted {
+				return nil, fmt.Errorf("line %d: unexpected %q", i+1, keyword)
+			}
+			if strings.HasPrefix(keyword, "#") {
+				// A comment starts with a token, so values such as
+				// passwords may contain #.
+				break
+			}
+			value := func() (string, error) {
+				if k+1 >= len(tokens) {
+					return "", fmt.Errorf("line %d: %s without a value", i+1, keyword)
+				}
+				k++
+				return tokens[k].text, nil
+			}
+			switch keyword {
+			case "machine":
+				name, err := value()
+				if err != nil {
+					return nil, err
+				}
+				machines = append(machines, Machine{Name: name})
+				current = &machines[len(machines)-1]
+			case "default":
+				machines = append(machines, Machine{})
+				current = &machines[len(machines)-1]
+			case "login", "password", "account":
+				v, err := value()
+				if err != nil {
+					return nil, err
+				}
+				if current == nil {
+					return nil, fmt.Errorf("line %d: %s outside of a machine entry", i+1, keyword)
+				}
+				switch keyword {
+				case "login":
+					current.Login = v
+				case "password":
+					current.Password = v
+				}
+			case "macdef":
+				// The macro ends with an empty line.
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				k = len(tokens)
+			default:
+				return nil, fmt.Errorf("line %d: unexpected %q", i+1, keyword)
+			}
+		}
+	}
+	return machines, nil
+}
+
+// Lookup returns the entry for host (host[:port]). An entry for the host
+// with the port is preferred to an entry for the hostname. The default entry
+// is not used, so that its password is not sent to every registry.
+func Lookup(machines []Machine, host string) (Machine, bool) {
+	hostname := host
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.HasSuffix(host, "]") {
+		hostname = host[:i]
+	}
+	for _, name := range []string{host, hostname} {
+		for _, m := range machines {
+			if m.Name != "" && m.Name == name {
+				return m, true
+			}
+		}
+	}
+	return Machine{}, false
+}
@@ -0,0 +1,132 @@
+package netrc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   string
+		want []Machine
+	}{
+		{
+			name: "one line per entry",
+			in:   "machine registry.example.com login user password secret\nmachine other.example.com login other password pw\n",
+			want: []Machine{
+				{Name: "registry.example.com", Login: "user", Password: "secret"},
+				{Name: "other.example.com", Login: "other", Password: "pw"},
+			},
+		},
+		{
+			name: "multiple lines and comments",
+			in: `# registries
+machine registry.example.com
+	login user # the robot account
+	password p#ss
+	account ignored
+
+# the default entry
+default login anonymous password guest@
+`,
+			want: []Machine{
+				{Name: "registry.example.com", Login: "user", Password: "p#ss"},
+				{Login: "anonymous", Password: "guest@"},
+			},
+		},
+		{
+			name: "quoted values",
+			in:   `machine "registry.example.com" login "my user" password "a b \"c\" \\ d"` + "\r\n",
+			want: []Machine{
+				{Name: "registry.example.com", Login: "my user", Password: `a b "c" \ d`},
+			},
+		},
+		{
+			name: "hash at the start of a value",
+			in:   "machine registry.example.com login user password #secret\n",
+			want: []Machine{
+				{Name: "registry.example.com", Login: "user", Password: "#secret"},
+			},
+		},
+		{
+			name: "macdef",
+			in: `machine ftp.example.com login ftp password ftp
+macdef init
+cd /pub
+machine inside.macro login no password no
+
+machine registry.example.com login user password secret
+`,
+			want: []Machine{
+				{Name: "ftp.example.com", Login: "ftp", Password: "ftp"},
+				{Name: "registry.example.com", Login: "user", Password: "secret"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		got, err := Parse(tc.in)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := []struct {
+		in      string
+		wantErr string
+	}{
+		{in: "machine", wantErr: "line 1: machine without a value"},
+		{in: "machine a login", wantErr: "line 1: login without a value"},
+		{in: "login user", wantErr: "line 1: login outside of a machine entry"},
+		{in: "machine a\nport 22", wantErr: `line 2: unexpected "port"`},
+		{in: `machine a password "open`, wantErr: "line 1: unterminated quoted string"},
+		{in: `machine a "login" b`, wantErr: `line 1: unexpected "login"`},
+	}
+	for _, tc := range testCases {
+		_, err := Parse(tc.in)
+		if err == nil {
+			t.Errorf("Parse(%q): expected error %q", tc.in, tc.wantErr)
+			continue
+		}
+		if err.Error() != tc.wantErr {
+			t.Errorf("Parse(%q): got error %q, want %q", tc.in, err, tc.wantErr)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	machines, err := Parse(`
+default login anonymous password guest
+machine registry.example.com login plain password 1
+machine registry.example.com:5000 login port password 2
+machine [::1]:5000 login ipv6 password 3
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		host      string
+		wantLogin string
+		wantOK    bool
+	}{
+		{host: "registry.example.com", wantLogin: "plain", wantOK: true},
+		{host: "registry.example.com:5000", wantLogin: "port", wantOK: true},
+		{host: "registry.example.com:443", wantLogin: "plain", wantOK: true},
+		{host: "[::1]:5000", wantLogin: "ipv6", wantOK: true},
+		{host: "other.example.com", wantOK: false},
+		{host: "", wantOK: false},
+	}
+	for _, tc := range testCases {
+		m, ok := Lookup(machines, tc.host)
+		if ok != tc.wantOK || m.Login != tc.wantLogin {
+			t.Errorf("Lookup(%q) = %+v, %t, want login %q, %t", tc.host, m, ok, tc.wantLogin, tc.wantOK)
+		}
+	}
+}
@@ -42,3 +42,23 @@ func KeyValueln(prefix string, key string, value interface{}) {
 	Value(value)
 	fmt.Print("\n")
 }
+
+func Added(value interface{}) {
+	color.New(color.FgGreen).Print(value)
+}
+
+func Removed(value interface{}) {
+	color.New(color.FgRed).Print(value)
+}
+
+func Addedln(prefix string, value interface{}) {
+	Delim(prefix)
+	Added(value)
+	fmt.Print("\n")
+}
+
+func Removedln(prefix string, value interface{}) {
+	Delim(prefix)
+	Removed(value)
+	fmt.Print("\n")
+}
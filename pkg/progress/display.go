@@ -0,0 +1,289 @@
+package progress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const barWidth = 20
+
+type transfer struct {
+	Event
+	start   time.Time
+	visible bool
+}
+
+func (t *transfer) rate(now time.Time) float64 {
+	elapsed := now.Sub(t.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(t.Current) / elapsed
+}
+
+// status describes the amount of transferred data, the rate and the
+// estimated time until the transfer is finished.
+func (t *transfer) status(now time.Time) string {
+	rate := t.rate(now)
+	if t.Total < 0 {
+		return fmt.Sprintf("%s, %s/s", FormatSize(t.Current), FormatSize(int64(rate)))
+	}
+	s := fmt.Sprintf("%s / %s, %s/s", FormatSize(t.Current), FormatSize(t.Total), FormatSize(int64(rate)))
+	if rate > 0 && t.Current < t.Total {
+		eta := time.Duration(float64(t.Total-t.Current) / rate * float64(time.Second))
+		s += ", ETA " + formatDuration(eta)
+	}
+	return s
+}
+
+// summary describes a finished transfer.
+func (t *transfer) summary(now time.Time) string {
+	if t.Err != nil {
+		return fmt.Sprintf("%s after %s: %s", t.Action, FormatSize(t.Current), t.Err)
+	}
+	return fmt.Sprintf("%s complete, %s in %s (%s/s)", t.Action, FormatSize(t.Current), formatDuration(now.Sub(t.start)), FormatSize(int64(t.rate(now))))
+}
+
+func (t *transfer) percent() int {
+	if t.Total <= 0 {
+		return 100
+	}
+	return int(t.Current * 100 / t.Total)
+}
+
+// tracker keeps the state of active transfers. Transfers are not shown
+// until they have been active for delay, so that small blobs do not clutter
+// the output.
+type tracker struct {
+	mu        sync.Mutex
+	w         io.Writer
+	delay     time.Duration
+	transfers []*transfer
+	finished  []*transfer
+}
+
+// update applies the event e. The lock must be held.
+func (t *tracker) update(e Event, now time.Time) {
+	for i, tr := range t.transfers {
+		if tr.ID != e.ID || tr.Action != e.Action {
+			continue
+		}
+		tr.Event = e
+		if e.Done {
+			t.transfers = append(t.transfers[:i], t.transfers[i+1:]...)
+			if tr.visible {
+				t.finished = append(t.finished, tr)
+			}
+		}
+		return
+	}
+	if !e.Done {
+		t.transfers = append(t.transfers, &transfer{
+			Event: e,
+			start: now,
+		})
+	}
+}
+
+// Bar draws a progress bar for each active transfer. It redraws the bars in
+// place, so it should be used only for terminals.
+type Bar struct {
+	tracker
+	interval time.Duration
+	lines    int
+	ticker   *time.Ticker
+	stop     chan struct{}
+}
+
+// NewBar returns a progress bar display that writes to w.
+func NewBar(w io.Writer) *Bar {
+	return &Bar{
+		tracker: tracker{
+			w:     w,
+			delay: 500 * time.Millisecond,
+		},
+		interval: 200 * time.Millisecond,
+	}
+}
+
+// Update applies the event e. It can be used as Func.
+func (b *Bar) Update(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.update(e, time.Now())
+	if e.Done {
+		b.draw(time.Now())
+	}
+	if b.ticker == nil {
+		b.ticker = time.NewTicker(b.interval)
+		b.stop = make(chan struct{})
+		go b.run(b.ticker, b.stop)
+	}
+}
+
+func (b *Bar) run(ticker *time.Ticker, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			b.mu.Lock()
+			b.draw(now)
+			b.mu.Unlock()
+		}
+	}
+}
+
+// Stop draws the final state of the transfers and stops redrawing.
+func (b *Bar) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.ticker != nil {
+		b.ticker.Stop()
+		close(b.stop)
+		b.ticker = nil
+	}
+	b.draw(time.Now())
+}
+
+// draw replaces the previously drawn bars with the summaries of finished
+// transfers and the bars of active transfers. The lock must be held.
+func (b *Bar) draw(now time.Time) {
+	var buf bytes.Buffer
+	if b.lines > 0 {
+		fmt.Fprintf(&buf, "\x1b[%dA", b.lines)
+	}
+	for _, tr := range b.finished {
+		fmt.Fprintf(&buf, "\r\x1b[K%s %s\n", shortID(tr.ID), tr.summary(now))
+	}
+	written := len(b.finished)
+	b.finished = nil
+
+	lines := 0
+	for _, tr := range b.transfers {
+		if !tr.visible && now.Sub(tr.start) < b.delay {
+			continue
+		}
+		tr.visible = true
+		fmt.Fprintf(&buf, "\r\x1b[K%s %-8s %s %s\n", shortID(tr.ID), tr.Action, bar(tr), tr.status(now))
+		lines++
+	}
+	if buf.Len() == 0 {
+		return
+	}
+	if written+lines < b.lines {
+		// Clear the bars that are not redrawn.
+		buf.WriteString("\x1b[J")
+	}
+	b.lines = lines
+	b.w.Write(buf.Bytes())
+}
+
+func bar(t *transfer) string {
+	if t.Total < 0 {
+		return "[" + strings.Repeat("?", barWidth) + "]    "
+	}
+	filled := barWidth * t.percent() / 100
+	if filled > barWidth {
+		filled = barWidth
+	}
+	s := strings.Repeat("=", filled)
+	if filled < barWidth {
+		s += ">" + strings.Repeat(" ", barWidth-filled-1)
+	}
+	return fmt.Sprintf("[%s] %3d%%", s, t.percent())
+}
+
+// Lines periodically writes a line for each active transfer. Unlike Bar, it
+// never rewrites the output, so it is suitable for logs.
+type Lines struct {
+	tracker
+	interval time.Duration
+	ticker   *time.Ticker
+	stop     chan struct{}
+}
+
+// NewLines returns a display that writes the state of the transfers to w
+// every interval.
+func NewLines(w io.Writer, interval time.Duration) *Lines {
+	return &Lines{
+		tracker: tracker{
+			w: w,
+			// The ticker may fire slightly earlier than a full interval after
+			// a transfer starts.
+			delay: interval / 2,
+		},
+		interval: interval,
+	}
+}
+
+// Update applies the event e. It can be used as Func.
+func (l *Lines) Update(e Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.update(e, now)
+	l.printFinished(now)
+	if l.ticker == nil {
+		l.ticker = time.NewTicker(l.interval)
+		l.stop = make(chan struct{})
+		go l.run(l.ticker, l.stop)
+	}
+}
+
+func (l *Lines) run(ticker *time.Ticker, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			l.print(now)
+			l.mu.Unlock()
+		}
+	}
+}
+
+// Stop stops writing the state of the transfers.
+func (l *Lines) Stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.ticker != nil {
+		l.ticker.Stop()
+		close(l.stop)
+		l.ticker = nil
+	}
+}
+
+// printFinished writes summaries for the finished transfers that have been
+// reported before. The lock must be held.
+func (l *Lines) printFinished(now time.Time) {
+	for _, tr := range l.finished {
+		fmt.Fprintf(l.w, "%s: %s\n", tr.ID, tr.summary(now))
+	}
+	l.finished = nil
+}
+
+// print writes a line for each transfer that has been active long enough.
+// The lock must be held.
+func (l *Lines) print(now time.Time) {
+	for _, tr := range l.transfers {
+		if now.Sub(tr.start) < l.delay {
+			continue
+		}
+		tr.visible = true
+		if tr.Total < 0 {
+			fmt.Fprintf(l.w, "%s: %s %s\n", tr.ID, tr.Action, tr.status(now))
+		} else {
+			fmt.Fprintf(l.w, "%s: %s %d%%, %s\n", tr.ID, tr.Action, tr.percent(), tr.status(now))
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Package progress reports the progress of blob transfers.
+package progress
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Actions of transfers.
+const (
+	Download = "download"
+	Upload   = "upload"
+)
+
+// ErrAborted is reported for transfers that were closed before all data was
+// transferred.
+var ErrAborted = errors.New("aborted")
+
+// Event describes the state of a transfer.
+type Event struct {
+	ID      string // the digest of the blob
+	Action  string // Download or Upload
+	Current int64  // the number of transferred bytes
+	Total   int64  // the size of the blob, or -1 if it is unknown
+	Done    bool   // the transfer is finished
+	Err     error  // the reason why the transfer failed
+}
+
+// Func is called when the state of a transfer changes. It may be called
+// concurrently for different transfers.
+type Func func(Event)
+
+type reader struct {
+	rc    io.ReadCloser
+	fn    Func
+	event Event
+}
+
+// NewReader returns a reader that reports the progress of reading rc to fn.
+// The transfer is finished when rc returns an error or when the reader is
+// closed.
+func NewReader(rc io.ReadCloser, fn Func, event Event) io.ReadCloser {
+	event.Current = 0
+	event.Done = false
+	event.Err = nil
+	fn(event)
+	return &reader{
+		rc:    rc,
+		fn:    fn,
+		event: event,
+	}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	if r.event.Done {
+		return n, err
+	}
+	r.event.Current += int64(n)
+	if err != nil {
+		r.event.Done = true
+		if err != io.EOF {
+			r.event.Err = err
+		}
+	}
+	if n > 0 || err != nil {
+		r.fn(r.event)
+	}
+	return n, err
+}
+
+func (r *reader) Close() error {
+	err := r.rc.Close()
+	if !r.event.Done {
+		r.event.Done = true
+		if r.event.Total < 0 || r.event.Current < r.event.Total {
+			r.event.Err = ErrAborted
+		}
+		r.fn(r.event)
+	}
+	return err
+}
+
+// FormatSize returns a short human-readable representation of the number of
+// bytes b.
+func FormatSize(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	value := float64(b) / unit
+	suffixes := []string{"KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for value >= unit && i < len(suffixes)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", value, suffixes[i])
+}
+
+func formatDuration(d time.Duration) string {
+	s := int64(d.Round(time.Second) / time.Second)
+	switch {
+	case s < 60:
+		return fmt.Sprintf("%ds", s)
+	case s < 3600:
+		return fmt.Sprintf("%dm%02ds", s/60, s%60)
+	}
+	return fmt.Sprintf("%dh%02dm", s/3600, s%3600/60)
+}
+
+func shortID(id string) string {
+	for i := 0; i < len(id); i++ {
+		if id[i] == ':' {
+			id = id[i+1:]
+			break
+		}
+	}
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return id
+}
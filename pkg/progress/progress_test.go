@@ -0,0 +1,174 @@
+package progress
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		err = r.err
+	}
+	return n, err
+}
+
+func TestReader(t *testing.T) {
+	readErr := errors.New("connection reset")
+	testCases := []struct {
+		name    string
+		r       io.Reader
+		total   int64
+		read    bool
+		wantErr error
+	}{
+		{name: "complete", r: strings.NewReader("data"), total: 4, read: true},
+		{name: "unknown size", r: strings.NewReader("data"), total: -1, read: true},
+		{name: "read error", r: errReader{strings.NewReader("da"), readErr}, total: 4, read: true, wantErr: readErr},
+		{name: "closed early", r: strings.NewReader("data"), total: 4, wantErr: ErrAborted},
+	}
+	for _, tc := range testCases {
+		var events []Event
+		fn := func(e Event) {
+			events = append(events, e)
+		}
+		r := NewReader(ioutil.NopCloser(tc.r), fn, Event{ID: "sha256:abc", Action: Download, Total: tc.total})
+		if tc.read {
+			io.Copy(ioutil.Discard, r)
+		}
+		r.Close()
+		r.Close()
+
+		if len(events) == 0 || events[0].Done || events[0].Current != 0 {
+			t.Errorf("%s: the first event is %+v, want a started transfer", tc.name, events)
+			continue
+		}
+		var done []Event
+		for _, e := range events {
+			if e.Done {
+				done = append(done, e)
+			}
+		}
+		if len(done) != 1 {
+			t.Errorf("%s: got %d finished events, want 1", tc.name, len(done))
+			continue
+		}
+		if last := events[len(events)-1]; !last.Done || last.Err != tc.wantErr {
+			t.Errorf("%s: the last event is %+v, want done with error %v", tc.name, last, tc.wantErr)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	testCases := []struct {
+		in   int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 40, "3.0 TiB"},
+		{2048 << 40, "2048.0 TiB"},
+	}
+	for _, tc := range testCases {
+		if got := FormatSize(tc.in); got != tc.want {
+			t.Errorf("FormatSize(%d) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	testCases := []struct {
+		in   time.Duration
+		want string
+	}{
+		{1400 * time.Millisecond, "1s"},
+		{59 * time.Second, "59s"},
+		{61 * time.Second, "1m01s"},
+		{2*time.Hour + 3*time.Minute + 4*time.Second, "2h03m"},
+	}
+	for _, tc := range testCases {
+		if got := formatDuration(tc.in); got != tc.want {
+			t.Errorf("formatDuration(%s) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestLines(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLines(&buf, time.Second)
+	defer l.Stop()
+
+	start := time.Now()
+	l.mu.Lock()
+	l.update(Event{ID: "sha256:abc", Action: Download, Current: 0, Total: 100}, start)
+	l.update(Event{ID: "sha256:def", Action: Upload, Current: 0, Total: -1}, start)
+	l.update(Event{ID: "sha256:abc", Action: Download, Current: 50, Total: 100}, start)
+	l.print(start.Add(200 * time.Millisecond))
+	if buf.Len() != 0 {
+		t.Errorf("transfers shorter than the delay are printed: %q", buf.String())
+	}
+
+	l.print(start.Add(10 * time.Second))
+	want := "sha256:abc: download 50%, 50 B / 100 B, 5 B/s, ETA 10s\n" +
+		"sha256:def: upload 0 B, 0 B/s\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	buf.Reset()
+
+	l.update(Event{ID: "sha256:abc", Action: Download, Current: 100, Total: 100, Done: true}, start)
+	l.printFinished(start.Add(20 * time.Second))
+	want = "sha256:abc: download complete, 100 B in 20s (5 B/s)\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	l.mu.Unlock()
+}
+
+func TestBar(t *testing.T) {
+	var buf bytes.Buffer
+	b := NewBar(&buf)
+
+	b.Update(Event{ID: "sha256:0123456789abcdef", Action: Download, Total: 100})
+	b.mu.Lock()
+	b.transfers[0].start = time.Now().Add(-time.Minute)
+	b.mu.Unlock()
+	b.Update(Event{ID: "sha256:0123456789abcdef", Action: Download, Current: 50, Total: 100})
+
+	b.mu.Lock()
+	b.draw(time.Now())
+	b.mu.Unlock()
+	if got := buf.String(); !strings.Contains(got, "0123456789ab download [==========>         ]  50%") {
+		t.Errorf("the bar is not drawn: %q", got)
+	}
+	buf.Reset()
+
+	b.Update(Event{ID: "sha256:0123456789abcdef", Action: Download, Current: 100, Total: 100, Done: true})
+	b.Stop()
+	got := buf.String()
+	if !strings.HasPrefix(got, "\x1b[1A") || !strings.Contains(got, "0123456789ab download complete, 100 B") {
+		t.Errorf("the bar is not replaced with the summary: %q", got)
+	}
+	if b.ticker != nil {
+		t.Errorf("Stop did not stop the ticker")
+	}
+
+	buf.Reset()
+	b.Stop()
+	if buf.Len() != 0 {
+		t.Errorf("Stop without changes redraws the bars: %q", buf.String())
+	}
+}
@@ -0,0 +1,148 @@
+// Package pullsecret reads registry credentials from Kubernetes image pull
+// secrets.
+package pullsecret
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/credentialprovider"
+)
+
+// Types of Secrets with registry credentials and their keys.
+const (
+	TypeDockerConfigJSON = "kubernetes.io/dockerconfigjson"
+	TypeDockercfg        = "kubernetes.io/dockercfg"
+
+	KeyDockerConfigJSON = ".dockerconfigjson"
+	KeyDockercfg        = ".dockercfg"
+)
+
+// Load reads the credentials from the Secret manifests in the YAML or JSON
+// file path.
+func Load(path string) ([]credentialprovider.DockerConfig, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	configs, err := Parse(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return configs, nil
+}
+
+// Parse returns the credentials from the Secret manifests in data. The
+// manifests can be JSON or YAML documents separated by ---. Lists of objects
+// (kind: List) are supported as well.
+func Parse(data []byte) ([]credentialprovider.DockerConfig, error) {
+	var objects []interface{}
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		var obj map[string]interface{}
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil, err
+		}
+		objects = []interface{}{obj}
+	} else {
+		docs, err := parseYAMLDocuments(string(data))
+		if err != nil {
+			return nil, err
+		}
+		objects = docs
+	}
+
+	var configs []credentialprovider.DockerConfig
+	for len(objects) > 0 {
+		obj, ok := objects[0].(map[string]interface{})
+		objects = objects[1:]
+		if !ok {
+			return nil, fmt.Errorf("expected a Kubernetes object")
+		}
+
+		kind, _ := obj["kind"].(string)
+		switch kind {
+		case "List":
+			items, _ := obj["items"].([]interface{})
+			objects = append(items, objects...)
+			continue
+		case "Secret":
+		default:
+			return nil, fmt.Errorf("expected a Secret, got kind %q", kind)
+		}
+
+		config, err := parseSecret(obj)
+		if err != nil {
+			if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+				if name, ok := metadata["name"].(string); ok {
+					err = fmt.Errorf("secret %s: %w", name, err)
+				}
+			}
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no Secrets found")
+	}
+	return configs, nil
+}
+
+// secretData returns the value for key from stringData or data.
+func secretData(obj map[string]interface{}, key string) ([]byte, bool, error) {
+	if stringData, ok := obj["stringData"].(map[string]interface{}); ok {
+		if value, ok := stringData[key].(string); ok {
+			return []byte(value), true, nil
+		}
+	}
+	if data, ok := obj["data"].(map[string]interface{}); ok {
+		if value, ok := data[key].(string); ok {
+			buf, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), ""))
+			if err != nil {
+				return nil, true, fmt.Errorf("data.%s: %w", key, err)
+			}
+			return buf, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func parseSecret(obj map[string]interface{}) (credentialprovider.DockerConfig, error) {
+	secretType, _ := obj["type"].(string)
+
+	if secretType == TypeDockerConfigJSON || secretType == "" {
+		buf, ok, err := secretData(obj, KeyDockerConfigJSON)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			var config credentialprovider.DockerConfigJSON
+			if err := json.Unmarshal(buf, &config); err != nil {
+				return nil, fmt.Errorf("%s: %w", KeyDockerConfigJSON, err)
+			}
+			return config.Auths, nil
+		}
+		if secretType != "" {
+			return nil, fmt.Errorf("no %s key", KeyDockerConfigJSON)
+		}
+	}
+
+	if secretType == TypeDockercfg || secretType == "" {
+		buf, ok, err := secretData(obj, KeyDockercfg)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			var config credentialprovider.DockerConfig
+			if err := json.Unmarshal(buf, &config); err != nil {
+				return nil, fmt.Errorf("%s: %w", KeyDockercfg, err)
+			}
+			return config, nil
+		}
+		return nil, fmt.Errorf("no %s or %s key", KeyDockerConfigJSON, KeyDockercfg)
+	}
+
+	return nil, fmt.Errorf("unsupported type %s, expected %s or %s", secretType, TypeDockerConfigJSON, TypeDockercfg)
+}
@@ -0,0 +1,203 @@
+package pullsecret
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/credentialprovider"
+)
+
+// dockerConfigJSONSecret is the output of kubectl get secret -o yaml for a
+// secret that was created by kubectl create secret docker-registry.
+const dockerConfigJSONSecret = `apiVersion: v1
+data:
+  .dockerconfigjson: eyJhdXRocyI6eyJyZWdpc3RyeS5leGFtcGxlLmNvbSI6eyJ1c2VybmFtZSI6InJvYm90JGNpIiwicGFzc3dvcmQiOiJzM2NyM3Q6cHciLCJhdXRoIjoiY205aWIzUWtZMms2Y3pOamNqTjBPbkIzIn19fQ==
+kind: Secret
+metadata:
+  creationTimestamp: "2022-09-01T10:00:00Z"
+  managedFields:
+  - apiVersion: v1
+    fieldsType: FieldsV1
+    fieldsV1:
+      f:data:
+        .: {}
+        f:.dockerconfigjson: {}
+      f:type: {}
+    manager: kubectl-create
+    operation: Update
+    time: "2022-09-01T10:00:00Z"
+  name: my-pull-secret
+  namespace: default
+  resourceVersion: "123456"
+  uid: 3c5e8b8e-4f0a-4a4e-9d44-2b1c2f6a7d10
+type: kubernetes.io/dockerconfigjson
+`
+
+// dockercfgSecret is the output of kubectl get secret -o yaml for a secret of
+// the legacy type, which was created by kubectl apply.
+const dockercfgSecret = `apiVersion: v1
+data:
+  .dockercfg: eyJodHRwczovL2luZGV4LmRvY2tlci5pby92MS8iOnsiYXV0aCI6IlpHMWhaMlU2YUhWdWRHVnlNZz09IiwiZW1haWwiOiJtZUBleGFtcGxlLmNvbSJ9fQ==
+kind: Secret
+metadata:
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: |
+      {"apiVersion":"v1","data":{".dockercfg":"eyJodHRwczovL2luZGV4LmRvY2tlci5pby92MS8iOnsiYXV0aCI6IlpHMWhaMlU2YUhWdWRHVnlNZz09IiwiZW1haWwiOiJtZUBleGFtcGxlLmNvbSJ9fQ=="},"kind":"Secret","metadata":{"annotations":{},"name":"legacy","namespace":"default"},"type":"kubernetes.io/dockercfg"}
+  creationTimestamp: "2022-09-01T10:00:00Z"
+  name: legacy
+  namespace: default
+  resourceVersion: "654321"
+  uid: 9a0d5a43-8f1c-4a43-b0c4-2d0cbb0d3f55
+type: kubernetes.io/dockercfg
+`
+
+var (
+	dockerConfigJSONWant = credentialprovider.DockerConfig{
+		"registry.example.com": {Username: "robot$ci", Password: "s3cr3t:pw"},
+	}
+	dockercfgWant = credentialprovider.DockerConfig{
+		"https://index.docker.io/v1/": {Username: "dmage", Password: "hunter2", Email: "me@example.com"},
+	}
+)
+
+func indent(s string, prefix string) string {
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   string
+		want []credentialprovider.DockerConfig
+	}{
+		{
+			name: "dockerconfigjson",
+			in:   dockerConfigJSONSecret,
+			want: []credentialprovider.DockerConfig{dockerConfigJSONWant},
+		},
+		{
+			name: "dockercfg",
+			in:   dockercfgSecret,
+			want: []credentialprovider.DockerConfig{dockercfgWant},
+		},
+		{
+			name: "documents",
+			in:   "---\n" + dockerConfigJSONSecret + "---\n# legacy\n" + dockercfgSecret + "...\n",
+			want: []credentialprovider.DockerConfig{dockerConfigJSONWant, dockercfgWant},
+		},
+		{
+			// kubectl get secrets -o yaml
+			name: "list",
+			in: "apiVersion: v1\nitems:\n" +
+				"- " + strings.TrimPrefix(indent(dockerConfigJSONSecret, "  "), "  ") +
+				"- " + strings.TrimPrefix(indent(dockercfgSecret, "  "), "  ") +
+				"kind: List\nmetadata:\n  resourceVersion: \"\"\n",
+			want: []credentialprovider.DockerConfig{dockerConfigJSONWant, dockercfgWant},
+		},
+		{
+			name: "stringData",
+			in: `apiVersion: v1
+kind: Secret
+metadata:
+  name: plain
+type: kubernetes.io/dockerconfigjson
+stringData:
+  .dockerconfigjson: '{"auths": {"registry.example.com": {"username": "robot$ci", "password": "s3cr3t:pw"}}}'
+`,
+			want: []credentialprovider.DockerConfig{dockerConfigJSONWant},
+		},
+		{
+			name: "stringData as a block scalar",
+			in: `kind: Secret
+type: kubernetes.io/dockerconfigjson
+stringData:
+  .dockerconfigjson: |
+    {
+      "auths": {
+        "registry.example.com": {"username": "robot$ci", "password": "s3cr3t:pw"}
+      }
+    }
+`,
+			want: []credentialprovider.DockerConfig{dockerConfigJSONWant},
+		},
+		{
+			name: "json",
+			in: `{
+    "apiVersion": "v1",
+    "data": {
+        ".dockercfg": "eyJodHRwczovL2luZGV4LmRvY2tlci5pby92MS8iOnsiYXV0aCI6IlpHMWhaMlU2YUhWdWRHVnlNZz09IiwiZW1haWwiOiJtZUBleGFtcGxlLmNvbSJ9fQ=="
+    },
+    "kind": "Secret",
+    "metadata": {
+        "name": "legacy"
+    },
+    "type": "kubernetes.io/dockercfg"
+}`,
+			want: []credentialprovider.DockerConfig{dockercfgWant},
+		},
+	}
+	for _, tc := range testCases {
+		got, err := Parse([]byte(tc.in))
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{
+			name:    "config map",
+			in:      "apiVersion: v1\nkind: ConfigMap\ndata:\n  a: b\n",
+			wantErr: `expected a Secret, got kind "ConfigMap"`,
+		},
+		{
+			name:    "opaque secret",
+			in:      "kind: Secret\nmetadata:\n  name: opaque\ntype: Opaque\ndata:\n  a: Yg==\n",
+			wantErr: "secret opaque: unsupported type Opaque",
+		},
+		{
+			name:    "missing key",
+			in:      "kind: Secret\ntype: kubernetes.io/dockerconfigjson\ndata:\n  .dockercfg: e30=\n",
+			wantErr: "no .dockerconfigjson key",
+		},
+		{
+			name:    "invalid base64",
+			in:      "kind: Secret\ntype: kubernetes.io/dockercfg\ndata:\n  .dockercfg: '!!!'\n",
+			wantErr: "data..dockercfg: illegal base64 data",
+		},
+		{
+			name:    "empty",
+			in:      "# nothing\n",
+			wantErr: "no Secrets found",
+		},
+		{
+			name:    "scalar document",
+			in:      "just a string\n",
+			wantErr: "expected a Kubernetes object",
+		},
+	}
+	for _, tc := range testCases {
+		_, err := Parse([]byte(tc.in))
+		if err == nil {
+			t.Errorf("%s: expected an error containing %q", tc.name, tc.wantErr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: got error %q, want %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
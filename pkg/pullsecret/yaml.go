@@ -0,0 +1,346 @@
+package pullsecret
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlParser parses the subset of YAML that is used by Kubernetes manifests:
+// block mappings and sequences, plain and quoted scalars, literal and folded
+// block scalars, and comments. Scalars are decoded as strings, mappings as
+// map[string]interface{}, sequences as []interface{}.
+type yamlParser struct {
+	lines []string
+	pos   int
+}
+
+// parseYAMLDocuments parses the documents separated by --- lines.
+func parseYAMLDocuments(s string) ([]interface{}, error) {
+	var docs []interface{}
+	var lines []string
+	flush := func(lineOffset int) error {
+		p := &yamlParser{lines: lines}
+		doc, err := p.parseBlock(0)
+		if err == nil {
+			p.skipBlank()
+			if p.pos < len(p.lines) {
+				err = fmt.Errorf("unexpected indentation")
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineOffset+p.pos+1, err)
+		}
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+		return nil
+	}
+
+	start := 0
+	all := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for i, line := range all {
+		if line == "---" || strings.HasPrefix(line, "--- ") || line == "..." {
+			if err := flush(start); err != nil {
+				return nil, err
+			}
+			lines, start = nil, i+1
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := flush(start); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func isBlankLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || strings.HasPrefix(trimmed, "#")
+}
+
+func (p *yamlParser) skipBlank() {
+	for p.pos < len(p.lines) && isBlankLine(p.lines[p.pos]) {
+		p.pos++
+	}
+}
+
+func isSequenceItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+// parseBlock parses the node that starts on the next line if it is indented
+// at least by indent spaces.
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	p.skipBlank()
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	line := p.lines[p.pos]
+	ind := indentOf(line)
+	if ind < indent {
+		return nil, nil
+	}
+	if strings.HasPrefix(line[ind:], "\t") {
+		return nil, fmt.Errorf("tabs are not allowed in indentation")
+	}
+	if isSequenceItem(line[ind:]) {
+		return p.parseSequence(ind)
+	}
+	if _, _, ok := splitKey(line[ind:]); !ok {
+		p.pos++
+		return parseScalar(line[ind:])
+	}
+	return p.parseMapping(ind)
+}
+
+// splitKey splits "key: value" into the key and the value.
+func splitKey(content string) (string, string, bool) {
+	var key string
+	rest := content
+	switch content[0] {
+	case '"', '\'':
+		end := quotedEnd(content)
+		if end < 0 {
+			return "", "", false
+		}
+		k, err := parseScalar(content[:end])
+		if err != nil {
+			return "", "", false
+		}
+		key, rest = k, content[end:]
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		rest = rest[1:]
+	default:
+		i := 0
+		for {
+			j := strings.IndexByte(content[i:], ':')
+			if j < 0 {
+				return "", "", false
+			}
+			i += j
+			if i+1 == len(content) || content[i+1] == ' ' {
+				break
+			}
+			i++
+		}
+		key, rest = strings.TrimSpace(content[:i]), content[i+1:]
+	}
+	if rest != "" && rest[0] != ' ' {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(rest), true
+}
+
+// quotedEnd returns the position after the closing quote of the quoted
+// scalar at the beginning of s, or -1.
+func quotedEnd(s string) int {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case q == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == q:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func (p *yamlParser) parseMapping(indent int) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.lines) {
+			return m, nil
+		}
+		line := p.lines[p.pos]
+		ind := indentOf(line)
+		if ind < indent {
+			return m, nil
+		}
+		if ind > indent {
+			return nil, fmt.Errorf("unexpected indentation")
+		}
+		if isSequenceItem(line[ind:]) {
+			return m, nil
+		}
+		key, rest, ok := splitKey(line[ind:])
+		if !ok {
+			return nil, fmt.Errorf("expected a key")
+		}
+		if _, ok := m[key]; ok {
+			return nil, fmt.Errorf("duplicate key %s", key)
+		}
+		p.pos++
+
+		var value interface{}
+		var err error
+		switch {
+		case rest == "" || strings.HasPrefix(rest, "#"):
+			// A sequence may have the same indentation as its key.
+			p.skipBlank()
+			if p.pos < len(p.lines) && indentOf(p.lines[p.pos]) == indent && isSequenceItem(p.lines[p.pos][indent:]) {
+				value, err = p.parseSequence(indent)
+			} else {
+				value, err = p.parseBlock(indent + 1)
+			}
+		case rest[0] == '|' || rest[0] == '>':
+			value, err = p.parseBlockScalar(indent, rest)
+		default:
+			value, err = parseScalar(rest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+}
+
+func (p *yamlParser) parseSequence(indent int) ([]interface{}, error) {
+	seq := []interface{}{}
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.lines) {
+			return seq, nil
+		}
+		line := p.lines[p.pos]
+		if indentOf(line) != indent || !isSequenceItem(line[indent:]) {
+			return seq, nil
+		}
+
+		content := strings.TrimSpace(line[indent+1:])
+		var item interface{}
+		var err error
+		switch {
+		case content == "" || strings.HasPrefix(content, "#"):
+			p.pos++
+			item, err = p.parseBlock(indent + 1)
+		default:
+			// Parse the rest of the line as if it started on its own line.
+			itemIndent := indent + 1 + indentOf(line[indent+1:])
+			p.lines[p.pos] = strings.Repeat(" ", itemIndent) + content
+			item, err = p.parseBlock(itemIndent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, item)
+	}
+}
+
+// parseBlockScalar parses a literal (|) or a folded (>) block scalar.
+func (p *yamlParser) parseBlockScalar(indent int, header string) (string, error) {
+	if i := strings.Index(header, " #"); i >= 0 {
+		header = header[:i]
+	}
+	header = strings.TrimSpace(header)
+	folded := header[0] == '>'
+	chomping := byte(0)
+	contentIndent := -1
+	for _, c := range header[1:] {
+		switch {
+		case c == '-' || c == '+':
+			chomping = byte(c)
+		case c >= '1' && c <= '9':
+			contentIndent = indent + int(c-'0')
+		default:
+			return "", fmt.Errorf("invalid block scalar header %q", header)
+		}
+	}
+
+	var lines []string
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if strings.TrimSpace(line) == "" {
+			lines = append(lines, "")
+			p.pos++
+			continue
+		}
+		ind := indentOf(line)
+		if contentIndent < 0 {
+			contentIndent = ind
+		}
+		if ind <= indent || ind < contentIndent {
+			break
+		}
+		lines = append(lines, line[contentIndent:])
+		p.pos++
+	}
+
+	// Trailing blank lines are handled by chomping.
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+
+	var b strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			prev := lines[i-1]
+			switch {
+			case !folded:
+				b.WriteByte('\n')
+			case line == "" && prev != "" && !strings.HasPrefix(prev, " "):
+				// The line break before empty lines is folded away.
+			case line == "" || prev == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(prev, " "):
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(line)
+	}
+	if len(lines) > 0 {
+		switch chomping {
+		case 0:
+			b.WriteByte('\n')
+		case '+':
+			b.WriteString(strings.Repeat("\n", trailing+1))
+		}
+	}
+	return b.String(), nil
+}
+
+// parseScalar parses a plain or a quoted scalar followed by an optional
+// comment.
+func parseScalar(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	switch s[0] {
+	case '"', '\'':
+		end := quotedEnd(s)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if rest := strings.TrimSpace(s[end:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %q after string", rest)
+		}
+		if s[0] == '\'' {
+			return strings.ReplaceAll(s[1:end-1], "''", "'"), nil
+		}
+		value, err := strconv.Unquote(s[:end])
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", s[:end])
+		}
+		return value, nil
+	}
+	// Flow collections, anchors and tags are not supported; they are kept
+	// as strings, as Secrets do not use them for the fields that matter.
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, nil
+}
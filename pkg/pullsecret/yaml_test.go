@@ -0,0 +1,123 @@
+package pullsecret
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseYAMLDocuments(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   string
+		want []interface{}
+	}{
+		{
+			name: "scalars",
+			in: `plain: hello world # comment
+double: "a \"b\"\n\u00e9"
+single: 'it''s # not a comment'
+empty:
+url: https://example.com/a#b
+`,
+			want: []interface{}{map[string]interface{}{
+				"plain":  "hello world",
+				"double": "a \"b\"\né",
+				"single": "it's # not a comment",
+				"empty":  nil,
+				"url":    "https://example.com/a#b",
+			}},
+		},
+		{
+			name: "nested",
+			in: `# comment
+a:
+  b:
+    - x
+    - y: 1
+      z: 2
+  c:
+  - d
+"quoted key": v
+`,
+			want: []interface{}{map[string]interface{}{
+				"a": map[string]interface{}{
+					"b": []interface{}{
+						"x",
+						map[string]interface{}{"y": "1", "z": "2"},
+					},
+					"c": []interface{}{"d"},
+				},
+				"quoted key": "v",
+			}},
+		},
+		{
+			name: "block scalars",
+			in: `literal: |
+  line 1
+    indented
+
+  line 3
+strip: |-
+  no newline
+keep: |+
+  kept
+
+folded: >
+  one
+  two
+
+  three
+    more indented
+  four
+indicator: |2
+    two extra spaces
+`,
+			want: []interface{}{map[string]interface{}{
+				"literal":   "line 1\n  indented\n\nline 3\n",
+				"strip":     "no newline",
+				"keep":      "kept\n\n",
+				"folded":    "one two\nthree\n  more indented\nfour\n",
+				"indicator": "  two extra spaces\n",
+			}},
+		},
+		{
+			name: "documents",
+			in:   "--- \na: 1\n---\n# empty\n...\n---\nb: 2\n",
+			want: []interface{}{
+				map[string]interface{}{"a": "1"},
+				map[string]interface{}{"b": "2"},
+			},
+		},
+		{
+			name: "crlf",
+			in:   "a:\r\n  b: c\r\n",
+			want: []interface{}{map[string]interface{}{
+				"a": map[string]interface{}{"b": "c"},
+			}},
+		},
+	}
+	for _, tc := range testCases {
+		got, err := parseYAMLDocuments(tc.in)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %#v, want %#v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseYAMLDocumentsErrors(t *testing.T) {
+	testCases := []string{
+		"a: \"unterminated\n",
+		"a: 'x' y\n",
+		"a: 1\n  b: 2\n",
+		"a: |x\n  b\n",
+	}
+	for _, in := range testCases {
+		if docs, err := parseYAMLDocuments(in); err == nil {
+			t.Errorf("parseYAMLDocuments(%q) = %#v, want error", in, docs)
+		}
+	}
+}
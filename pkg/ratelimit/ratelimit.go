@@ -0,0 +1,147 @@
+// Package ratelimit limits the bandwidth of HTTP transfers.
+package ratelimit
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chunkSize is the maximum number of bytes that are read at once, so that
+// concurrent transfers get their share of the bandwidth.
+const chunkSize = 32 * 1024
+
+// Limiter is a token bucket that is shared by all transfers that use it. It
+// is safe for concurrent use.
+type Limiter struct {
+	rate  float64 // bytes per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter that allows rate bytes per second.
+func NewLimiter(rate int64) *Limiter {
+	// Keep bursts short, so that the rate is even from the start.
+	burst := float64(rate) / 10
+	if burst < chunkSize {
+		burst = chunkSize
+	}
+	return &Limiter{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// reserve takes n tokens from the bucket and returns how long the caller
+// should wait until the tokens are available.
+func (l *Limiter) reserve(n int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Wait blocks until n bytes can be transferred.
+func (l *Limiter) Wait(n int) {
+	if d := l.reserve(n, time.Now()); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+type reader struct {
+	rc      io.ReadCloser
+	limiter *Limiter
+}
+
+// NewReader returns a reader that reads from rc no faster than the limiter
+// allows.
+func NewReader(rc io.ReadCloser, limiter *Limiter) io.ReadCloser {
+	return &reader{
+		rc:      rc,
+		limiter: limiter,
+	}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	n, err := r.rc.Read(p)
+	if n > 0 {
+		r.limiter.Wait(n)
+	}
+	return n, err
+}
+
+func (r *reader) Close() error {
+	return r.rc.Close()
+}
+
+// RoundTripper limits the bandwidth of request and response bodies.
+type RoundTripper struct {
+	http.RoundTripper
+	Limiter *Limiter
+}
+
+func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(req.Context())
+		req.Body = NewReader(req.Body, rt.Limiter)
+	}
+	resp, err := rt.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.Body != nil && resp.Body != http.NoBody {
+		resp.Body = NewReader(resp.Body, rt.Limiter)
+	}
+	return resp, nil
+}
+
+// ParseRate parses a rate like 500K or 10M (bytes per second, with binary
+// suffixes K, M and G).
+func ParseRate(s string) (int64, error) {
+	value := strings.TrimSuffix(strings.ToUpper(s), "B")
+	multiplier := int64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q: expected a positive number of bytes per second with an optional suffix K, M or G", s)
+	}
+	rate := int64(n * float64(multiplier))
+	if rate < 1 {
+		rate = 1
+	}
+	return rate, nil
+}
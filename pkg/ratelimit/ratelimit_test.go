@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	testCases := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "100", want: 100},
+		{in: "500K", want: 500 << 10},
+		{in: "500k", want: 500 << 10},
+		{in: "10M", want: 10 << 20},
+		{in: "10MB", want: 10 << 20},
+		{in: "1.5M", want: 3 << 19},
+		{in: "2G", want: 2 << 30},
+		{in: "0.1", want: 1},
+		{in: "", wantErr: true},
+		{in: "K", wantErr: true},
+		{in: "0", wantErr: true},
+		{in: "-1M", wantErr: true},
+		{in: "10X", wantErr: true},
+	}
+	for _, tc := range testCases {
+		got, err := ParseRate(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("ParseRate(%q) = %d, want error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRate(%q): %s", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseRate(%q) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestLimiterReserve(t *testing.T) {
+	l := NewLimiter(10 << 20)
+	now := l.last
+	burst := int(l.burst)
+
+	if d := l.reserve(burst, now); d != 0 {
+		t.Errorf("reserving the burst: wait %s, want 0", d)
+	}
+	if d := l.reserve(10<<20, now); d != time.Second {
+		t.Errorf("reserving 10 MiB at 10 MiB/s with an empty bucket: wait %s, want 1s", d)
+	}
+
+	// The debt is paid off after a second, the bucket stays empty.
+	now = now.Add(time.Second)
+	if d := l.reserve(5<<20, now); d != 500*time.Millisecond {
+		t.Errorf("reserving 5 MiB after 1s: wait %s, want 500ms", d)
+	}
+
+	// Idle time does not accumulate more tokens than the burst.
+	now = now.Add(time.Hour)
+	if d := l.reserve(burst, now); d != 0 {
+		t.Errorf("reserving the burst after an hour: wait %s, want 0", d)
+	}
+	if d := l.reserve(1<<10, now); d <= 0 {
+		t.Errorf("reserving more than the burst after an hour: wait %s, want > 0", d)
+	}
+}
+
+func TestLimiterMinimumBurst(t *testing.T) {
+	l := NewLimiter(100)
+	if l.burst != chunkSize {
+		t.Errorf("burst = %f, want %d", l.burst, chunkSize)
+	}
+}
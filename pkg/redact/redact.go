@@ -0,0 +1,120 @@
+// Package redact masks credentials in headers, URLs and bodies, so that they
+// can be logged.
+package redact
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces secret values.
+const Mask = "***"
+
+// IsSecretParameter returns true if the query or form parameter name may
+// hold a secret, e.g. a token or the signature of a pre-signed URL.
+func IsSecretParameter(name string) bool {
+	name = strings.ToLower(name)
+	if name == "sig" {
+		// Azure SAS; X-Amz-SignedHeaders is not a secret.
+		return true
+	}
+	for _, s := range []string{"token", "password", "secret", "signature", "credential", "key"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// URL returns u with the password and secret query parameters masked.
+func URL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	redacted := *u
+	if u.RawQuery != "" {
+		redacted.RawQuery = query(u.RawQuery)
+	}
+	return redacted.Redacted()
+}
+
+// URLString is like URL, but for URLs that are not parsed yet. Values that
+// cannot be parsed are masked entirely.
+func URLString(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return Mask
+	}
+	return URL(u)
+}
+
+// query masks the values of secret parameters in the query or the
+// form-encoded body s, keeping their order.
+func query(s string) string {
+	parts := strings.Split(s, "&")
+	for i, part := range parts {
+		j := strings.IndexByte(part, '=')
+		if j < 0 {
+			continue
+		}
+		name := part[:j]
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if IsSecretParameter(name) {
+			parts[i] = part[:j+1] + Mask
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Header returns the value of the header name with secrets masked. For
+// Authorization headers, the scheme is kept.
+func Header(name string, value string) string {
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Proxy-Authorization":
+		if i := strings.IndexByte(value, ' '); i >= 0 {
+			return value[:i+1] + Mask
+		}
+		return Mask
+	case "Cookie":
+		cookies := strings.Split(value, ";")
+		for i, c := range cookies {
+			cookies[i] = cookieName(c) + "=" + Mask
+		}
+		return strings.Join(cookies, "; ")
+	case "Set-Cookie":
+		attrs := strings.SplitN(value, ";", 2)
+		attrs[0] = cookieName(attrs[0]) + "=" + Mask
+		return strings.Join(attrs, ";")
+	case "Location", "Content-Location":
+		return URLString(value)
+	}
+	return value
+}
+
+func cookieName(c string) string {
+	c = strings.TrimSpace(c)
+	if i := strings.IndexByte(c, '='); i >= 0 {
+		return c[:i]
+	}
+	return c
+}
+
+var jsonSecret = regexp.MustCompile(`("(?:token|access_token|refresh_token|id_token|identitytoken|password|client_secret)"\s*:\s*")((?:[^"\\]|\\.)*\\?)("|$)`)
+
+var formSecret = regexp.MustCompile(`^[A-Za-z0-9_.~%+-]+=[^&\s]*(&[A-Za-z0-9_.~%+-]+=[^&\s]*)*$`)
+
+// Body returns a copy of the (possibly truncated) body b with secrets
+// masked: the values of token fields in JSON objects, such as token
+// responses, and secret fields in form-encoded bodies, such as OAuth token
+// requests.
+func Body(b []byte) []byte {
+	s := string(b)
+	if formSecret.MatchString(s) {
+		return []byte(query(s))
+	}
+	return []byte(jsonSecret.ReplaceAllString(s, "${1}"+Mask+"${3}"))
+}
@@ -0,0 +1,405 @@
+// Package registries reads containers-registries.conf(5) files: registry
+// locations, mirrors, insecure and blocked registries, and short-name
+// resolution.
+package registries
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Values of pull-from-mirror.
+const (
+	PullFromMirrorAll        = "all"
+	PullFromMirrorDigestOnly = "digest-only"
+	PullFromMirrorTagOnly    = "tag-only"
+)
+
+// Mirror is a location that is tried before the registry when images are
+// pulled.
+type Mirror struct {
+	Location       string
+	Insecure       bool
+	PullFromMirror string
+}
+
+// Registry is the configuration for the repositories that match Prefix.
+type Registry struct {
+	Prefix             string
+	Location           string
+	Insecure           bool
+	Blocked            bool
+	MirrorByDigestOnly bool
+	Mirrors            []Mirror
+}
+
+// Config is the contents of registries.conf.
+type Config struct {
+	UnqualifiedSearchRegistries []string
+	Registries                  []Registry
+	Aliases                     map[string]string
+}
+
+// Endpoint is a location from which a repository can be pulled.
+type Endpoint struct {
+	Name     string // the name of the repository at the location
+	Insecure bool
+}
+
+// DefaultPaths returns the locations of registries.conf in the order of
+// preference.
+func DefaultPaths() []string {
+	var paths []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "containers", "registries.conf"))
+	}
+	return append(paths, "/etc/containers/registries.conf")
+}
+
+// Load reads the configuration from the file path.
+func Load(path string) (*Config, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := Parse(string(buf))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Parse parses the configuration in either the version 2 format or the
+// deprecated version 1 format ([registries.search], [registries.insecure] and
+// [registries.block]).
+func Parse(data string) (*Config, error) {
+	doc, err := parseTOML(data)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{
+		Aliases: map[string]string{},
+	}
+	if config.UnqualifiedSearchRegistries, err = getStrings(doc, "unqualified-search-registries"); err != nil {
+		return nil, err
+	}
+
+	registries, err := getTables(doc, "registry")
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range registries {
+		r, err := parseRegistry(t)
+		if err != nil {
+			return nil, err
+		}
+		config.Registries = append(config.Registries, r)
+	}
+
+	if aliases, ok := doc["aliases"].(map[string]interface{}); ok {
+		for name := range aliases {
+			value, err := getString(aliases, name)
+			if err != nil {
+				return nil, fmt.Errorf("aliases: %w", err)
+			}
+			config.Aliases[name] = value
+		}
+	}
+
+	if v1, ok := doc["registries"].(map[string]interface{}); ok {
+		if err := parseV1(config, v1); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+func parseRegistry(t map[string]interface{}) (Registry, error) {
+	var r Registry
+	var err error
+	if r.Prefix, err = getString(t, "prefix"); err != nil {
+		return r, err
+	}
+	if r.Location, err = getString(t, "location"); err != nil {
+		return r, err
+	}
+	if r.Prefix == "" {
+		r.Prefix = r.Location
+	}
+	if r.Prefix == "" {
+		return r, fmt.Errorf("registry: either prefix or location must be set")
+	}
+	if r.Location == "" && !strings.HasPrefix(r.Prefix, "*.") {
+		r.Location = r.Prefix
+	}
+	if r.Insecure, err = getBool(t, "insecure"); err != nil {
+		return r, err
+	}
+	if r.Blocked, err = getBool(t, "blocked"); err != nil {
+		return r, err
+	}
+	if r.MirrorByDigestOnly, err = getBool(t, "mirror-by-digest-only"); err != nil {
+		return r, err
+	}
+
+	mirrors, err := getTables(t, "mirror")
+	if err != nil {
+		return r, err
+	}
+	for _, mt := range mirrors {
+		var m Mirror
+		if m.Location, err = getString(mt, "location"); err != nil {
+			return r, err
+		}
+		if m.Location == "" {
+			return r, fmt.Errorf("registry %s: mirror location must be set", r.Prefix)
+		}
+		if m.Insecure, err = getBool(mt, "insecure"); err != nil {
+			return r, err
+		}
+		if m.PullFromMirror, err = getString(mt, "pull-from-mirror"); err != nil {
+			return r, err
+		}
+		switch m.PullFromMirror {
+		case "":
+			m.PullFromMirror = PullFromMirrorAll
+		case PullFromMirrorAll, PullFromMirrorDigestOnly, PullFromMirrorTagOnly:
+		default:
+			return r, fmt.Errorf("registry %s: invalid pull-from-mirror %q", r.Prefix, m.PullFromMirror)
+		}
+		r.Mirrors = append(r.Mirrors, m)
+	}
+	return r, nil
+}
+
+func parseV1(config *Config, v1 map[string]interface{}) error {
+	lists := []struct {
+		table string
+		apply func(name string)
+	}{
+		{"search", func(name string) {
+			config.UnqualifiedSearchRegistries = append(config.UnqualifiedSearchRegistries, name)
+		}},
+		{"insecure", func(name string) {
+			config.registry(name).Insecure = true
+		}},
+		{"block", func(name string) {
+			config.registry(name).Blocked = true
+		}},
+	}
+	for _, l := range lists {
+		t, ok := v1[l.table].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		names, err := getStrings(t, "registries")
+		if err != nil {
+			return fmt.Errorf("registries.%s: %w", l.table, err)
+		}
+		for _, name := range names {
+			l.apply(name)
+		}
+	}
+	return nil
+}
+
+// registry returns the registry with the prefix, adding it if necessary.
+func (c *Config) registry(prefix string) *Registry {
+	for i := range c.Registries {
+		if c.Registries[i].Prefix == prefix {
+			return &c.Registries[i]
+		}
+	}
+	c.Registries = append(c.Registries, Registry{
+		Prefix:   prefix,
+		Location: prefix,
+	})
+	return &c.Registries[len(c.Registries)-1]
+}
+
+// matchPrefix returns the length of the part of name that matches prefix,
+// or -1 if the prefix does not match.
+func matchPrefix(name, prefix string) int {
+	if strings.HasPrefix(prefix, "*.") {
+		host := name
+		if i := strings.IndexByte(host, '/'); i >= 0 {
+			host = host[:i]
+		}
+		if strings.HasSuffix(host, prefix[1:]) {
+			return len(host)
+		}
+		return -1
+	}
+	if name == prefix {
+		return len(prefix)
+	}
+	if strings.HasPrefix(name, prefix) && name[len(prefix)] == '/' {
+		return len(prefix)
+	}
+	return -1
+}
+
+// Lookup returns the registry configuration for the repository name (a fully
+// qualified name without a tag or a digest), or nil if there is none. The
+// longest matching prefix wins.
+func (c *Config) Lookup(name string) *Registry {
+	var best *Registry
+	bestLength := -1
+	for i := range c.Registries {
+		r := &c.Registries[i]
+		n := matchPrefix(name, r.Prefix)
+		if n < 0 {
+			continue
+		}
+		// Wildcards have lower priority than exact prefixes.
+		if strings.HasPrefix(r.Prefix, "*.") {
+			n = len(r.Prefix) - 2
+		}
+		if n > bestLength {
+			best, bestLength = r, n
+		}
+	}
+	return best
+}
+
+// rewrite replaces the part of name that matches the prefix with location.
+func (r *Registry) rewrite(name, location string) string {
+	n := matchPrefix(name, r.Prefix)
+	if n < 0 || location == "" {
+		return name
+	}
+	return location + name[n:]
+}
+
+// Endpoint returns the location of the repository name in the registry.
+func (r *Registry) Endpoint(name string) Endpoint {
+	return Endpoint{
+		Name:     r.rewrite(name, r.Location),
+		Insecure: r.Insecure,
+	}
+}
+
+// PullEndpoints returns the mirrors that should be tried before the
+// registry, followed by the registry itself. byDigest should be true if the
+// image is pulled by digest.
+func (r *Registry) PullEndpoints(name string, byDigest bool) []Endpoint {
+	var endpoints []Endpoint
+	if !r.MirrorByDigestOnly || byDigest {
+		for _, m := range r.Mirrors {
+			if m.PullFromMirror == PullFromMirrorDigestOnly && !byDigest || m.PullFromMirror == PullFromMirrorTagOnly && byDigest {
+				continue
+			}
+			endpoints = append(endpoints, Endpoint{
+				Name:     r.rewrite(name, m.Location),
+				Insecure: m.Insecure,
+			})
+		}
+	}
+	return append(endpoints, r.Endpoint(name))
+}
+
+// locationHost returns the host part of a location.
+func locationHost(location string) string {
+	if i := strings.IndexByte(location, '/'); i >= 0 {
+		return location[:i]
+	}
+	return location
+}
+
+// InsecureHost returns true if a registry or a mirror on the host is marked
+// as insecure.
+func (c *Config) InsecureHost(host string) bool {
+	for _, r := range c.Registries {
+		if r.Insecure && locationHost(r.Location) == host {
+			return true
+		}
+		for _, m := range r.Mirrors {
+			if m.Insecure && locationHost(m.Location) == host {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsShortName returns true if the reference does not start with a registry
+// domain.
+func IsShortName(ref string) bool {
+	i := strings.IndexByte(ref, '/')
+	if i < 0 {
+		return true
+	}
+	domain := ref[:i]
+	return !strings.ContainsAny(domain, ".:") && domain != "localhost"
+}
+
+// Alias returns the fully qualified name for the short name, if there is an
+// alias for it.
+func (c *Config) Alias(shortName string) (string, bool) {
+	name, ok := c.Aliases[shortName]
+	return name, ok
+}
+
+func getString(t map[string]interface{}, key string) (string, error) {
+	switch v := t[key].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("%s: expected a string", key)
+}
+
+func getBool(t map[string]interface{}, key string) (bool, error) {
+	switch v := t[key].(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	}
+	return false, fmt.Errorf("%s: expected a boolean", key)
+}
+
+func getStrings(t map[string]interface{}, key string) ([]string, error) {
+	switch v := t[key].(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: expected an array of strings", key)
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("%s: expected an array of strings", key)
+}
+
+func getTables(t map[string]interface{}, key string) ([]map[string]interface{}, error) {
+	switch v := t[key].(type) {
+	case nil:
+		return nil, nil
+	case []map[string]interface{}:
+		return v, nil
+	case []interface{}:
+		// An array of inline tables.
+		var tables []map[string]interface{}
+		for _, item := range v {
+			table, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: expected an array of tables", key)
+			}
+			tables = append(tables, table)
+		}
+		return tables, nil
+	}
+	return nil, fmt.Errorf("%s: expected an array of tables", key)
+}
@@ -0,0 +1,318 @@
+package registries
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+unqualified-search-registries = [
+  "registry.fedoraproject.org", # Fedora
+  # "quay.io",
+  "docker.io",
+]
+
+[[registry]]
+prefix = "example.com/foo"
+location = "internal.example.com/bar"
+insecure = true
+
+[[registry.mirror]]
+location = "mirror-1.example.com/foo"
+[[registry.mirror]]
+location = "mirror-2.example.com"
+insecure = true
+pull-from-mirror = "digest-only"
+[[registry.mirror]]
+location = "mirror-3.example.com/tags"
+pull-from-mirror = "tag-only"
+
+[[registry]]
+location = "example.com"
+blocked = true
+
+[[registry]]
+prefix = "*.example.org"
+location = "proxy.example.org"
+
+[[registry]]
+prefix = "docker.io/library"
+location = "docker.io/library"
+mirror-by-digest-only = true
+mirror = [
+  { location = "digest-mirror.example.com/library" },
+]
+
+[aliases]
+"fedora" = "registry.fedoraproject.org/fedora"
+'alpine' = "docker.io/library/alpine"
+`
+
+func TestParse(t *testing.T) {
+	config, err := Parse(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Config{
+		UnqualifiedSearchRegistries: []string{"registry.fedoraproject.org", "docker.io"},
+		Registries: []Registry{
+			{
+				Prefix:   "example.com/foo",
+				Location: "internal.example.com/bar",
+				Insecure: true,
+				Mirrors: []Mirror{
+					{Location: "mirror-1.example.com/foo", PullFromMirror: PullFromMirrorAll},
+					{Location: "mirror-2.example.com", Insecure: true, PullFromMirror: PullFromMirrorDigestOnly},
+					{Location: "mirror-3.example.com/tags", PullFromMirror: PullFromMirrorTagOnly},
+				},
+			},
+			{
+				Prefix:   "example.com",
+				Location: "example.com",
+				Blocked:  true,
+			},
+			{
+				Prefix:   "*.example.org",
+				Location: "proxy.example.org",
+			},
+			{
+				Prefix:             "docker.io/library",
+				Location:           "docker.io/library",
+				MirrorByDigestOnly: true,
+				Mirrors: []Mirror{
+					{Location: "digest-mirror.example.com/library", PullFromMirror: PullFromMirrorAll},
+				},
+			},
+		},
+		Aliases: map[string]string{
+			"fedora": "registry.fedoraproject.org/fedora",
+			"alpine": "docker.io/library/alpine",
+		},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("got %+v, want %+v", config, want)
+	}
+}
+
+func TestParseV1(t *testing.T) {
+	config, err := Parse(`
+[registries.search]
+registries = ['registry.access.redhat.com', 'quay.io']
+
+[registries.insecure]
+registries = ["localhost:5000"]
+
+[registries.block]
+registries = ["bad.example.com", "localhost:5000"]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Config{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "quay.io"},
+		Registries: []Registry{
+			{Prefix: "localhost:5000", Location: "localhost:5000", Insecure: true, Blocked: true},
+			{Prefix: "bad.example.com", Location: "bad.example.com", Blocked: true},
+		},
+		Aliases: map[string]string{},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("got %+v, want %+v", config, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := []struct {
+		in      string
+		wantErr string
+	}{
+		{in: "unqualified-search-registries = \"docker.io\"", wantErr: "expected an array of strings"},
+		{in: "unqualified-search-registries = [1]", wantErr: "expected an array of strings"},
+		{in: "[[registry]]\ninsecure = true", wantErr: "either prefix or location must be set"},
+		{in: "[[registry]]\nlocation = \"a.com\"\ninsecure = \"yes\"", wantErr: "insecure: expected a boolean"},
+		{in: "[[registry]]\nlocation = \"a.com\"\n[[registry.mirror]]\ninsecure = true", wantErr: "mirror location must be set"},
+		{in: "[[registry]]\nlocation = \"a.com\"\n[[registry.mirror]]\nlocation = \"b.com\"\npull-from-mirror = \"never\"", wantErr: `invalid pull-from-mirror "never"`},
+		{in: "registry = \"a.com\"", wantErr: "registry: expected an array of tables"},
+		{in: "[aliases]\nfoo = 1", wantErr: "aliases: foo: expected a string"},
+		{in: "[registries.search]\nregistries = \"quay.io\"", wantErr: "registries.search: registries: expected an array of strings"},
+		{in: "[[registry]\nlocation = \"a.com\"", wantErr: "line 1: expected ]]"},
+	}
+	for _, tc := range testCases {
+		_, err := Parse(tc.in)
+		if err == nil {
+			t.Errorf("Parse(%q): expected an error containing %q", tc.in, tc.wantErr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("Parse(%q): got error %q, want %q", tc.in, err, tc.wantErr)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	config, err := Parse(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name       string
+		wantPrefix string
+	}{
+		{name: "example.com/foo", wantPrefix: "example.com/foo"},
+		{name: "example.com/foo/app", wantPrefix: "example.com/foo"},
+		{name: "example.com/foobar", wantPrefix: "example.com"},
+		{name: "example.com/other", wantPrefix: "example.com"},
+		{name: "registry.example.org/app", wantPrefix: "*.example.org"},
+		{name: "a.b.example.org/app", wantPrefix: "*.example.org"},
+		{name: "example.org/app", wantPrefix: ""},
+		{name: "docker.io/library/alpine", wantPrefix: "docker.io/library"},
+		{name: "docker.io/other/alpine", wantPrefix: ""},
+		{name: "example.com.evil.net/foo", wantPrefix: ""},
+	}
+	for _, tc := range testCases {
+		r := config.Lookup(tc.name)
+		prefix := ""
+		if r != nil {
+			prefix = r.Prefix
+		}
+		if prefix != tc.wantPrefix {
+			t.Errorf("Lookup(%q) = %q, want %q", tc.name, prefix, tc.wantPrefix)
+		}
+	}
+}
+
+func TestLookupPrefersExactPrefixes(t *testing.T) {
+	config, err := Parse(`
+[[registry]]
+prefix = "*.example.com"
+location = "wildcard.example.net"
+
+[[registry]]
+prefix = "registry.example.com"
+location = "exact.example.net"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := config.Lookup("registry.example.com/app"); r == nil || r.Location != "exact.example.net" {
+		t.Errorf("Lookup = %+v, want the exact prefix", r)
+	}
+}
+
+func TestPullEndpoints(t *testing.T) {
+	config, err := Parse(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		byDigest bool
+		want     []Endpoint
+	}{
+		{
+			name: "example.com/foo/app",
+			want: []Endpoint{
+				{Name: "mirror-1.example.com/foo/app"},
+				{Name: "mirror-3.example.com/tags/app"},
+				{Name: "internal.example.com/bar/app", Insecure: true},
+			},
+		},
+		{
+			name:     "example.com/foo/app",
+			byDigest: true,
+			want: []Endpoint{
+				{Name: "mirror-1.example.com/foo/app"},
+				{Name: "mirror-2.example.com/app", Insecure: true},
+				{Name: "internal.example.com/bar/app", Insecure: true},
+			},
+		},
+		{
+			name: "docker.io/library/alpine",
+			want: []Endpoint{
+				{Name: "docker.io/library/alpine"},
+			},
+		},
+		{
+			name:     "docker.io/library/alpine",
+			byDigest: true,
+			want: []Endpoint{
+				{Name: "digest-mirror.example.com/library/alpine"},
+				{Name: "docker.io/library/alpine"},
+			},
+		},
+		{
+			name: "registry.example.org/team/app",
+			want: []Endpoint{
+				{Name: "proxy.example.org/team/app"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		r := config.Lookup(tc.name)
+		if r == nil {
+			t.Errorf("Lookup(%q) = nil", tc.name)
+			continue
+		}
+		got := r.PullEndpoints(tc.name, tc.byDigest)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("PullEndpoints(%q, %t) = %+v, want %+v", tc.name, tc.byDigest, got, tc.want)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	testCases := []struct {
+		prefix   string
+		name     string
+		location string
+		want     string
+	}{
+		{prefix: "example.com/foo", name: "example.com/foo", location: "mirror.com/bar", want: "mirror.com/bar"},
+		{prefix: "example.com/foo", name: "example.com/foo/app", location: "mirror.com", want: "mirror.com/app"},
+		{prefix: "example.com/foo", name: "example.com/foobar", location: "mirror.com", want: "example.com/foobar"},
+		{prefix: "*.example.com", name: "a.example.com/app", location: "mirror.com", want: "mirror.com/app"},
+		{prefix: "example.com", name: "example.com/app", location: "", want: "example.com/app"},
+	}
+	for _, tc := range testCases {
+		r := &Registry{Prefix: tc.prefix}
+		if got := r.rewrite(tc.name, tc.location); got != tc.want {
+			t.Errorf("rewrite(%q, %q) with prefix %q = %q, want %q", tc.name, tc.location, tc.prefix, got, tc.want)
+		}
+	}
+}
+
+func TestInsecureHost(t *testing.T) {
+	config, err := Parse(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, want := range map[string]bool{
+		"internal.example.com": true,
+		"mirror-2.example.com": true,
+		"mirror-1.example.com": false,
+		"example.com":          false,
+	} {
+		if got := config.InsecureHost(host); got != want {
+			t.Errorf("InsecureHost(%q) = %t, want %t", host, got, want)
+		}
+	}
+}
+
+func TestIsShortName(t *testing.T) {
+	for ref, want := range map[string]bool{
+		"alpine":                   true,
+		"library/alpine":           true,
+		"docker.io/library/alpine": false,
+		"localhost/app":            false,
+		"registry:5000/app":        false,
+	} {
+		if got := IsShortName(ref); got != want {
+			t.Errorf("IsShortName(%q) = %t, want %t", ref, got, want)
+		}
+	}
+}
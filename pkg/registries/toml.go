@@ -0,0 +1,484 @@
+package registries
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// tomlParser parses the subset of TOML that is used by registries.conf:
+// tables, arrays of tables, strings (including multi-line strings), booleans,
+// integers, arrays and inline tables. Floats and dates are not supported.
+// Tables are decoded as map[string]interface{}, arrays of tables as
+// []map[string]interface{}.
+type tomlParser struct {
+	s    string
+	pos  int
+	line int
+}
+
+func parseTOML(s string) (map[string]interface{}, error) {
+	p := &tomlParser{
+		s:    s,
+		line: 1,
+	}
+	root := map[string]interface{}{}
+	if err := p.parse(root); err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.line, err)
+	}
+	return root, nil
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *tomlParser) skipSpaces() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+func (p *tomlParser) skipComment() {
+	if p.peek() == '#' {
+		for !p.eof() && p.peek() != '\n' {
+			p.pos++
+		}
+	}
+}
+
+// skipBlank skips whitespace, comments and newlines.
+func (p *tomlParser) skipBlank() {
+	for {
+		p.skipSpaces()
+		p.skipComment()
+		switch p.peek() {
+		case '\r':
+			p.pos++
+		case '\n':
+			p.pos++
+			p.line++
+		default:
+			return
+		}
+	}
+}
+
+// endLine expects the end of the line, optionally with a comment.
+func (p *tomlParser) endLine() error {
+	p.skipSpaces()
+	p.skipComment()
+	if p.peek() == '\r' {
+		p.pos++
+	}
+	if p.eof() {
+		return nil
+	}
+	if p.peek() != '\n' {
+		return fmt.Errorf("unexpected %q after value", p.peek())
+	}
+	p.pos++
+	p.line++
+	return nil
+}
+
+func (p *tomlParser) parse(root map[string]interface{}) error {
+	current := root
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return nil
+		}
+
+		if p.peek() == '[' {
+			p.pos++
+			isArray := p.peek() == '['
+			if isArray {
+				p.pos++
+			}
+			path, err := p.parseKeyPath()
+			if err != nil {
+				return err
+			}
+			closing := "]"
+			if isArray {
+				closing = "]]"
+			}
+			if !strings.HasPrefix(p.s[p.pos:], closing) {
+				return fmt.Errorf("expected %s", closing)
+			}
+			p.pos += len(closing)
+			current, err = p.table(root, path, isArray)
+			if err != nil {
+				return err
+			}
+		} else {
+			path, err := p.parseKeyPath()
+			if err != nil {
+				return err
+			}
+			if p.peek() != '=' {
+				return fmt.Errorf("expected = after key %s", strings.Join(path, "."))
+			}
+			p.pos++
+			p.skipSpaces()
+			value, err := p.parseValue()
+			if err != nil {
+				return err
+			}
+			if err := setKey(current, path, value); err != nil {
+				return err
+			}
+		}
+
+		if err := p.endLine(); err != nil {
+			return err
+		}
+	}
+}
+
+// table returns the table for the header path. For arrays of tables, a new
+// table is appended to the array.
+func (p *tomlParser) table(root map[string]interface{}, path []string, isArray bool) (map[string]interface{}, error) {
+	t := root
+	for i, key := range path {
+		last := i == len(path)-1
+		switch v := t[key].(type) {
+		case nil:
+			child := map[string]interface{}{}
+			if last && isArray {
+				t[key] = []map[string]interface{}{child}
+			} else {
+				t[key] = child
+			}
+			t = child
+		case map[string]interface{}:
+			if last && isArray {
+				return nil, fmt.Errorf("%s is a table, not an array of tables", strings.Join(path, "."))
+			}
+			t = v
+		case []map[string]interface{}:
+			if last && isArray {
+				child := map[string]interface{}{}
+				t[key] = append(v, child)
+				t = child
+			} else {
+				t = v[len(v)-1]
+			}
+		default:
+			return nil, fmt.Errorf("%s is not a table", strings.Join(path[:i+1], "."))
+		}
+	}
+	return t, nil
+}
+
+func setKey(t map[string]interface{}, path []string, value interface{}) error {
+	for _, key := range path[:len(path)-1] {
+		switch v := t[key].(type) {
+		case nil:
+			child := map[string]interface{}{}
+			t[key] = child
+			t = child
+		case map[string]interface{}:
+			t = v
+		default:
+			return fmt.Errorf("%s is not a table", key)
+		}
+	}
+	key := path[len(path)-1]
+	if _, ok := t[key]; ok {
+		return fmt.Errorf("duplicate key %s", strings.Join(path, "."))
+	}
+	t[key] = value
+	return nil
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// parseKeyPath parses a dotted key.
+func (p *tomlParser) parseKeyPath() ([]string, error) {
+	var path []string
+	for {
+		p.skipSpaces()
+		var key string
+		switch c := p.peek(); {
+		case c == '"':
+			s, err := p.parseBasicString()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		case c == '\'':
+			s, err := p.parseLiteralString()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		case isBareKeyChar(c):
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			key = p.s[start:p.pos]
+		default:
+			return nil, fmt.Errorf("expected a key")
+		}
+		path = append(path, key)
+		p.skipSpaces()
+		if p.peek() != '.' {
+			return path, nil
+		}
+		p.pos++
+	}
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	switch c := p.peek(); {
+	case c == '"':
+		if strings.HasPrefix(p.s[p.pos:], `"""`) {
+			return p.parseMultilineString('"')
+		}
+		return p.parseBasicString()
+	case c == '\'':
+		if strings.HasPrefix(p.s[p.pos:], "'''") {
+			return p.parseMultilineString('\'')
+		}
+		return p.parseLiteralString()
+	case c == '[':
+		return p.parseArray()
+	case c == '{':
+		return p.parseInlineTable()
+	case strings.HasPrefix(p.s[p.pos:], "true"):
+		p.pos += len("true")
+		return true, nil
+	case strings.HasPrefix(p.s[p.pos:], "false"):
+		p.pos += len("false")
+		return false, nil
+	case c == '+' || c == '-' || c >= '0' && c <= '9':
+		start := p.pos
+		p.pos++
+		for !p.eof() && (p.peek() >= '0' && p.peek() <= '9' || p.peek() == '_') {
+			p.pos++
+		}
+		n, err := strconv.ParseInt(strings.ReplaceAll(p.s[start:p.pos], "_", ""), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", p.s[start:p.pos])
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("unsupported value")
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	p.pos++ // "
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if err := p.parseEscape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// parseEscape parses an escape sequence after a backslash in a basic string.
+func (p *tomlParser) parseEscape(b *strings.Builder) error {
+	if p.eof() {
+		return fmt.Errorf("unterminated string")
+	}
+	e := p.peek()
+	p.pos++
+	switch e {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case '"', '\\':
+		b.WriteByte(e)
+	case 'u', 'U':
+		size := 4
+		if e == 'U' {
+			size = 8
+		}
+		if p.pos+size > len(p.s) {
+			return fmt.Errorf("invalid escape sequence")
+		}
+		r, err := strconv.ParseUint(p.s[p.pos:p.pos+size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return fmt.Errorf("invalid escape sequence")
+		}
+		b.WriteRune(rune(r))
+		p.pos += size
+	default:
+		return fmt.Errorf("invalid escape sequence \\%c", e)
+	}
+	return nil
+}
+
+// parseMultilineString parses a multi-line basic or literal string, which is
+// delimited by three quote characters. A newline right after the opening
+// delimiter is trimmed, and in basic strings a backslash at the end of a line
+// trims the following whitespace.
+func (p *tomlParser) parseMultilineString(quote byte) (string, error) {
+	p.pos += 3
+	if strings.HasPrefix(p.s[p.pos:], "\r\n") {
+		p.pos += 2
+		p.line++
+	} else if p.peek() == '\n' {
+		p.pos++
+		p.line++
+	}
+
+	var b strings.Builder
+	for {
+		if p.eof() {
+			return "", fmt.Errorf("unterminated string")
+		}
+		c := p.peek()
+		if c == quote {
+			n := 0
+			for p.pos+n < len(p.s) && p.s[p.pos+n] == quote {
+				n++
+			}
+			if n >= 3 {
+				if n > 5 {
+					return "", fmt.Errorf("too many quotes at the end of string")
+				}
+				// Up to two quotes can be placed right before the closing
+				// delimiter.
+				b.WriteString(p.s[p.pos : p.pos+n-3])
+				p.pos += n
+				return b.String(), nil
+			}
+			b.WriteString(p.s[p.pos : p.pos+n])
+			p.pos += n
+			continue
+		}
+		p.pos++
+		switch {
+		case c == '\n':
+			p.line++
+			b.WriteByte(c)
+		case c == '\\' && quote == '"':
+			rest := p.s[p.pos:]
+			trimmed := strings.TrimLeft(rest, " \t")
+			if strings.HasPrefix(trimmed, "\n") || strings.HasPrefix(trimmed, "\r\n") {
+				// A line ending backslash.
+				p.pos += len(rest) - len(trimmed)
+				for !p.eof() && strings.IndexByte(" \t\r\n", p.peek()) >= 0 {
+					if p.peek() == '\n' {
+						p.line++
+					}
+					p.pos++
+				}
+				continue
+			}
+			if err := p.parseEscape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	p.pos++ // '
+	start := p.pos
+	for !p.eof() && p.peek() != '\'' && p.peek() != '\n' {
+		p.pos++
+	}
+	if p.peek() != '\'' {
+		return "", fmt.Errorf("unterminated string")
+	}
+	s := p.s[start:p.pos]
+	p.pos++
+	return s, nil
+}
+
+func (p *tomlParser) parseArray() ([]interface{}, error) {
+	p.pos++ // [
+	values := []interface{}{}
+	for {
+		p.skipBlank()
+		if p.peek() == ']' {
+			p.pos++
+			return values, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		p.skipBlank()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, fmt.Errorf("expected , or ] in array")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (map[string]interface{}, error) {
+	p.pos++ // {
+	t := map[string]interface{}{}
+	for {
+		p.skipSpaces()
+		if p.peek() == '}' {
+			p.pos++
+			return t, nil
+		}
+		path, err := p.parseKeyPath()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != '=' {
+			return nil, fmt.Errorf("expected = after key %s", strings.Join(path, "."))
+		}
+		p.pos++
+		p.skipSpaces()
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := setKey(t, path, value); err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+		default:
+			return nil, fmt.Errorf("expected , or } in inline table")
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package registries
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   string
+		want map[string]interface{}
+	}{
+		{
+			name: "keys and comments",
+			in: `# comment
+a = "x" # trailing comment
+b = 'C:\path'
+c = true
+d = -1_000
+"quoted key" = "\u00e9\t\"\\"
+e.f = false
+`,
+			want: map[string]interface{}{
+				"a":          "x",
+				"b":          `C:\path`,
+				"c":          true,
+				"d":          int64(-1000),
+				"quoted key": "é\t\"\\",
+				"e":          map[string]interface{}{"f": false},
+			},
+		},
+		{
+			name: "arrays with comments and trailing commas",
+			in:   "list = [\r\n  \"a\", # first\r\n  # between\r\n  'b',\r\n]\nempty = []\nnested = [[1], []]\n",
+			want: map[string]interface{}{
+				"list":   []interface{}{"a", "b"},
+				"empty":  []interface{}{},
+				"nested": []interface{}{[]interface{}{int64(1)}, []interface{}{}},
+			},
+		},
+		{
+			name: "tables",
+			in: `[a]
+x = 1
+[a.b]
+y = 2
+[c."d.e"]
+z = 3
+`,
+			want: map[string]interface{}{
+				"a": map[string]interface{}{
+					"x": int64(1),
+					"b": map[string]interface{}{"y": int64(2)},
+				},
+				"c": map[string]interface{}{
+					"d.e": map[string]interface{}{"z": int64(3)},
+				},
+			},
+		},
+		{
+			name: "arrays of tables",
+			in: `[[r]]
+n = 1
+[[r.m]]
+k = "a"
+[[r.m]]
+k = "b"
+[[r]]
+n = 2
+`,
+			want: map[string]interface{}{
+				"r": []map[string]interface{}{
+					{
+						"n": int64(1),
+						"m": []map[string]interface{}{{"k": "a"}, {"k": "b"}},
+					},
+					{"n": int64(2)},
+				},
+			},
+		},
+		{
+			name: "inline tables",
+			in:   `t = { a = "x", b.c = true, d = [{ e = 1 }] }`,
+			want: map[string]interface{}{
+				"t": map[string]interface{}{
+					"a": "x",
+					"b": map[string]interface{}{"c": true},
+					"d": []interface{}{map[string]interface{}{"e": int64(1)}},
+				},
+			},
+		},
+		{
+			name: "multi-line strings",
+			in: `a = """
+line 1
+line "2"\t"""
+b = '''
+C:\path
+'quoted'\'''
+c = """one \
+    two"""
+d = """""x"""""
+`,
+			want: map[string]interface{}{
+				"a": "line 1\nline \"2\"\t",
+				"b": "C:\\path\n'quoted'\\",
+				"c": "one two",
+				"d": `""x""`,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		got, err := parseTOML(tc.in)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %#v, want %#v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	testCases := []struct {
+		in      string
+		wantErr string
+	}{
+		{in: `a = "x`, wantErr: "line 1: unterminated string"},
+		{in: "a = 'x\n'", wantErr: "line 1: unterminated string"},
+		{in: "\n\na = \"\"\"x", wantErr: "line 3: unterminated string"},
+		{in: `a = "\q"`, wantErr: `line 1: invalid escape sequence \q`},
+		{in: "a = 1\na = 2", wantErr: "line 2: duplicate key a"},
+		{in: "a = 1 b = 2", wantErr: `line 1: unexpected 'b' after value`},
+		{in: "a 1", wantErr: "line 1: expected = after key a"},
+		{in: "a = 1.5", wantErr: `line 1: unexpected '.' after value`},
+		{in: "a = 1979-05-27", wantErr: `line 1: unexpected '-' after value`},
+		{in: "a = [1 2]", wantErr: "line 1: expected , or ] in array"},
+		{in: "a = { b = 1 c = 2 }", wantErr: "line 1: expected , or } in inline table"},
+		{in: "[a\nb = 1", wantErr: "line 1: expected ]"},
+		{in: "[a]\n[[a]]", wantErr: "line 2: a is a table, not an array of tables"},
+		{in: "a = 1\n[a.b]", wantErr: "line 2: a is not a table"},
+		{in: "a = nope", wantErr: "line 1: unsupported value"},
+	}
+	for _, tc := range testCases {
+		_, err := parseTOML(tc.in)
+		if err == nil {
+			t.Errorf("parseTOML(%q): expected error %q", tc.in, tc.wantErr)
+			continue
+		}
+		if err.Error() != tc.wantErr {
+			t.Errorf("parseTOML(%q): got error %q, want %q", tc.in, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Package retry implements an HTTP round tripper that retries requests that
+// failed because of transient errors.
+package retry
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/dmage/boater/pkg/redact"
+)
+
+const baseDelay = 500 * time.Millisecond
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// canRetry returns true if the request can be sent again: either its body can
+// be rewound, or it is an idempotent request without a body. Non-idempotent
+// requests without a body can be marked as safe to repeat by setting GetBody.
+func canRetry(req *http.Request) bool {
+	if req.GetBody != nil {
+		return true
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return isIdempotent(req.Method)
+	}
+	return false
+}
+
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func isRetryableError(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter returns the delay from the Retry-After header, which is
+// either a number of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// backoff returns the delay before the retry number attempt (starting from
+// 0): exponential backoff with equal jitter.
+func backoff(attempt int, maxWait time.Duration) time.Duration {
+	d := baseDelay << uint(attempt)
+	if d <= 0 || d > maxWait {
+		d = maxWait
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// RoundTripper retries requests that failed with a network error or with the
+// status 429, 502, 503 or 504. Only idempotent requests without a body and
+// requests with rewindable bodies are retried.
+//
+// Only sending the request and receiving the response headers are retried.
+// Errors that happen while the caller reads the response body, e.g. a
+// connection reset in the middle of a long blob, are returned to the caller.
+type RoundTripper struct {
+	http.RoundTripper
+	Retries int           // the maximum number of retries
+	MaxWait time.Duration // the maximum delay before a retry
+	Verbose bool          // log retries
+}
+
+func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := rt.RoundTripper
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		resp, err := transport.RoundTrip(req)
+		if attempt >= rt.Retries || !canRetry(req) {
+			return resp, err
+		}
+
+		var reason string
+		var delay time.Duration
+		if err != nil {
+			if !isRetryableError(err) {
+				return nil, err
+			}
+			reason = err.Error()
+			delay = backoff(attempt, rt.MaxWait)
+		} else {
+			if !isRetryableStatus(resp.StatusCode) {
+				return resp, nil
+			}
+			reason = resp.Status
+			delay = backoff(attempt, rt.MaxWait)
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				if retryAfter > rt.MaxWait {
+					// The server asks to wait longer than allowed.
+					return resp, nil
+				}
+				if retryAfter > delay {
+					delay = retryAfter
+				}
+			}
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		if rt.Verbose {
+			log.Printf("Retrying %s %s in %s (retry %d of %d): %s", req.Method, redact.URL(req.URL), delay.Round(time.Millisecond), attempt+1, rt.Retries, reason)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	testCases := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "", wantOK: false},
+		{value: "0", want: 0, wantOK: true},
+		{value: "120", want: 2 * time.Minute, wantOK: true},
+		{value: "-1", wantOK: false},
+		{value: "soon", wantOK: false},
+		{value: "Sun, 02 Jan 2022 03:04:35 GMT", want: 30 * time.Second, wantOK: true},
+		{value: "Sun, 02 Jan 2022 03:00:00 GMT", want: 0, wantOK: true},
+	}
+	for _, tc := range testCases {
+		got, ok := parseRetryAfter(tc.value, now)
+		if ok != tc.wantOK || got != tc.want {
+			t.Errorf("parseRetryAfter(%q) = %s, %t, want %s, %t", tc.value, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	maxWait := 3 * time.Second
+	testCases := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 0, max: baseDelay},
+		{attempt: 1, max: 2 * baseDelay},
+		{attempt: 2, max: 4 * baseDelay},
+		{attempt: 3, max: maxWait},
+		{attempt: 100, max: maxWait},
+	}
+	for _, tc := range testCases {
+		for i := 0; i < 100; i++ {
+			d := backoff(tc.attempt, maxWait)
+			if d < tc.max/2 || d > tc.max {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", tc.attempt, d, tc.max/2, tc.max)
+			}
+		}
+	}
+}
+
+func TestCanRetry(t *testing.T) {
+	newRequest := func(method string, body io.Reader) *http.Request {
+		req, err := http.NewRequest(method, "http://example.com/", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	withoutGetBody := func(req *http.Request) *http.Request {
+		req.GetBody = nil
+		return req
+	}
+
+	testCases := []struct {
+		name string
+		req  *http.Request
+		want bool
+	}{
+		{name: "GET", req: newRequest(http.MethodGet, nil), want: true},
+		{name: "HEAD", req: newRequest(http.MethodHead, nil), want: true},
+		{name: "DELETE", req: newRequest(http.MethodDelete, nil), want: true},
+		{name: "POST without body", req: newRequest(http.MethodPost, nil), want: false},
+		{name: "PUT with rewindable body", req: newRequest(http.MethodPut, strings.NewReader("data")), want: true},
+		{name: "POST with rewindable body", req: newRequest(http.MethodPost, strings.NewReader("data")), want: true},
+		{name: "PUT with stream", req: withoutGetBody(newRequest(http.MethodPut, strings.NewReader("data"))), want: false},
+		{name: "PATCH with stream", req: newRequest(http.MethodPatch, ioutil.NopCloser(strings.NewReader("data"))), want: false},
+	}
+	for _, tc := range testCases {
+		if got := canRetry(tc.req); got != tc.want {
+			t.Errorf("%s: canRetry = %t, want %t", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	testCases := []struct {
+		err  error
+		want bool
+	}{
+		{err: io.ErrUnexpectedEOF, want: true},
+		{err: fmt.Errorf("read: %w", syscall.ECONNRESET), want: true},
+		{err: fmt.Errorf("dial: %w", syscall.ECONNREFUSED), want: true},
+		{err: errors.New("x509: certificate signed by unknown authority"), want: false},
+	}
+	for _, tc := range testCases {
+		if got := isRetryableError(tc.err); got != tc.want {
+			t.Errorf("isRetryableError(%v) = %t, want %t", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestRetryUnavailable(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "got %s", body)
+	}))
+	defer srv.Close()
+
+	c := &http.Client{
+		Transport: &RoundTripper{
+			Retries: 3,
+			MaxWait: 10 * time.Millisecond,
+		},
+	}
+	resp, err := c.Post(srv.URL, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %s, want 200 OK", resp.Status)
+	}
+	if string(body) != "got payload" {
+		t.Errorf("body = %q, want %q: the request body is not rewound", body, "got payload")
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	c := &http.Client{
+		Transport: &RoundTripper{
+			Retries: 2,
+			MaxWait: time.Millisecond,
+		},
+	}
+	resp, err := c.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status = %s, want 502 Bad Gateway", resp.Status)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c := &http.Client{
+		Transport: &RoundTripper{
+			Retries: 3,
+			MaxWait: time.Second,
+		},
+	}
+	resp, err := c.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("status = %s, want 429 Too Many Requests", resp.Status)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
@@ -0,0 +1,108 @@
+package semver
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "1.2.3", want: "1.2.3"},
+		{in: "v1.2.3", want: "1.2.3"},
+		{in: "1.21", want: "1.21.0"},
+		{in: "v2", want: "2.0.0"},
+		{in: "1.0.0-rc.1", want: "1.0.0-rc.1"},
+		{in: "1.0.0-alpha+build.5", want: "1.0.0-alpha+build.5"},
+		{in: "1.0.0+20220101", want: "1.0.0+20220101"},
+		{in: "latest", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "1.2.3.4", wantErr: true},
+		{in: "01.2.3", wantErr: true},
+		{in: "1..3", wantErr: true},
+		{in: "1.2.3-", wantErr: true},
+		{in: "1.2.3-rc..1", wantErr: true},
+		{in: "vv1.2.3", wantErr: true},
+	}
+	for _, tc := range testCases {
+		v, err := Parse(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q) = %s, want error", tc.in, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %s", tc.in, err)
+			continue
+		}
+		if got := v.String(); got != tc.want {
+			t.Errorf("Parse(%q) = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// The precedence example from the Semantic Versioning specification.
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"v1.0.1",
+		"1.2",
+		"1.10.0",
+		"2",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, err := Parse(ordered[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := Parse(ordered[j])
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got := a.Compare(b); got != want {
+				t.Errorf("%s.Compare(%s) = %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+}
+
+func TestCompareIgnoresBuild(t *testing.T) {
+	a, _ := Parse("1.0.0+a")
+	b, _ := Parse("v1.0.0+b")
+	if c := a.Compare(b); c != 0 {
+		t.Errorf("Compare = %d, want 0", c)
+	}
+}
+
+func TestSort(t *testing.T) {
+	tags := []string{"1.10.0", "1.2.0", "1.10.0-rc.1", "v1.9"}
+	sort.Slice(tags, func(i, j int) bool {
+		a, _ := Parse(tags[i])
+		b, _ := Parse(tags[j])
+		return a.Compare(b) < 0
+	})
+	want := []string{"1.2.0", "v1.9", "1.10.0-rc.1", "1.10.0"}
+	for i := range want {
+		if tags[i] != want[i] {
+			t.Fatalf("sorted tags = %v, want %v", tags, want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Package signature implements simple signing of image manifests in the format
+// that is used by cosign.
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// MediaTypeSimpleSigning is the media type of the layers that contain
+	// simple signing payloads.
+	MediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+
+	// ArtifactType is the artifact type of signatures that are stored as
+	// OCI referrers.
+	ArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+
+	// AnnotationSignature is the layer annotation that contains the
+	// base64-encoded signature of the payload.
+	AnnotationSignature = "dev.cosignproject.cosign/signature"
+
+	payloadType = "cosign container image signature"
+)
+
+// Tag returns the tag that is used to store signatures of the manifest dgst.
+func Tag(dgst digest.Digest) string {
+	return fmt.Sprintf("%s-%s.sig", dgst.Algorithm(), dgst.Encoded())
+}
+
+type Identity struct {
+	DockerReference string `json:"docker-reference"`
+}
+
+type Image struct {
+	DockerManifestDigest digest.Digest `json:"docker-manifest-digest"`
+}
+
+type Critical struct {
+	Identity Identity `json:"identity"`
+	Image    Image    `json:"image"`
+	Type     string   `json:"type"`
+}
+
+// Payload is a simple signing payload.
+type Payload struct {
+	Critical Critical               `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// NewPayload returns the payload for the manifest dgst in the repository
+// dockerReference.
+func NewPayload(dockerReference string, dgst digest.Digest) Payload {
+	return Payload{
+		Critical: Critical{
+			Identity: Identity{
+				DockerReference: dockerReference,
+			},
+			Image: Image{
+				DockerManifestDigest: dgst,
+			},
+			Type: payloadType,
+		},
+	}
+}
+
+// ParsePayload parses the payload and checks its type.
+func ParsePayload(data []byte) (Payload, error) {
+	var p Payload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("decode payload: %s", err)
+	}
+	if p.Critical.Type != payloadType {
+		return p, fmt.Errorf("unexpected payload type %q", p.Critical.Type)
+	}
+	return p, nil
+}
+
+// Sign signs the data with the private key. ECDSA keys produce ASN.1
+// signatures of the SHA-256 hash of the data, ed25519 keys sign the data
+// itself.
+func Sign(key crypto.Signer, data []byte) ([]byte, error) {
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		hash := sha256.Sum256(data)
+		return ecdsa.SignASN1(rand.Reader, key, hash[:])
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, data), nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
+
+// Verify checks that sig is a valid signature of the data.
+func Verify(pub crypto.PublicKey, data []byte, sig []byte) error {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(pub, hash[:], sig) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, data, sig) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported public key type %T", pub)
+}
+
+// GenerateKey generates a private key. The algorithm is either ecdsa-p256 or
+// ed25519.
+func GenerateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case "ecdsa-p256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q (expected ecdsa-p256 or ed25519)", algorithm)
+}
+
+// MarshalPrivateKey encodes the private key as a PKCS #8 PEM block.
+func MarshalPrivateKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// MarshalPublicKey encodes the public key as a PKIX PEM block.
+func MarshalPublicKey(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+func readPEM(filename string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", filename)
+	}
+	return block, nil
+}
+
+// LoadPrivateKey reads an unencrypted PKCS #8, SEC 1 or ed25519 private key
+// from the PEM file.
+func LoadPrivateKey(filename string) (crypto.Signer, error) {
+	block, err := readPEM(filename)
+	if err != nil {
+		return nil, err
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("%s: unsupported private key type %T", filename, key)
+		}
+		return signer, nil
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("%s: unsupported PEM block type %q", filename, block.Type)
+}
+
+// LoadPublicKey reads a PKIX public key from the PEM file.
+func LoadPublicKey(filename string) (crypto.PublicKey, error) {
+	block, err := readPEM(filename)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s: unsupported PEM block type %q", filename, block.Type)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return pub, nil
+}
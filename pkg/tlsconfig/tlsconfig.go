@@ -0,0 +1,107 @@
+// Package tlsconfig builds TLS configurations for registries from CA files,
+// client certificates and certs.d directories.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultCertsDirs returns the certs.d directories in the order of
+// preference. Each of them contains a directory for each registry host
+// (host[:port]) with CA certificates (*.crt) and client certificates (*.cert
+// with matching *.key files).
+func DefaultCertsDirs() []string {
+	var dirs []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, "containers", "certs.d"))
+	}
+	return append(dirs, "/etc/containers/certs.d", "/etc/docker/certs.d")
+}
+
+// Options describes the TLS configuration for a host.
+type Options struct {
+	CAFiles    []string // additional CA certificates
+	CertFile   string   // the client certificate
+	KeyFile    string   // the key for the client certificate
+	SkipVerify bool     // do not verify the server certificate
+}
+
+// AddCertsDir adds the CA and client certificates for the host from the first
+// certs.d directory that has a directory for it.
+func (o *Options) AddCertsDir(certsDirs []string, host string) error {
+	for _, dir := range certsDirs {
+		hostDir := filepath.Join(dir, host)
+		files, err := ioutil.ReadDir(hostDir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		for _, f := range files {
+			name := f.Name()
+			switch {
+			case strings.HasSuffix(name, ".crt"):
+				o.CAFiles = append(o.CAFiles, filepath.Join(hostDir, name))
+			case strings.HasSuffix(name, ".cert"):
+				keyName := strings.TrimSuffix(name, ".cert") + ".key"
+				if _, err := os.Stat(filepath.Join(hostDir, keyName)); err != nil {
+					return fmt.Errorf("missing key %s for client certificate %s", keyName, filepath.Join(hostDir, name))
+				}
+				o.CertFile = filepath.Join(hostDir, name)
+				o.KeyFile = filepath.Join(hostDir, keyName)
+			case strings.HasSuffix(name, ".key"):
+				certName := strings.TrimSuffix(name, ".key") + ".cert"
+				if _, err := os.Stat(filepath.Join(hostDir, certName)); err != nil {
+					return fmt.Errorf("missing client certificate %s for key %s", certName, filepath.Join(hostDir, name))
+				}
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// Config returns the TLS configuration. The CA certificates are added to the
+// system certificate pool.
+func (o *Options) Config() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: o.SkipVerify,
+	}
+
+	if len(o.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, caFile := range o.CAFiles {
+			pem, err := ioutil.ReadFile(caFile)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("%s: no certificates found", caFile)
+			}
+		}
+		config.RootCAs = pool
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, fmt.Errorf("both a client certificate and a key are required")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,45 @@
+package tlsconfig
+
+import (
+	"net/http"
+	"sync"
+)
+
+// HostTransport sends requests using a separate transport for each host, so
+// that hosts can have different TLS configurations.
+type HostTransport struct {
+	// NewTransport returns the transport for the host (host[:port]).
+	NewTransport func(host string) (http.RoundTripper, error)
+
+	mu         sync.Mutex
+	transports map[string]http.RoundTripper
+}
+
+func (t *HostTransport) transport(host string) (http.RoundTripper, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if rt, ok := t.transports[host]; ok {
+		return rt, nil
+	}
+	rt, err := t.NewTransport(host)
+	if err != nil {
+		return nil, err
+	}
+	if t.transports == nil {
+		t.transports = map[string]http.RoundTripper{}
+	}
+	t.transports[host] = rt
+	return rt, nil
+}
+
+func (t *HostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt, err := t.transport(req.URL.Host)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return rt.RoundTrip(req)
+}